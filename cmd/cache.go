@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd represents the cache command, a parent to its stats and clear subcommands
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local cache of web log objects",
+	Long: `Reports on and clears the local cache of web log objects kept by commands run with
+the --cache flag. Log objects never change once AWS has written them, so the cached
+copies can be read in place of S3 until they are removed, either to keep the cache
+within --cache-max-size or with the clear subcommand. The cache lives in --cache-dir.`,
+}
+
+// cacheStatsCmd represents the cache stats subcommand
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Display the number and size of the cached log objects",
+	Long:  `Displays the number of log objects cached, and their total size, for each log bucket.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCache(func(cache *s3.LogCache) error {
+			return s3.DisplayCacheStats(cache)
+		})
+	},
+}
+
+// cacheClearCmd represents the cache clear subcommand
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [log-bucket]",
+	Short: "Remove cached log objects",
+	Long: `Removes the cached log objects of the given log bucket or, if no bucket is given,
+every cached log object.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bucket := ""
+		if len(args) > 0 {
+			bucket = args[0]
+		}
+		return runCache(func(cache *s3.LogCache) error {
+			return s3.ClearCache(cache, bucket)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
+
+// runCache does the work common to the cache subcommands: validating the cache directory
+// and invoking the cache operation unless we are unit testing.
+func runCache(operation func(cache *s3.LogCache) error) error {
+
+	// There must be somewhere to look
+	if len(cacheDir) == 0 {
+		return errors.New("A cache directory must be provided with --cache-dir")
+	}
+
+	// The cache subcommands work on the cache whether or not --cache was given
+	logCache = &s3.LogCache{Dir: cacheDir, MaxSize: cacheMaxSize}
+
+	// Go ahead and do the work unless we are unit testing
+	if unitTesting {
+		return nil
+	}
+	return operation(logCache)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+// Unit tests for the cache command and flags
+
+import (
+	"testing"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCacheFlags confirms that the cache flags are passed on to the commands that read log objects
+func TestCacheFlags(t *testing.T) {
+
+	// No cache unless asked for
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Nil(t, slogSession.Cache, "There should be no cache by default")
+
+	// Asked for, with the default size limit
+	executeCommand("read", "bucket", "--cache", "--cache-dir", "/tmp/slog-cache")
+	require.Nil(t, executeError, "error seen parsing cache flags")
+	require.Equal(t, &s3.LogCache{Dir: "/tmp/slog-cache", MaxSize: 1 << 30}, slogSession.Cache, "Cache set incorrectly")
+
+	// On another command, with an explicit size limit
+	executeCommand("latency", "bucket", "--cache", "--cache-dir", "/tmp/slog-cache", "--cache-max-size", "500M")
+	require.Nil(t, executeError, "error seen parsing cache flags")
+	require.Equal(t, &s3.LogCache{Dir: "/tmp/slog-cache", MaxSize: 500 << 20}, slogSession.Cache, "Cache set incorrectly")
+
+	// A bad size
+	executeCommand("read", "bucket", "--cache-max-size", "lots")
+	require.NotNil(t, executeError, "an invalid cache size should have been rejected")
+	require.Equal(t, "Invalid cache size: Cannot parse size lots", executeError.Error(), "Expected invalid cache size error")
+
+	// No directory
+	executeCommand("read", "bucket", "--cache", "--cache-dir", "")
+	require.NotNil(t, executeError, "a cache without a directory should have been rejected")
+	require.Equal(t, "A cache directory must be provided with --cache-dir", executeError.Error(), "Expected cache directory required error")
+}
+
+// TestCacheCommand confirms that the cache subcommands work on the cache directory whether or not
+// --cache is given
+func TestCacheCommand(t *testing.T) {
+	for _, subcommand := range []string{"stats", "clear"} {
+		executeCommand("cache", subcommand, "--cache-dir", "/tmp/slog-cache", "--cache-max-size", "2K")
+		require.Nil(t, executeError, "error seen parsing cache %s command line", subcommand)
+		require.Equal(t, &s3.LogCache{Dir: "/tmp/slog-cache", MaxSize: 2048}, logCache, "Cache set incorrectly for cache %s", subcommand)
+	}
+}
+
+// TestParseByteSize examines the parsing of sizes with and without suffixes
+func TestParseByteSize(t *testing.T) {
+	for sstr, expected := range map[string]int64{"0": 0, "1024": 1024, "2K": 2048, "3MB": 3 << 20, "1g": 1 << 30} {
+		size, err := parseByteSize(sstr)
+		require.Nil(t, err, "Failed to parse size %s", sstr)
+		require.Equal(t, expected, size, "Size %s parsed incorrectly", sstr)
+	}
+	for _, sstr := range []string{"", "K", "-1", "1T", "lots"} {
+		_, err := parseByteSize(sstr)
+		require.NotNil(t, err, "Size %s should not have parsed", sstr)
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 // Unit tests for the Cobra command line parsers
 
 import (
+	"os"
 	"testing"
 	"time"
 
@@ -204,4 +205,254 @@ func TestReadCommandContentTypes(t *testing.T) {
 	executeCommand("read", "bucket", "--content", "raw")
 	require.Nil(t, executeError, "raw should have been an acceptable content type")
 	require.Equal(t, s3.RAW, slogSession.Content, "SlogSession not populated with the right content type")
+
+	// Run the command specifying the JSON content type
+	executeCommand("read", "bucket", "--content", "json")
+	require.Nil(t, executeError, "json should have been an acceptable content type")
+	require.Equal(t, s3.JSON, slogSession.Content, "SlogSession not populated with the right content type")
+}
+
+// TestReadCommandBotFilters checks that the bot filtering flags are reflected in the session
+func TestReadCommandBotFilters(t *testing.T) {
+
+	// By default, everything is included
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Equal(t, s3.ALLTRAFFIC, slogSession.BotFilter, "Default bot filter set incorrectly")
+	require.Empty(t, slogSession.BotSignatures, "Default bot signature file set incorrectly")
+
+	// Excluding bots with our own signatures
+	executeCommand("read", "bucket", "--exclude-bots", "--bot-signatures", "my-bots.txt")
+	require.Nil(t, executeError, "error seen parsing --exclude-bots")
+	require.Equal(t, s3.EXCLUDEBOTS, slogSession.BotFilter, "Excluding bot filter set incorrectly")
+	require.Equal(t, "my-bots.txt", slogSession.BotSignatures, "Bot signature file set incorrectly")
+
+	// Only bots
+	executeCommand("read", "bucket", "--only-bots")
+	require.Nil(t, executeError, "error seen parsing --only-bots")
+	require.Equal(t, s3.ONLYBOTS, slogSession.BotFilter, "Only bots filter set incorrectly")
+
+	// But not both
+	executeCommand("read", "bucket", "--only-bots", "--exclude-bots")
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "Only one of --exclude-bots and --only-bots may be given", executeError.Error(), "Expected conflicting bot flags error")
+}
+
+// TestReadCommandCheckpoint checks that the checkpoint file is passed on in the session
+func TestReadCommandCheckpoint(t *testing.T) {
+
+	// By default, there is no checkpointing
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Empty(t, slogSession.Checkpoint, "Checkpointing should be off by default")
+
+	// But it can be asked for
+	executeCommand("read", "bucket", "--checkpoint", "read.json")
+	require.Nil(t, executeError, "error seen parsing --checkpoint")
+	require.Equal(t, "read.json", slogSession.Checkpoint, "Checkpoint file set incorrectly")
+}
+
+// TestAggregateCommandCheckpoint checks that the checkpoint file is passed on in the session by
+// the commands that aggregate log entries and by purge
+func TestAggregateCommandCheckpoint(t *testing.T) {
+	for _, args := range [][]string{
+		{"sessions", "bucket"},
+		{"latency", "bucket"},
+		{"histogram", "bucket"},
+		{"report", "bucket"},
+		{"404s", "bucket"},
+		{"purge", "bucket", "--ip", "192.0.2.1"},
+	} {
+
+		// By default, there is no checkpointing
+		executeCommand(args...)
+		require.Nil(t, executeError, "error seen parsing minimum %s command line", args[0])
+		require.Empty(t, slogSession.Checkpoint, "Checkpointing should be off by default for %s", args[0])
+
+		// But it can be asked for
+		executeCommand(append(args, "--checkpoint", "aggregate.json")...)
+		require.Nil(t, executeError, "error seen parsing %s --checkpoint", args[0])
+		require.Equal(t, "aggregate.json", slogSession.Checkpoint, "Checkpoint file set incorrectly for %s", args[0])
+	}
+}
+
+// TestRetryFlags checks that the retry policy flags shared by every command reach the session
+func TestRetryFlags(t *testing.T) {
+
+	// The defaults
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Equal(t, s3.RetryPolicy{Retries: 5, MaxWait: 20 * time.Second}, slogSession.Retry, "Default retry policy set incorrectly")
+
+	// Explicit values, on another command
+	executeCommand("histogram", "bucket", "--retries", "2", "--retry-max-wait", "1m", "--skip-missing")
+	require.Nil(t, executeError, "error seen parsing retry flags")
+	require.Equal(t, s3.RetryPolicy{Retries: 2, MaxWait: time.Minute, SkipMissing: true}, slogSession.Retry, "Retry policy set incorrectly")
+
+	// A bad wait
+	executeCommand("read", "bucket", "--retry-max-wait", "forever")
+	require.NotNil(t, executeError, "an invalid retry wait should have been rejected")
+	require.Equal(t, "Invalid retry wait: Cannot parse time window length", executeError.Error(), "Expected invalid retry wait error")
+}
+
+// TestReadCommandAnonymize examines the parsing of the anonymization flags
+func TestReadCommandAnonymize(t *testing.T) {
+
+	// No anonymization by default
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Equal(t, s3.NOANONYMIZE, slogSession.Anonymize, "Anonymization should be off by default")
+
+	// Truncation needs no key
+	executeCommand("read", "bucket", "--anonymize", "truncate", "--content", "raw")
+	require.Nil(t, executeError, "error seen parsing truncate anonymization")
+	require.Equal(t, s3.TRUNCATE, slogSession.Anonymize, "Anonymization set incorrectly")
+
+	// Hashing does
+	executeCommand("read", "bucket", "--anonymize", "hash")
+	require.NotNil(t, executeError, "hash anonymization without a key should have been rejected")
+	require.Equal(t, "An anonymization key must be provided with --anonymize-key", executeError.Error(), "Expected key required error")
+	executeCommand("read", "bucket", "--anonymize", "hash", "--anonymize-key", "secret")
+	require.Nil(t, executeError, "error seen parsing hash anonymization")
+	require.Equal(t, s3.HASH, slogSession.Anonymize, "Anonymization set incorrectly")
+	require.Equal(t, "secret", slogSession.AnonymizeKey, "Anonymization key set incorrectly")
+
+	// Nothing else will do
+	executeCommand("read", "bucket", "--anonymize", "scramble")
+	require.NotNil(t, executeError, "an unknown anonymization should have been rejected")
+	require.Equal(t, "Unrecognized anonymization: scramble", executeError.Error(), "Expected unrecognized anonymization error")
+}
+
+// TestReadCommandGeoIP examines the parsing of the GeoIP database and country flags
+func TestReadCommandGeoIP(t *testing.T) {
+
+	// No databases and no country filter by default
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Empty(t, slogSession.GeoIPDatabases, "There should be no GeoIP databases by default")
+	require.Empty(t, slogSession.Countries, "There should be no country filter by default")
+
+	// Countries are normalized to upper case ISO codes
+	executeCommand("read", "bucket", "--geoip-db", "GeoLite2-City.mmdb", "--geoip-db", "GeoLite2-ASN.mmdb", "--country", "de,fr", "--country", "US")
+	require.Nil(t, executeError, "error seen parsing GeoIP flags")
+	require.Equal(t, []string{"GeoLite2-City.mmdb", "GeoLite2-ASN.mmdb"}, slogSession.GeoIPDatabases, "GeoIP databases set incorrectly")
+	require.Equal(t, []string{"DE", "FR", "US"}, slogSession.Countries, "Countries set incorrectly")
+
+	// Countries cannot be known without a database
+	executeCommand("read", "bucket", "--country", "DE")
+	require.NotNil(t, executeError, "a country filter without a GeoIP database should have been rejected")
+	require.Equal(t, "A GeoIP database must be provided with --geoip-db to filter by country", executeError.Error(), "Expected GeoIP database required error")
+}
+
+// TestReadCommandResolve examines the parsing of the host name lookup flags
+func TestReadCommandResolve(t *testing.T) {
+
+	// No lookups by default
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.Nil(t, slogSession.Resolve, "Host names should not be looked up by default")
+
+	// The default settings
+	executeCommand("read", "bucket", "--resolve")
+	require.Nil(t, executeError, "error seen parsing resolve flag")
+	require.NotNil(t, slogSession.Resolve, "Host names should have been requested")
+	require.Equal(t, 8, slogSession.Resolve.Workers, "Default workers set incorrectly")
+	require.Equal(t, 50, slogSession.Resolve.Rate, "Default rate set incorrectly")
+	require.Equal(t, 2*time.Second, slogSession.Resolve.Timeout, "Default timeout set incorrectly")
+	require.Equal(t, 30*time.Second, slogSession.Resolve.Budget, "Default budget set incorrectly")
+	require.Equal(t, 7*24*time.Hour, slogSession.Resolve.TTL, "Default TTL set incorrectly")
+
+	// Explicit values
+	executeCommand("read", "bucket", "--resolve", "--resolve-workers", "2", "--resolve-rate", "0", "--resolve-timeout", "1s",
+		"--resolve-budget", "5m", "--resolve-ttl", "1d", "--resolve-cache", "hosts.json", "--content", "json")
+	require.Nil(t, executeError, "error seen parsing resolve flags")
+	require.Equal(t, s3.ResolvePolicy{Workers: 2, Timeout: time.Second, Budget: 5 * time.Minute, TTL: 24 * time.Hour, CacheFile: "hosts.json"},
+		*slogSession.Resolve, "Resolve policy set incorrectly")
+
+	// A bad budget
+	executeCommand("read", "bucket", "--resolve", "--resolve-budget", "forever")
+	require.NotNil(t, executeError, "an invalid budget should have been rejected")
+	require.Equal(t, "Invalid resolve budget: Cannot parse time window length", executeError.Error(), "Expected invalid budget error")
+
+	// Host names would undo anonymization, and have no place in raw content
+	executeCommand("read", "bucket", "--resolve", "--anonymize", "truncate")
+	require.NotNil(t, executeError, "resolving anonymized entries should have been rejected")
+	require.Equal(t, "Host names cannot be looked up for anonymized entries", executeError.Error(), "Expected anonymized error")
+	executeCommand("read", "bucket", "--resolve", "--content", "raw")
+	require.NotNil(t, executeError, "resolving raw content should have been rejected")
+	require.Equal(t, "Host names cannot be added to raw content", executeError.Error(), "Expected raw content error")
+}
+
+// TestReadCommandSources examines the parsing of log bucket folders given with --from
+func TestReadCommandSources(t *testing.T) {
+
+	// Several sources, with and without paths and source buckets
+	executeCommand("read", "--path", "root/", "--from", "log.example.com/blog=blog.example.com,www.example.com",
+		"--from", "log.example.com", "--from", "log.example.net/shop/")
+	require.Nil(t, executeError, "error seen parsing from flags: %v", executeError)
+	require.Equal(t, []s3.LogSource{
+		{LogBucket: "log.example.com", Folder: "blog", SourceBuckets: []string{"blog.example.com", "www.example.com"}},
+		{LogBucket: "log.example.com", Folder: "root"},
+		{LogBucket: "log.example.net", Folder: "shop"},
+	}, slogSession.Sources, "Sources set incorrectly")
+	require.Empty(t, slogSession.LogBucket, "No single log bucket should have been set")
+
+	// Not as well as arguments
+	executeCommand("read", "bucket", "--from", "log.example.com")
+	require.NotNil(t, executeError, "sources given both ways should have been rejected")
+	require.Equal(t, "Log buckets must be given either as arguments or with --from, not both", executeError.Error(),
+		"Expected sources given both ways error")
+
+	// Nor without a bucket
+	executeCommand("read", "--from", "/blog")
+	require.NotNil(t, executeError, "a source without a bucket should have been rejected")
+	require.Equal(t, "Invalid source: /blog", executeError.Error(), "Expected invalid source error")
+}
+
+// TestReadCommandColor examines the parsing of the color flags, and when color is used by default
+func TestReadCommandColor(t *testing.T) {
+	defer func(original func() bool) { stdoutIsTerminal = original }(stdoutIsTerminal)
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	os.Unsetenv("NO_COLOR")
+
+	// Piped output stays plain
+	stdoutIsTerminal = func() bool { return false }
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.False(t, slogSession.Color, "Piped output should not have been colored")
+	require.Equal(t, time.Second, slogSession.SlowRequest, "Default slow time set incorrectly")
+
+	// Terminals are colored, unless NO_COLOR says otherwise
+	stdoutIsTerminal = func() bool { return true }
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.True(t, slogSession.Color, "Terminal output should have been colored")
+	os.Setenv("NO_COLOR", "1")
+	executeCommand("read", "bucket")
+	require.Nil(t, executeError, "error seen parsing minimum read command line")
+	require.False(t, slogSession.Color, "NO_COLOR should have been respected")
+
+	// The flag has the last word
+	executeCommand("read", "bucket", "--color", "always", "--slow", "250ms")
+	require.Nil(t, executeError, "error seen parsing color flags")
+	require.True(t, slogSession.Color, "Output should always have been colored")
+	require.Equal(t, 250*time.Millisecond, slogSession.SlowRequest, "Slow time set incorrectly")
+	os.Unsetenv("NO_COLOR")
+	executeCommand("read", "bucket", "--color", "never")
+	require.Nil(t, executeError, "error seen parsing color flags")
+	require.False(t, slogSession.Color, "Output should never have been colored")
+
+	// Bad values
+	executeCommand("read", "bucket", "--color", "sometimes")
+	require.NotNil(t, executeError, "an unknown color setting should have been rejected")
+	require.Equal(t, "Unrecognized color: sometimes", executeError.Error(), "Expected unknown color error")
+	executeCommand("read", "bucket", "--slow", "soon")
+	require.NotNil(t, executeError, "an invalid slow time should have been rejected")
+	require.Contains(t, executeError.Error(), "Invalid slow time", "Expected invalid slow time error")
+	executeCommand("read", "bucket", "--slow", "0s")
+	require.NotNil(t, executeError, "a zero slow time should have been rejected")
+	require.Equal(t, "Invalid slow time: 0s is not greater than zero", executeError.Error(), "Expected zero slow time error")
+	executeCommand("read", "bucket", "--slow", "-1s")
+	require.NotNil(t, executeError, "a negative slow time should have been rejected")
+	require.Equal(t, "Invalid slow time: -1s is not greater than zero", executeError.Error(), "Expected negative slow time error")
 }
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// The profile selected with --profile-name, empty if there is none
+	activeProfile profile
+)
+
+// slogConfig is the layout of the YAML configuration file
+type slogConfig struct {
+	Profiles map[string]profile `yaml:"profiles"` // The named profiles, selected with --profile-name
+}
+
+// profile is a named set of default settings, each of which is overridden by its flag when
+// the flag is given
+type profile struct {
+	Bucket        string   `yaml:"bucket"`         // The log bucket name, used when no bucket is given as an argument
+	SourceBuckets []string `yaml:"source-buckets"` // The source buckets to filter for, used along with the bucket
+	Path          string   `yaml:"path"`           // The log folder path within the bucket
+	Region        string   `yaml:"region"`         // The AWS region to target
+	Endpoint      string   `yaml:"endpoint"`       // The URL of an S3 compatible service to use in place of AWS
+	Content       string   `yaml:"content"`        // The content to include in the read command output
+	TimeZone      string   `yaml:"timezone"`       // The time zone of --start values given without an offset
+}
+
+// effectiveConfig is the layout in which the config show subcommand displays the settings in force
+type effectiveConfig struct {
+	Config  string `yaml:"config"`  // The configuration file
+	Profile string `yaml:"profile"` // The name of the selected profile
+	profile `yaml:",inline"`
+}
+
+// configCmd represents the config command, a parent to its show subcommand
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the slog configuration file",
+	Long: `The configuration file, ~/.config/slog/config.yaml unless --config says otherwise,
+defines named profiles of default settings so that they need not be repeated on every
+command line. A profile is selected with --profile-name and is written in YAML as follows:
+
+  profiles:
+    blog:
+      bucket: log.example.com
+      source-buckets: [example.com, www.example.com]
+      path: root
+      region: us-west-2
+      endpoint: https://s3.example.net
+      content: rich
+      timezone: Europe/Berlin
+
+The bucket and source buckets are used when no buckets are given as arguments, and
+each other setting is used unless its flag is given.`,
+}
+
+// configShowCmd represents the config show subcommand
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Display the settings in force",
+	Long: `Displays the settings in force once the selected profile, if there is one, and any
+flags given have been taken into account.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// The profile has already been applied to the flag values
+		settings := effectiveConfig{
+			Config:  configFile,
+			Profile: profileName,
+			profile: profile{
+				Bucket:        activeProfile.Bucket,
+				SourceBuckets: activeProfile.SourceBuckets,
+				Path:          path,
+				Region:        region,
+				Endpoint:      endpoint,
+				Content:       activeProfile.Content,
+				TimeZone:      timeZone.String(),
+			},
+		}
+		if len(settings.Content) == 0 {
+			settings.Content = "basic"
+		}
+
+		// A struct of strings cannot fail to marshal
+		content, _ := yaml.Marshal(settings)
+		fmt.Fprint(cmd.OutOrStdout(), string(content))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
+
+// defaultConfigFile returns the configuration file read unless the user asks otherwise, or
+// an empty string if the user has no home directory.
+func defaultConfigFile() string {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".config", "slog", "config.yaml")
+}
+
+// applyProfile loads the profile selected with --profile-name, if there is one, from the
+// configuration file and uses its settings in place of the defaults of any flags of the
+// command that were not given.
+func applyProfile(cmd *cobra.Command) error {
+
+	// Without a profile, the configuration file is not needed
+	activeProfile = profile{}
+	if len(profileName) == 0 {
+		return nil
+	}
+
+	// Find the profile
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read config file: %w", err)
+	}
+	config := slogConfig{}
+	err = yaml.UnmarshalStrict(content, &config)
+	if err != nil {
+		return fmt.Errorf("Invalid config file: %w", err)
+	}
+	p, ok := config.Profiles[profileName]
+	if !ok {
+		return fmt.Errorf("Unrecognized profile: %s", profileName)
+	}
+	activeProfile = p
+
+	// Flags that were given win over the profile
+	useProfileValue(cmd, "path", &path, p.Path)
+	useProfileValue(cmd, "region", &region, p.Region)
+	useProfileValue(cmd, "endpoint", &endpoint, p.Endpoint)
+	useProfileValue(cmd, "content", &contentTypeStr, p.Content)
+	useProfileValue(cmd, "timezone", &timeZoneStr, p.TimeZone)
+	return nil
+}
+
+// useProfileValue sets a flag value from the profile, if the profile has one for it and the
+// command has the flag but it was not given
+func useProfileValue(cmd *cobra.Command, name string, value *string, profileValue string) {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil || flag.Changed || len(profileValue) == 0 {
+		return
+	}
+	*value = profileValue
+}
+
+// profileArgs returns the arguments of a command that takes a log bucket followed by source
+// buckets, taking them from the profile if none were given
+func profileArgs(args []string) []string {
+	if len(args) > 0 || len(activeProfile.Bucket) == 0 {
+		return args
+	}
+	return append([]string{activeProfile.Bucket}, activeProfile.SourceBuckets...)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+// Unit tests for the configuration file, its profiles, and the config command
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestConfig writes a configuration file with a blog profile to a temporary location,
+// returning its name
+func writeTestConfig(t *testing.T) string {
+	file, err := ioutil.TempFile("", "slog-config-*.yaml")
+	require.Nil(t, err, "failed to create temporary config file: %v", err)
+	_, err = file.WriteString(`profiles:
+  blog:
+    bucket: log.example.com
+    source-buckets: [example.com]
+    path: blog
+    region: us-west-2
+    endpoint: https://s3.example.net
+    content: rich
+    timezone: Europe/Berlin
+`)
+	file.Close()
+	require.Nil(t, err, "failed to write temporary config file: %v", err)
+	return file.Name()
+}
+
+// TestProfile confirms that profile settings are applied, and that flags override them
+func TestProfile(t *testing.T) {
+	config := writeTestConfig(t)
+	defer os.Remove(config)
+
+	// Everything from the profile
+	executeCommand("read", "--config", config, "--profile-name", "blog", "--start", "2020-03-20T14:00:00")
+	require.Nil(t, executeError, "error seen applying profile: %v", executeError)
+	require.Equal(t, "log.example.com", slogSession.LogBucket, "Log bucket not taken from the profile")
+	require.Equal(t, []string{"example.com"}, slogSession.SourceBuckets, "Source buckets not taken from the profile")
+	require.Equal(t, "blog", slogSession.Folder, "Path not taken from the profile")
+	require.Equal(t, "us-west-2", slogSession.Region, "Region not taken from the profile")
+	require.Equal(t, "https://s3.example.net", slogSession.Endpoint, "Endpoint not taken from the profile")
+	require.Equal(t, s3.RICH, slogSession.Content, "Content not taken from the profile")
+	require.Equal(t, time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC), slogSession.StartDateTime.UTC(),
+		"Start time should have been in the profile time zone")
+
+	// Flags and arguments win
+	executeCommand("read", "other-bucket", "--config", config, "--profile-name", "blog", "--region", "eu-west-1",
+		"--path", "root", "--content", "basic", "--timezone", "UTC", "--start", "2020-03-20T14:00:00")
+	require.Nil(t, executeError, "error seen applying profile: %v", executeError)
+	require.Equal(t, "other-bucket", slogSession.LogBucket, "Log bucket argument should have overridden the profile")
+	require.Empty(t, slogSession.SourceBuckets, "Profile source buckets should not apply to another bucket")
+	require.Equal(t, "root", slogSession.Folder, "Path flag should have overridden the profile")
+	require.Equal(t, "eu-west-1", slogSession.Region, "Region flag should have overridden the profile")
+	require.Equal(t, s3.BASIC, slogSession.Content, "Content flag should have overridden the profile")
+	require.Equal(t, time.Date(2020, time.March, 20, 14, 0, 0, 0, time.UTC), slogSession.StartDateTime.UTC(),
+		"Time zone flag should have overridden the profile")
+
+	// Profiles that are not there
+	executeCommand("read", "--config", config, "--profile-name", "shop")
+	require.NotNil(t, executeError, "an unknown profile should have been rejected")
+	require.Equal(t, "Unrecognized profile: shop", executeError.Error(), "Expected unknown profile error")
+	executeCommand("read", "--config", "/there/is/no/such/config.yaml", "--profile-name", "blog")
+	require.NotNil(t, executeError, "a missing config file should have been reported")
+	require.Contains(t, executeError.Error(), "Unable to read config file", "Expected missing config file error")
+
+	// Without a profile, the bucket is still required
+	executeCommand("read", "--config", config)
+	require.NotNil(t, executeError, "a missing bucket should have been reported")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestConfigShow confirms that the effective settings are displayed
+func TestConfigShow(t *testing.T) {
+	config := writeTestConfig(t)
+	defer os.Remove(config)
+
+	output := executeCommand("config", "show", "--config", config, "--profile-name", "blog", "--region", "eu-west-1")
+	require.Nil(t, executeError, "error seen showing config: %v", executeError)
+	require.Contains(t, output, "profile: blog\n", "Profile name not shown")
+	require.Contains(t, output, "bucket: log.example.com\n", "Bucket not shown")
+	require.Contains(t, output, "path: blog\n", "Path not shown")
+	require.Contains(t, output, "region: eu-west-1\n", "Region flag should have overridden the profile")
+	require.Contains(t, output, "timezone: Europe/Berlin\n", "Time zone not shown")
+
+	// The defaults
+	output = executeCommand("config", "show", "--config", config)
+	require.Nil(t, executeError, "error seen showing config: %v", executeError)
+	require.Contains(t, output, "region: us-east-1\n", "Default region not shown")
+	require.Contains(t, output, "content: basic\n", "Default content not shown")
+	require.Contains(t, output, "timezone: UTC\n", "Default time zone not shown")
+}
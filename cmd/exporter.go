@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	metricsAddr    string        // flag value giving the address on which to serve the metrics
+	checkpointFile string        // flag value naming the file in which progress is checkpointed
+	pollStr        string        // flag value defining how often to poll for new log objects
+	pollInterval   time.Duration // how often to poll for new log objects
+)
+
+// exporterCmd represents the exporter command
+var exporterCmd = &cobra.Command{
+	Use:   "exporter log-bucket [source-bucket*]",
+	Short: "Export the traffic found in S3 hosted web logs as Prometheus metrics",
+	Long: `Continuously tails the S3 hosted web logs in a specified bucket and serves counts of
+the requests recorded, by bucket, operation and HTTP status, the bytes sent, and a
+histogram of the Total Time of requests, in the Prometheus text format at /metrics.
+Optionally, filters the log data to only include those entries that match the list of
+source buckets.
+
+The counters and the key of the last log object counted are saved to the --checkpoint
+file after every object so that a restarted exporter carries on counting from where it
+left off. Until the checkpoint file exists, counting starts from the current time. Give
+an empty --checkpoint "" to run without one, always counting from the current time.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the polling interval
+		var err error
+		pollInterval, err = parseTimeWindow(pollStr)
+		if err != nil {
+			return fmt.Errorf("Invalid poll interval: %w", err)
+		}
+		if pollInterval <= 0 {
+			return fmt.Errorf("Invalid poll interval: %s is not greater than zero", pollStr)
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:        region,
+			Endpoint:      endpoint,
+			Retry:         retryPolicy,
+			Cache:         logCache,
+			LogBucket:     args[0],
+			Folder:        path,
+			SourceBuckets: args[1:],
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.RunExporter(slogSession, metricsAddr, checkpointFile, pollInterval)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+
+	// Initialize the flags that apply to the exporter command
+	initExporterFlags()
+}
+
+// initExporterFlags is called from init() to define the flags that apply to the exporter
+// command. It is defined separately from init() so that it can be invoked by unit tests when
+// they need to reset the playing field.
+func initExporterFlags() {
+
+	// Local flag definitions
+	exporterCmd.Flags().StringVar(&metricsAddr, "listen", ":9180",
+		`The address and port on which to serve the metrics`)
+	exporterCmd.Flags().StringVar(&checkpointFile, "checkpoint", "slog-exporter.json",
+		`The file in which to save the counters and the last log object counted, or ""
+for none`)
+	exporterCmd.Flags().StringVar(&pollStr, "poll", "1m",
+		`How often to look for new log objects, in days (d), hours (h), minutes (m)
+or seconds (s)`)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+// Unit tests for the exporter command line parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareExporterCommand examines the case where an exporter command is requested
+// but no parameters are provided
+func TestBareExporterCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("exporter")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestExporterCommand confirms that the listen address, checkpoint and poll interval are parsed
+func TestExporterCommand(t *testing.T) {
+
+	// The defaults
+	executeCommand("exporter", "my-bucket", "source-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum exporter command line")
+	require.Equal(t, ":9180", metricsAddr, "Default listen address set incorrectly")
+	require.Equal(t, "slog-exporter.json", checkpointFile, "Default checkpoint file set incorrectly")
+	require.Equal(t, time.Minute, pollInterval, "Default poll interval set incorrectly")
+	require.Equal(t, []string{"source-bucket"}, slogSession.SourceBuckets, "Source buckets set incorrectly")
+
+	// Explicit values
+	executeCommand("exporter", "my-bucket", "--listen", "127.0.0.1:9999", "--checkpoint", "state.json", "--poll", "30s")
+	require.Nil(t, executeError, "error seen parsing exporter command line")
+	require.Equal(t, "127.0.0.1:9999", metricsAddr, "Listen address set incorrectly")
+	require.Equal(t, "state.json", checkpointFile, "Checkpoint file set incorrectly")
+	require.Equal(t, 30*time.Second, pollInterval, "Poll interval set incorrectly")
+
+	// No checkpoint at all
+	executeCommand("exporter", "my-bucket", "--checkpoint", "")
+	require.Nil(t, executeError, "error seen parsing exporter command line without a checkpoint")
+	require.Equal(t, "", checkpointFile, "An empty checkpoint file should have been accepted")
+
+	// A bad poll interval
+	executeCommand("exporter", "my-bucket", "--poll", "often")
+	require.NotNil(t, executeError, "an invalid poll interval should have been rejected")
+	require.Equal(t, "Invalid poll interval: Cannot parse time window length", executeError.Error(), "Expected invalid poll interval error")
+	executeCommand("exporter", "my-bucket", "--poll", "0s")
+	require.NotNil(t, executeError, "a zero poll interval should have been rejected")
+	require.Equal(t, "Invalid poll interval: 0s is not greater than zero", executeError.Error(), "Expected zero poll interval error")
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	maxGapStr   string // flag value defining the longest tolerable period without logs
+	maxDelayStr string // flag value defining the longest tolerable log delivery delay
+	healthSince string // flag value defining how far back from now the health check looks
+
+	// The health thresholds parsed from the flags, held as a global so that
+	// they can be checked by unit test code
+	healthThresholds s3.HealthThresholds
+)
+
+// healthCmd represents the health command
+var healthCmd = &cobra.Command{
+	Use:   "health log-bucket",
+	Short: "Check S3 hosted web logs for delivery gaps and delays",
+	Long: `Given a start date and time, together with a time window, examines the web log
+objects in a bucket to report how long after the traffic they record each was delivered,
+as percentiles, and any periods without logs longer than the --max-gap threshold.
+
+Exits with a non-zero status if the 99th percentile delivery delay exceeds --max-delay
+or any gap exceeds --max-gap, making it suitable for running from cron. Given --since
+instead of --start and --window, the check covers the time from that long ago until now,
+so that the same command line checks the latest logs every time it is run.
+
+The most recent --max-delay of the window is not checked for gaps, since logs for that
+traffic may not have been delivered yet.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window, or how long up to now to cover
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+		if len(healthSince) > 0 {
+			window, err = parseTimeWindow(healthSince)
+			if err != nil {
+				return fmt.Errorf("Invalid since: %w", err)
+			}
+			if window <= 0 {
+				return fmt.Errorf("Invalid since: %s is not greater than zero", healthSince)
+			}
+			startDateTime = time.Now().UTC().Add(-window).Truncate(time.Second)
+		}
+
+		// Parse the thresholds
+		healthThresholds.MaxGap, err = parseTimeWindow(maxGapStr)
+		if err != nil {
+			return fmt.Errorf("Invalid maximum gap: %w", err)
+		}
+		healthThresholds.MaxDelay, err = parseTimeWindow(maxDelayStr)
+		if err != nil {
+			return fmt.Errorf("Invalid maximum delay: %w", err)
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:        region,
+			Endpoint:      endpoint,
+			Retry:         retryPolicy,
+			LogBucket:     args[0],
+			Folder:        path,
+			StartDateTime: startDateTime,
+			EndDateTime:   startDateTime.Add(window),
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		healthy := true
+		if !unitTesting {
+			healthy, err = s3.CheckHealth(slogSession, healthThresholds)
+		}
+		if err != nil {
+			return err
+		}
+
+		// An unhealthy result is reported as an error so that we exit with a non-zero status
+		if !healthy {
+			return errors.New("Log delivery health thresholds breached")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(healthCmd)
+
+	// Initialize the flags that apply to the health command
+	initHealthFlags()
+}
+
+// initHealthFlags is called from init() to define the flags that apply to the health command.
+// It is defined separately from init() so that it can be invoked by unit tests when they need
+// to reset the playing field.
+func initHealthFlags() {
+
+	// Local flag definitions
+	addWindowFlags(healthCmd)
+	healthCmd.Flags().StringVar(&maxGapStr, "max-gap", "1h",
+		`The longest tolerable period without any logs, in days (d), hours (h),
+minutes (m) or seconds (s)`)
+	healthCmd.Flags().StringVar(&maxDelayStr, "max-delay", "2h",
+		`The longest tolerable 99th percentile log delivery delay, in days (d),
+hours (h), minutes (m) or seconds (s)`)
+	healthCmd.Flags().StringVar(&healthSince, "since", "",
+		`Check the time from this long ago until now, in days (d), hours (h), minutes (m)
+or seconds (s); overrides --start and --window`)
+}
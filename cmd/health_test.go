@@ -0,0 +1,66 @@
+package cmd
+
+// Unit tests for the health command line parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareHealthCommand examines the case where a health command is requested
+// but no parameters are provided
+func TestBareHealthCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("health")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestHealthThresholds confirms that the threshold flags are parsed correctly
+func TestHealthThresholds(t *testing.T) {
+
+	// The defaults
+	executeCommand("health", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum health command line")
+	require.Equal(t, time.Hour, healthThresholds.MaxGap, "Default maximum gap set incorrectly")
+	require.Equal(t, 2*time.Hour, healthThresholds.MaxDelay, "Default maximum delay set incorrectly")
+
+	// Explicit values
+	executeCommand("health", "my-bucket", "--max-gap", "30m", "--max-delay", "1d")
+	require.Nil(t, executeError, "error seen parsing health command line")
+	require.Equal(t, 30*time.Minute, healthThresholds.MaxGap, "Maximum gap set incorrectly")
+	require.Equal(t, 24*time.Hour, healthThresholds.MaxDelay, "Maximum delay set incorrectly")
+
+	// Bad values
+	executeCommand("health", "my-bucket", "--max-gap", "blargle")
+	require.NotNil(t, executeError, "an invalid maximum gap should have been rejected")
+	require.Equal(t, "Invalid maximum gap: Cannot parse time window length", executeError.Error(), "Expected invalid gap error")
+	executeCommand("health", "my-bucket", "--max-delay", "blargle")
+	require.NotNil(t, executeError, "an invalid maximum delay should have been rejected")
+	require.Equal(t, "Invalid maximum delay: Cannot parse time window length", executeError.Error(), "Expected invalid delay error")
+}
+
+// TestHealthSince confirms that --since covers the time from that long ago until now
+func TestHealthSince(t *testing.T) {
+
+	// Relative to now
+	before := time.Now().UTC().Truncate(time.Second)
+	executeCommand("health", "my-bucket", "--since", "2h", "--start", "2020-01-01T00:00:00Z")
+	require.Nil(t, executeError, "error seen parsing health command line")
+	require.Equal(t, 2*time.Hour, slogSession.EndDateTime.Sub(slogSession.StartDateTime), "Window length incorrect")
+	require.False(t, slogSession.StartDateTime.Before(before.Add(-2*time.Hour)), "Start time should have been two hours ago")
+	require.False(t, slogSession.EndDateTime.After(time.Now()), "End time should not have been after now")
+
+	// Bad values
+	executeCommand("health", "my-bucket", "--since", "blargle")
+	require.NotNil(t, executeError, "an invalid since should have been rejected")
+	require.Equal(t, "Invalid since: Cannot parse time window length", executeError.Error(), "Expected invalid since error")
+	executeCommand("health", "my-bucket", "--since", "0h")
+	require.NotNil(t, executeError, "a zero since should have been rejected")
+	require.Equal(t, "Invalid since: 0h is not greater than zero", executeError.Error(), "Expected non-positive since error")
+}
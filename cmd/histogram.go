@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	intervalStr string        // flag value defining the length of each histogram interval
+	interval    time.Duration // the length of each histogram interval
+	splitStatus bool          // when true, split the histogram bars by HTTP status class
+)
+
+// histogramCmd represents the histogram command
+var histogramCmd = &cobra.Command{
+	Use:   "histogram log-bucket [source-bucket*]",
+	Short: "Chart the traffic found in S3 hosted web logs over a given time window",
+	Long: `Given a start date and time, together with a time window, reads the S3 hosted web
+logs from a specified bucket and charts the number of requests, errors and bytes sent
+in each interval of the window. Optionally, filters the log data to only include those
+entries that match the list of source buckets.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// Check the bot filtering flags
+		err = parseBotFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the GeoIP flags
+		err = parseGeoFlags()
+		if err != nil {
+			return err
+		}
+
+		// Confirm that the output format requested is valid
+		err = validateOutputFormat()
+		if err != nil {
+			return err
+		}
+
+		// Parse the interval
+		interval, err = parseTimeWindow(intervalStr)
+		if err != nil {
+			return fmt.Errorf("Invalid histogram interval: %w", err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("Invalid histogram interval: %s is not greater than zero", intervalStr)
+		}
+		if window/interval >= s3.MaxHistogramIntervals {
+			return fmt.Errorf("Invalid histogram interval: %s divides the time window into more than %d intervals",
+				intervalStr, s3.MaxHistogramIntervals)
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:         region,
+			Endpoint:       endpoint,
+			Retry:          retryPolicy,
+			Checkpoint:     progressFile,
+			Cache:          logCache,
+			LogBucket:      args[0],
+			Folder:         path,
+			SourceBuckets:  args[1:],
+			StartDateTime:  startDateTime,
+			EndDateTime:    startDateTime.Add(window),
+			BotFilter:      botFilter,
+			BotSignatures:  botSigFile,
+			GeoIPDatabases: geoIPFiles,
+			Countries:      countries,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.DisplayHistogram(slogSession, interval, splitStatus, outputFormat)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(histogramCmd)
+
+	// Initialize the flags that apply to the histogram command
+	initHistogramFlags()
+}
+
+// initHistogramFlags is called from init() to define the flags that apply to the histogram
+// command. It is defined separately from init() so that it can be invoked by unit tests when
+// they need to reset the playing field.
+func initHistogramFlags() {
+
+	// Local flag definitions
+	addWindowFlags(histogramCmd)
+	addCheckpointFlag(histogramCmd)
+	histogramCmd.Flags().StringVar(&intervalStr, "bucket", "5m",
+		`The length of each histogram interval, in days (d), hours (h), minutes (m)
+or seconds (s)`)
+	histogramCmd.Flags().BoolVar(&splitStatus, "split-status", false,
+		`Split the histogram bars by HTTP status class`)
+	addFormatFlag(histogramCmd)
+	addBotFlags(histogramCmd)
+	addGeoFlags(histogramCmd)
+}
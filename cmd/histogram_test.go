@@ -0,0 +1,57 @@
+package cmd
+
+// Unit tests for the histogram command line parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareHistogramCommand examines the case where a histogram command is requested
+// but no parameters are provided
+func TestBareHistogramCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("histogram")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestHistogramCommand confirms that the interval, status split and format are parsed
+func TestHistogramCommand(t *testing.T) {
+
+	// The defaults
+	executeCommand("histogram", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum histogram command line")
+	require.Equal(t, 5*time.Minute, interval, "Default interval set incorrectly")
+	require.False(t, splitStatus, "Status should not be split by default")
+	require.Equal(t, s3.TEXT, outputFormat, "Default output format set incorrectly")
+
+	// Explicit values
+	executeCommand("histogram", "my-bucket", "--bucket", "1h", "--split-status", "--format", "csv")
+	require.Nil(t, executeError, "error seen parsing histogram command line")
+	require.Equal(t, time.Hour, interval, "Interval set incorrectly")
+	require.True(t, splitStatus, "Status split should have been requested")
+	require.Equal(t, s3.CSV, outputFormat, "Output format set incorrectly")
+
+	// A bad interval
+	executeCommand("histogram", "my-bucket", "--bucket", "blargle")
+	require.NotNil(t, executeError, "an invalid interval should have been rejected")
+	require.Equal(t, "Invalid histogram interval: Cannot parse time window length", executeError.Error(), "Expected invalid interval error")
+
+	// Intervals that would never end, or would need too many of them
+	executeCommand("histogram", "bucket", "--bucket", "0m")
+	require.NotNil(t, executeError, "a zero interval should have been rejected")
+	require.Equal(t, "Invalid histogram interval: 0m is not greater than zero", executeError.Error(), "Expected zero interval error")
+	executeCommand("histogram", "bucket", "--bucket", "-5m")
+	require.NotNil(t, executeError, "a negative interval should have been rejected")
+	require.Equal(t, "Invalid histogram interval: -5m is not greater than zero", executeError.Error(), "Expected negative interval error")
+	executeCommand("histogram", "bucket", "--bucket", "1s", "--window", "365d")
+	require.NotNil(t, executeError, "too many intervals should have been rejected")
+	require.Contains(t, executeError.Error(), "divides the time window into more than", "Expected too many intervals error")
+}
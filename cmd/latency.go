@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	breakdownStrs    []string              // flag values naming the ways to break down latency statistics
+	breakdowns       []s3.LatencyBreakdown // the ways to break down latency statistics as enumerated values
+	topCount         int                   // the number of groups to display in each breakdown
+	displayHistogram bool                  // when true, display a histogram as well as statistics
+)
+
+// latencyCmd represents the latency command
+var latencyCmd = &cobra.Command{
+	Use:   "latency log-bucket [source-bucket*]",
+	Short: "Analyze request latency found in S3 hosted web logs for a given time window",
+	Long: `Given a start date and time, together with a time window, reads the S3 hosted web
+logs from a specified bucket and reports the 50th, 90th and 99th percentiles and the
+maximum of the Total Time and Turn-Around Time recorded for each request. The statistics
+are given for all requests and broken down by key, operation and object size. Optionally,
+filters the log data to only include those entries that match the list of source buckets.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// Check the bot filtering flags
+		err = parseBotFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the GeoIP flags
+		err = parseGeoFlags()
+		if err != nil {
+			return err
+		}
+
+		// Confirm that the breakdowns requested are ones that we know
+		breakdowns = make([]s3.LatencyBreakdown, 0, len(breakdownStrs))
+		for _, breakdownStr := range breakdownStrs {
+			switch breakdownStr {
+			case "key":
+				breakdowns = append(breakdowns, s3.BYKEY)
+			case "operation":
+				breakdowns = append(breakdowns, s3.BYOPERATION)
+			case "size":
+				breakdowns = append(breakdowns, s3.BYSIZE)
+			case "country":
+				if len(geoIPFiles) == 0 {
+					return errors.New("The country breakdown requires a GeoIP database given with --geoip-db")
+				}
+				breakdowns = append(breakdowns, s3.BYCOUNTRY)
+			default:
+				return fmt.Errorf("Unrecognized breakdown: %s", breakdownStr)
+			}
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:         region,
+			Endpoint:       endpoint,
+			Retry:          retryPolicy,
+			Checkpoint:     progressFile,
+			Cache:          logCache,
+			LogBucket:      args[0],
+			Folder:         path,
+			SourceBuckets:  args[1:],
+			StartDateTime:  startDateTime,
+			EndDateTime:    startDateTime.Add(window),
+			BotFilter:      botFilter,
+			BotSignatures:  botSigFile,
+			GeoIPDatabases: geoIPFiles,
+			Countries:      countries,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.DisplayLatency(slogSession, breakdowns, topCount, displayHistogram)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(latencyCmd)
+
+	// Initialize the flags that apply to the latency command
+	initLatencyFlags()
+}
+
+// initLatencyFlags is called from init() to define the flags that apply to the latency command.
+// It is defined separately from init() so that it can be invoked by unit tests when they need
+// to reset the playing field.
+func initLatencyFlags() {
+
+	// Local flag definitions
+	addWindowFlags(latencyCmd)
+	addCheckpointFlag(latencyCmd)
+	latencyCmd.Flags().StringSliceVar(&breakdownStrs, "by", []string{"key", "operation", "size"},
+		`The ways to break down the statistics; any of key, operation, size and country`)
+	latencyCmd.Flags().IntVar(&topCount, "top", 20,
+		`The number of most requested keys, operations and countries to display; zero for all`)
+	latencyCmd.Flags().BoolVar(&displayHistogram, "histogram", false,
+		`Display a histogram of the Total Time`)
+	addBotFlags(latencyCmd)
+	addGeoFlags(latencyCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+// Unit tests for the latency command line parser
+
+import (
+	"testing"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareLatencyCommand examines the case where a latency command is requested
+// but no parameters are provided
+func TestBareLatencyCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("latency")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestLatencyCommand confirms that the breakdowns and display options are parsed
+func TestLatencyCommand(t *testing.T) {
+
+	// The defaults
+	executeCommand("latency", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum latency command line")
+	require.Equal(t, []s3.LatencyBreakdown{s3.BYKEY, s3.BYOPERATION, s3.BYSIZE}, breakdowns, "Default breakdowns set incorrectly")
+	require.Equal(t, 20, topCount, "Default top count set incorrectly")
+	require.False(t, displayHistogram, "Histogram should not be displayed by default")
+
+	// Explicit values
+	executeCommand("latency", "my-bucket", "--by", "size,operation", "--top", "5", "--histogram")
+	require.Nil(t, executeError, "error seen parsing latency command line")
+	require.Equal(t, []s3.LatencyBreakdown{s3.BYSIZE, s3.BYOPERATION}, breakdowns, "Breakdowns set incorrectly")
+	require.Equal(t, 5, topCount, "Top count set incorrectly")
+	require.True(t, displayHistogram, "Histogram should have been requested")
+
+	// A breakdown that we do not know
+	executeCommand("latency", "my-bucket", "--by", "colour")
+	require.NotNil(t, executeError, "an unknown breakdown should have been rejected")
+	require.Equal(t, "Unrecognized breakdown: colour", executeError.Error(), "Expected unknown breakdown error")
+
+	// Breaking down by country needs a GeoIP database
+	executeCommand("latency", "my-bucket", "--by", "country")
+	require.NotNil(t, executeError, "a country breakdown without a GeoIP database should have been rejected")
+	require.Equal(t, "The country breakdown requires a GeoIP database given with --geoip-db", executeError.Error(), "Expected GeoIP database required error")
+	executeCommand("latency", "my-bucket", "--by", "country", "--geoip-db", "GeoLite2-Country.mmdb")
+	require.Nil(t, executeError, "error seen parsing country breakdown")
+	require.Equal(t, []s3.LatencyBreakdown{s3.BYCOUNTRY}, breakdowns, "Breakdowns set incorrectly")
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	expireStr  string // flag value defining how long S3 should keep log objects before expiring them
+	expireDays int64  // the number of days that S3 should keep log objects before expiring them
+)
+
+// lifecycleCmd represents the lifecycle command, a parent to its show, apply and remove subcommands
+var lifecycleCmd = &cobra.Command{
+	Use:   "lifecycle",
+	Short: "Manage S3 lifecycle rules that expire web logs server-side",
+	Long: `Reads and writes the lifecycle rules of a log bucket so that S3 itself expires
+old web logs, which is much faster than deleting them with the retain command when
+buckets are large. The rules that slog manages are scoped to the --path prefix and
+named for it; rules that slog did not create are never modified.`,
+}
+
+// lifecycleShowCmd represents the lifecycle show subcommand
+var lifecycleShowCmd = &cobra.Command{
+	Use:   "show log-bucket",
+	Short: "Display the lifecycle rules of a log bucket",
+	Long: `Displays all of the lifecycle rules of a log bucket in human readable form. The rules
+that apply to the --path prefix are marked with an asterisk.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runLifecycle(args, s3.ShowLifecycle)
+	},
+}
+
+// lifecycleApplyCmd represents the lifecycle apply subcommand
+var lifecycleApplyCmd = &cobra.Command{
+	Use:   "apply log-bucket",
+	Short: "Add or replace the lifecycle rule that expires the web logs under --path",
+	Long: `Adds a lifecycle rule to a log bucket that expires the web logs under the --path
+prefix once they reach the age given by --expire. If slog has already added a rule for
+the prefix, it is replaced. All other rules are left as they are.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// S3 measures expiration in whole days
+		window, err := parseTimeWindow(expireStr)
+		if err != nil {
+			return fmt.Errorf("Invalid expiration: %w", err)
+		}
+		if window < 24*time.Hour || window%(24*time.Hour) != 0 {
+			return fmt.Errorf("Invalid expiration: %s is not a whole number of days", expireStr)
+		}
+		expireDays = int64(window / (24 * time.Hour))
+
+		return runLifecycle(args, func(session *s3.SlogSession) error {
+			return s3.ApplyLifecycle(session, expireDays)
+		})
+	},
+}
+
+// lifecycleRemoveCmd represents the lifecycle remove subcommand
+var lifecycleRemoveCmd = &cobra.Command{
+	Use:   "remove log-bucket",
+	Short: "Remove the lifecycle rule that expires the web logs under --path",
+	Long: `Removes the lifecycle rule that slog added to expire the web logs under the --path
+prefix. All other rules are left as they are.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runLifecycle(args, s3.RemoveLifecycle)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lifecycleCmd)
+	lifecycleCmd.AddCommand(lifecycleShowCmd)
+	lifecycleCmd.AddCommand(lifecycleApplyCmd)
+	lifecycleCmd.AddCommand(lifecycleRemoveCmd)
+
+	// Initialize the flags that apply to the lifecycle subcommands
+	initLifecycleFlags()
+}
+
+// initLifecycleFlags is called from init() to define the flags that apply to the lifecycle
+// subcommands. It is defined separately from init() so that it can be invoked by unit tests
+// when they need to reset the playing field.
+func initLifecycleFlags() {
+
+	// Local flag definitions
+	lifecycleApplyCmd.Flags().StringVar(&expireStr, "expire", "30d",
+		`How long S3 should keep web logs before expiring them, in whole days (d)`)
+}
+
+// runLifecycle does the work common to all of the lifecycle subcommands: validating the log
+// bucket argument, populating the SlogSession, and invoking the S3 operation unless we are
+// unit testing.
+func runLifecycle(args []string, operation func(session *s3.SlogSession) error) error {
+
+	// There must be an S3 bucket name, given or taken from the profile
+	args = profileArgs(args)
+	if len(args) == 0 {
+		return errors.New("An S3 bucket name must be provided")
+	}
+
+	// Populate the SlogSession to wrap our parameters up for the run
+	slogSession = &s3.SlogSession{
+		Region:    region,
+		Endpoint:  endpoint,
+		Retry:     retryPolicy,
+		LogBucket: args[0],
+		Folder:    path,
+	}
+
+	// Go ahead and do the work unless we are unit testing
+	if unitTesting {
+		return nil
+	}
+	return operation(slogSession)
+}
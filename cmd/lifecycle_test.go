@@ -0,0 +1,54 @@
+package cmd
+
+// Unit tests for the lifecycle command line parsers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLifecycleBucketRequired confirms that each of the lifecycle subcommands insists
+// on being given a log bucket name
+func TestLifecycleBucketRequired(t *testing.T) {
+	for _, subcommand := range []string{"show", "apply", "remove"} {
+		executeCommand("lifecycle", subcommand)
+		require.NotNil(t, executeError, "lifecycle %s should have required a bucket", subcommand)
+		require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+	}
+}
+
+// TestLifecycleShow confirms that the show subcommand populates the session from the global flags
+func TestLifecycleShow(t *testing.T) {
+
+	// The following should parse happily
+	executeCommand("lifecycle", "show", "my-bucket", "--path", "logs", "--region", "eu-west-1")
+	require.Nil(t, executeError, "error seen parsing lifecycle show command line")
+	require.Equal(t, "my-bucket", slogSession.LogBucket, "Log bucket set incorrectly")
+	require.Equal(t, "logs", slogSession.Folder, "Folder set incorrectly")
+	require.Equal(t, "eu-west-1", slogSession.Region, "Region set incorrectly")
+}
+
+// TestLifecycleApply examines how the apply subcommand handles the expiration flag
+func TestLifecycleApply(t *testing.T) {
+
+	// The default expiration
+	executeCommand("lifecycle", "apply", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing lifecycle apply command line")
+	require.Equal(t, int64(30), expireDays, "Default expiration set incorrectly")
+
+	// An explicit expiration
+	executeCommand("lifecycle", "apply", "my-bucket", "--expire", "365d")
+	require.Nil(t, executeError, "error seen parsing lifecycle apply command line")
+	require.Equal(t, int64(365), expireDays, "Expiration set incorrectly")
+
+	// Expirations must be a whole number of days
+	executeCommand("lifecycle", "apply", "my-bucket", "--expire", "36h")
+	require.NotNil(t, executeError, "a part day expiration should have been rejected")
+	require.Equal(t, "Invalid expiration: 36h is not a whole number of days", executeError.Error(), "Expected whole days error")
+
+	// And must be parsable
+	executeCommand("lifecycle", "apply", "my-bucket", "--expire", "never")
+	require.NotNil(t, executeError, "an unparsable expiration should have been rejected")
+	require.Equal(t, "Invalid expiration: Cannot parse time window length", executeError.Error(), "Expected parse error")
+}
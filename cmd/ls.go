@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	periodStr string        // flag value defining the length of the periods that an inventory is summarized by
+	period    time.Duration // the length of the periods that an inventory is summarized by
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls log-bucket",
+	Short: "Summarize the S3 hosted web log objects available for a given time window",
+	Long: `Given a start date and time, together with a time window, lists the web log objects
+in a bucket without downloading them, reporting the number of objects, their total size,
+and the first and last keys for each hour or day of the window. Periods in which no logs
+arrived at all are reported as gaps.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// Confirm that the period is one that we know
+		switch periodStr {
+		case "hour":
+			period = time.Hour
+		case "day":
+			period = 24 * time.Hour
+		default:
+			return fmt.Errorf("Unrecognized period: %s", periodStr)
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:        region,
+			Endpoint:      endpoint,
+			Retry:         retryPolicy,
+			LogBucket:     args[0],
+			Folder:        path,
+			StartDateTime: startDateTime,
+			EndDateTime:   startDateTime.Add(window),
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.DisplayInventory(slogSession, period)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	// Initialize the flags that apply to the ls command
+	initLsFlags()
+}
+
+// initLsFlags is called from init() to define the flags that apply to the ls command.
+// It is defined separately from init() so that it can be invoked by unit tests when
+// they need to reset the playing field.
+func initLsFlags() {
+
+	// Local flag definitions
+	addWindowFlags(lsCmd)
+	lsCmd.Flags().StringVar(&periodStr, "by", "hour",
+		`The period to summarize the log objects by; either hour or day`)
+}
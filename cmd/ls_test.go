@@ -0,0 +1,49 @@
+package cmd
+
+// Unit tests for the ls command line parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareLsCommand examines the case where an ls command is requested
+// but no parameters are provided
+func TestBareLsCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("ls")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestLsCommand confirms that the ls command shares the read command's time window
+// handling and accepts the periods that it should
+func TestLsCommand(t *testing.T) {
+
+	// The defaults
+	executeCommand("ls", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum ls command line")
+	require.Equal(t, time.Hour, period, "Default period set incorrectly")
+	expectedStartDateTime, _ := time.Parse(time.RFC3339, "2020-01-01T00:00:00+00:00")
+	require.Equal(t, expectedStartDateTime, slogSession.StartDateTime, "Default start date time set incorrectly")
+	require.Equal(t, expectedStartDateTime.Add(time.Hour), slogSession.EndDateTime, "Default window set incorrectly")
+
+	// Daily periods over a week
+	executeCommand("ls", "my-bucket", "--by", "day", "--window", "7d")
+	require.Nil(t, executeError, "error seen parsing ls command line")
+	require.Equal(t, 24*time.Hour, period, "Daily period set incorrectly")
+	require.Equal(t, expectedStartDateTime.Add(7*24*time.Hour), slogSession.EndDateTime, "Window set incorrectly")
+
+	// Unknown periods and bad windows are rejected
+	executeCommand("ls", "my-bucket", "--by", "fortnight")
+	require.NotNil(t, executeError, "an unknown period should have been rejected")
+	require.Equal(t, "Unrecognized period: fortnight", executeError.Error(), "Expected unknown period error")
+	executeCommand("ls", "my-bucket", "--window", "blargle")
+	require.NotNil(t, executeError, "an invalid window should have been rejected")
+	require.Equal(t, "Invalid time window: Cannot parse time window length", executeError.Error(), "Expected invalid window error")
+}
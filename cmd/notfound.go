@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	siteHosts       []string        // flag values naming the host names of our own web sites
+	outputFormatStr string          // flag value defining the format of a report
+	outputFormat    s3.OutputFormat // the format of a report as an enumerated value
+)
+
+// notFoundCmd represents the 404s command
+var notFoundCmd = &cobra.Command{
+	Use:   "404s log-bucket [source-bucket*]",
+	Short: "Report broken links found in S3 hosted web logs for a given time window",
+	Long: `Given a start date and time, together with a time window, reads the S3 hosted web
+logs from a specified bucket and reports the requests for keys that do not exist. The
+requests are aggregated by key and referrer, ranked by the number of hits, and separated
+into those referred by our own pages and those referred from elsewhere. Optionally,
+filters the log data to only include those entries that match the list of source buckets.
+
+Referrers are recognized as our own if their host name is given with --host or, if no
+--host is given, if it matches the name of the bucket that the request was served from.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// Check the bot filtering flags
+		err = parseBotFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the GeoIP flags
+		err = parseGeoFlags()
+		if err != nil {
+			return err
+		}
+
+		// Confirm that the output format requested is valid
+		err = validateOutputFormat()
+		if err != nil {
+			return err
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:         region,
+			Endpoint:       endpoint,
+			Retry:          retryPolicy,
+			Checkpoint:     progressFile,
+			Cache:          logCache,
+			LogBucket:      args[0],
+			Folder:         path,
+			SourceBuckets:  args[1:],
+			StartDateTime:  startDateTime,
+			EndDateTime:    startDateTime.Add(window),
+			BotFilter:      botFilter,
+			BotSignatures:  botSigFile,
+			GeoIPDatabases: geoIPFiles,
+			Countries:      countries,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.DisplayNotFound(slogSession, siteHosts, outputFormat)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notFoundCmd)
+
+	// Initialize the flags that apply to the 404s command
+	initNotFoundFlags()
+}
+
+// initNotFoundFlags is called from init() to define the flags that apply to the 404s command.
+// It is defined separately from init() so that it can be invoked by unit tests when they need
+// to reset the playing field.
+func initNotFoundFlags() {
+
+	// Local flag definitions
+	addWindowFlags(notFoundCmd)
+	addCheckpointFlag(notFoundCmd)
+	notFoundCmd.Flags().StringArrayVar(&siteHosts, "host", nil,
+		`A host name of our own web site, used to recognize internal referrers.
+May be repeated; for example --host example.com --host www.example.com`)
+	addFormatFlag(notFoundCmd)
+	addBotFlags(notFoundCmd)
+	addGeoFlags(notFoundCmd)
+}
+
+// addFormatFlag defines the --format flag on a command that produces a report
+func addFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&outputFormatStr, "format", "text",
+		`The format of the report; either text or csv`)
+}
+
+// validateOutputFormat ensures that the output format provided, or its default, is one
+// that we know how to render.
+func validateOutputFormat() error {
+	switch outputFormatStr {
+	case "text":
+		outputFormat = s3.TEXT
+	case "csv":
+		outputFormat = s3.CSV
+	default:
+		return fmt.Errorf("Unrecognized output format: %s", outputFormatStr)
+	}
+	return nil
+}
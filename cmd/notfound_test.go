@@ -0,0 +1,44 @@
+package cmd
+
+// Unit tests for the 404s command line parser
+
+import (
+	"testing"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareNotFoundCommand examines the case where a 404s command is requested
+// but no parameters are provided
+func TestBareNotFoundCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("404s")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestNotFoundCommand confirms that the host names and output format are parsed
+func TestNotFoundCommand(t *testing.T) {
+
+	// The defaults
+	executeCommand("404s", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum 404s command line")
+	require.Equal(t, s3.TEXT, outputFormat, "Default output format set incorrectly")
+	require.Empty(t, siteHosts, "Default site hosts set incorrectly")
+
+	// Explicit values
+	executeCommand("404s", "my-bucket", "--host", "example.com", "--host", "www.example.com", "--format", "csv", "--exclude-bots")
+	require.Nil(t, executeError, "error seen parsing 404s command line")
+	require.Equal(t, s3.CSV, outputFormat, "Output format set incorrectly")
+	require.Equal(t, []string{"example.com", "www.example.com"}, siteHosts, "Site hosts set incorrectly")
+	require.Equal(t, s3.EXCLUDEBOTS, slogSession.BotFilter, "Bot filter set incorrectly")
+
+	// A format that we do not know
+	executeCommand("404s", "my-bucket", "--format", "pdf")
+	require.NotNil(t, executeError, "an unknown format should have been rejected")
+	require.Equal(t, "Unrecognized output format: pdf", executeError.Error(), "Expected unknown format error")
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	purgeIPs        []string // flag values giving the IP addresses of the visitors to be purged
+	purgeRequesters []string // flag values giving the canonical user IDs or ARNs of the visitors to be purged
+	deleteVersions  bool     // flag value, when true the noncurrent versions of rewritten objects are deleted too
+
+	// The visitors to be purged, held as a global so that they can be checked by unit test code
+	purgeTarget s3.PurgeTarget
+)
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge log-bucket",
+	Short: "Erase the entries made by particular visitors from S3 hosted web logs",
+	Long: `Given a start date and time, together with a time window, removes every entry made
+from the given IP addresses or by the given requesters from the S3 hosted web logs of a
+specified bucket, to honor data deletion requests. Each log object holding such entries is
+rewritten in place, keeping its key, content type and metadata; objects that do not are
+never touched. The number of lines removed from each object is reported.
+
+Use --dry-run first to see what would be removed.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// There must be someone to purge, and IP addresses must look like IP addresses
+		if len(purgeIPs) == 0 && len(purgeRequesters) == 0 {
+			return errors.New("At least one visitor must be provided with --ip or --requester")
+		}
+		for _, ip := range purgeIPs {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("Invalid IP address: %s", ip)
+			}
+		}
+		purgeTarget = s3.PurgeTarget{RemoteIPs: purgeIPs, Requesters: purgeRequesters}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:        region,
+			Endpoint:      endpoint,
+			Retry:         retryPolicy,
+			Checkpoint:    progressFile,
+			Cache:         logCache,
+			LogBucket:     args[0],
+			Folder:        path,
+			StartDateTime: startDateTime,
+			EndDateTime:   startDateTime.Add(window),
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.PurgeLogs(slogSession, purgeTarget, dryRun, deleteVersions)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+
+	// Initialize the flags that apply to the purge command
+	initPurgeFlags()
+}
+
+// initPurgeFlags is called from init() to define the flags that apply to the purge
+// command. It is defined separately from init() so that it can be invoked by unit tests
+// when they need to reset the playing field.
+func initPurgeFlags() {
+
+	// Local flag definitions
+	addWindowFlags(purgeCmd)
+	addCheckpointFlag(purgeCmd)
+	purgeCmd.Flags().StringArrayVar(&purgeIPs, "ip", nil,
+		`The IP address of a visitor whose entries are to be removed; may be repeated`)
+	purgeCmd.Flags().StringArrayVar(&purgeRequesters, "requester", nil,
+		`The canonical user ID or ARN of a requester whose entries are to be removed;
+may be repeated`)
+	purgeCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		`Report what would be removed without rewriting anything`)
+	purgeCmd.Flags().BoolVar(&deleteVersions, "delete-versions", false,
+		`In a versioned bucket, also delete the noncurrent versions of each object
+in the window, which would otherwise still hold the purged entries`)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+// Unit tests for the purge command line parser
+
+import (
+	"testing"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBarePurgeCommand examines the case where a purge command is requested
+// but no parameters are provided
+func TestBarePurgeCommand(t *testing.T) {
+	executeCommand("purge")
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestPurgeCommand confirms that the visitors and dry run flag are parsed
+func TestPurgeCommand(t *testing.T) {
+
+	// Someone must be named
+	executeCommand("purge", "my-bucket")
+	require.NotNil(t, executeError, "a purge without visitors should have been rejected")
+	require.Equal(t, "At least one visitor must be provided with --ip or --requester", executeError.Error(), "Expected visitor required error")
+
+	// Addresses and requesters, repeated
+	executeCommand("purge", "my-bucket", "--ip", "203.0.113.7", "--ip", "2001:db8::1",
+		"--requester", "arn:aws:iam::123456789012:user/alice", "--start", "2020-03-01T00:00:00Z", "--window", "30d")
+	require.Nil(t, executeError, "error seen parsing purge command line")
+	require.Equal(t, s3.PurgeTarget{
+		RemoteIPs:  []string{"203.0.113.7", "2001:db8::1"},
+		Requesters: []string{"arn:aws:iam::123456789012:user/alice"},
+	}, purgeTarget, "Purge target set incorrectly")
+	require.Equal(t, "my-bucket", slogSession.LogBucket, "Log bucket set incorrectly")
+	require.False(t, dryRun, "Dry run should be off by default")
+
+	// A dry run
+	executeCommand("purge", "my-bucket", "--requester", "arn:aws:iam::123456789012:user/alice", "--dry-run")
+	require.Nil(t, executeError, "error seen parsing purge dry run command line")
+	require.True(t, dryRun, "Dry run should have been requested")
+	require.False(t, deleteVersions, "Deleting versions should be off by default")
+
+	// Purging old versions too
+	executeCommand("purge", "my-bucket", "--ip", "203.0.113.7", "--delete-versions")
+	require.Nil(t, executeError, "error seen parsing purge --delete-versions command line")
+	require.True(t, deleteVersions, "Deleting versions should have been requested")
+
+	// Addresses must be addresses
+	executeCommand("purge", "my-bucket", "--ip", "203.0.113")
+	require.NotNil(t, executeError, "an invalid IP address should have been rejected")
+	require.Equal(t, "Invalid IP address: 203.0.113", executeError.Error(), "Expected invalid IP address error")
+}
@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	queryDescription string // a description of the query being saved
+	replaceQuery     bool   // when true, a saved query of the same name is replaced
+	sinceStr         string // flag value defining how far back from now a query is to be run
+)
+
+// queryFile is the layout of the YAML file in which queries are saved
+type queryFile struct {
+	Queries map[string]savedQuery `yaml:"queries"` // The saved queries, by name
+}
+
+// savedQuery is a command line saved to be run again by name
+type savedQuery struct {
+	Description string   `yaml:"description,omitempty"` // What the query is for
+	Args        []string `yaml:"args"`                  // The command and its arguments and flags, e.g. read log-bucket --content json
+}
+
+// queryCmd represents the query command, a parent to its save, run and list subcommands
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Save and run named queries",
+	Long: `Saves command lines, with their filters, content and output options, under a name so
+that investigations that are made again and again can be run by that name. Queries are
+kept in queries.yaml alongside the --config file.`,
+}
+
+// querySaveCmd represents the query save subcommand
+var querySaveCmd = &cobra.Command{
+	Use:   "save name -- command [args]",
+	Short: "Save a command line as a named query",
+	Long: `Saves the command line that follows the -- under the given name, for example:
+
+  slog query save robots --description "robots.txt fetches" -- read log.example.com --only-bots`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be a name, and something to save under it
+		if len(args) < 2 || cmd.ArgsLenAtDash() != 1 {
+			return errors.New("A query name must be provided, followed by -- and the command line to save")
+		}
+		name, query := args[0], args[1:]
+		err := validateQuery(query)
+		if err != nil {
+			return err
+		}
+
+		// Add it to those already saved
+		queries, err := loadQueries()
+		if err != nil {
+			return err
+		}
+		if _, ok := queries.Queries[name]; ok && !replaceQuery {
+			return fmt.Errorf("A query named %s already exists; use --replace to replace it", name)
+		}
+		queries.Queries[name] = savedQuery{Description: queryDescription, Args: query}
+		return saveQueries(queries)
+	},
+}
+
+// queryRunCmd represents the query run subcommand
+var queryRunCmd = &cobra.Command{
+	Use:   "run name [-- args]",
+	Short: "Run a named query",
+	Long: `Runs the command line saved under the given name. Any arguments following a -- are
+added to the end of the saved command line, so flags given there override the saved ones.
+With --since, the query covers the time from that long ago until now.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be a name, and a query saved under it
+		if len(args) == 0 {
+			return errors.New("A query name must be provided")
+		}
+		queries, err := loadQueries()
+		if err != nil {
+			return err
+		}
+		query, ok := queries.Queries[args[0]]
+		if !ok {
+			return fmt.Errorf("Unrecognized query: %s", args[0])
+		}
+		queryArgs := append(append([]string{}, query.Args...), args[1:]...)
+
+		// Cover the time up to now if asked to
+		var since time.Duration
+		if len(sinceStr) > 0 {
+			since, err = parseTimeWindow(sinceStr)
+			if err != nil {
+				return fmt.Errorf("Invalid since: %w", err)
+			}
+		}
+		return runQuery(args[0], queryArgs, since)
+	},
+}
+
+// queryListCmd represents the query list subcommand
+var queryListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the named queries",
+	Long:  `Lists the saved queries with their descriptions and command lines.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		queries, err := loadQueries()
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(queries.Queries))
+		for name := range queries.Queries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// Display the queries in columns
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDESCRIPTION\tCOMMAND")
+		for _, name := range names {
+			query := queries.Queries[name]
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, query.Description, strings.Join(query.Args, " "))
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	queryCmd.AddCommand(querySaveCmd)
+	queryCmd.AddCommand(queryRunCmd)
+	queryCmd.AddCommand(queryListCmd)
+
+	// Initialize the flags that apply to the query subcommands
+	initQueryFlags()
+}
+
+// initQueryFlags is called from init() to define the flags that apply to the query
+// subcommands. It is defined separately from init() so that it can be invoked by unit tests
+// when they need to reset the playing field.
+func initQueryFlags() {
+
+	// Local flag definitions
+	querySaveCmd.Flags().StringVar(&queryDescription, "description", "",
+		`A description of what the query is for`)
+	querySaveCmd.Flags().BoolVar(&replaceQuery, "replace", false,
+		`Replace a query that has already been saved under the same name`)
+	queryRunCmd.Flags().StringVar(&sinceStr, "since", "",
+		`Cover the time from this long ago until now, in days (d), hours (h), minutes (m)
+or seconds (s); overrides the saved --start and --window`)
+}
+
+// validateQuery confirms that a command line to be saved names a command that can be run
+func validateQuery(query []string) error {
+	found, _, err := rootCmd.Find(query)
+	if err != nil || found == rootCmd || !found.Runnable() {
+		return fmt.Errorf("Unrecognized command: %s", strings.Join(query, " "))
+	}
+	for c := found; c != nil; c = c.Parent() {
+		if c == queryCmd {
+			return errors.New("A query cannot run other queries")
+		}
+	}
+	return nil
+}
+
+// runQuery runs the command line of the named query as if it had been typed. The command is
+// found and its flags parsed from the query arguments alone, so that nothing is carried over
+// but the shared flags given to query run itself, such as --config. If since is greater than
+// zero, the command's --start and --window flags are set to cover that long up to now,
+// replacing any given in the query.
+func runQuery(name string, queryArgs []string, since time.Duration) error {
+
+	// Find the command and parse its flags
+	target, targetArgs, err := rootCmd.Find(queryArgs)
+	if err != nil || target == rootCmd || !target.Runnable() {
+		return fmt.Errorf("Unrecognized command: %s", strings.Join(queryArgs, " "))
+	}
+	err = target.ParseFlags(targetArgs)
+	if err != nil {
+		return err
+	}
+
+	// Set the time window, if asked to
+	if since > 0 {
+		if target.Flags().Lookup("start") == nil || target.Flags().Lookup("window") == nil {
+			return fmt.Errorf("The %s query does not cover a window of time", name)
+		}
+		start := time.Now().UTC().Add(-since).Truncate(time.Second)
+		err = target.Flags().Set("start", start.Format(time.RFC3339))
+		if err == nil {
+			err = target.Flags().Set("window", sinceStr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Run it as cobra would, shared flags first
+	args := target.Flags().Args()
+	err = target.ValidateArgs(args)
+	if err == nil {
+		err = rootCmd.PersistentPreRunE(target, args)
+	}
+	if err != nil {
+		return err
+	}
+	if target.RunE != nil {
+		return target.RunE(target, args)
+	}
+	target.Run(target, args)
+	return nil
+}
+
+// queriesPath returns the name of the file in which queries are saved, alongside the config file
+func queriesPath() (string, error) {
+	if len(configFile) == 0 {
+		return "", errors.New("A configuration file location must be provided with --config")
+	}
+	return filepath.Join(filepath.Dir(configFile), "queries.yaml"), nil
+}
+
+// loadQueries reads the saved queries. A query file that does not exist yet holds no queries.
+func loadQueries() (*queryFile, error) {
+	queries := &queryFile{Queries: make(map[string]savedQuery)}
+	file, err := queriesPath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return queries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read query file: %w", err)
+	}
+	err = yaml.UnmarshalStrict(content, queries)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query file: %w", err)
+	}
+	if queries.Queries == nil {
+		queries.Queries = make(map[string]savedQuery)
+	}
+	return queries, nil
+}
+
+// saveQueries writes the saved queries, creating the configuration directory if need be
+func saveQueries(queries *queryFile) error {
+	file, err := queriesPath()
+	if err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(queries)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("Unable to write query file: %w", err)
+	}
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to write query file: %w", err)
+	}
+	return nil
+}
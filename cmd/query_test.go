@@ -0,0 +1,116 @@
+package cmd
+
+// Unit tests for the query command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestQuerySaveAndRun confirms that queries can be saved, listed and run by name
+func TestQuerySaveAndRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-query")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "slog", "config.yaml")
+
+	// Save a query
+	executeCommand("query", "save", "robots", "--config", config, "--description", "robots.txt fetches",
+		"--", "read", "log.example.com", "images.example.com", "--only-bots", "--content", "json")
+	require.Nil(t, executeError, "error seen saving query: %v", executeError)
+	_, err = os.Stat(filepath.Join(dir, "slog", "queries.yaml"))
+	require.Nil(t, err, "The query file should have been written alongside the config file")
+
+	// It is listed
+	output := executeCommand("query", "list", "--config", config)
+	require.Nil(t, executeError, "error seen listing queries: %v", executeError)
+	require.Contains(t, output, "robots", "Query name not listed")
+	require.Contains(t, output, "robots.txt fetches", "Query description not listed")
+	require.Contains(t, output, "read log.example.com images.example.com --only-bots --content json", "Query command not listed")
+
+	// And runs as if it had been typed
+	executeCommand("query", "run", "robots", "--config", config)
+	require.Nil(t, executeError, "error seen running query: %v", executeError)
+	require.Equal(t, "log.example.com", slogSession.LogBucket, "Log bucket set incorrectly")
+	require.Equal(t, []string{"images.example.com"}, slogSession.SourceBuckets, "Source buckets set incorrectly")
+	require.Equal(t, s3.ONLYBOTS, slogSession.BotFilter, "Bot filter set incorrectly")
+	require.Equal(t, s3.JSON, slogSession.Content, "Content set incorrectly")
+
+	// Up to now, with extra flags
+	executeCommand("query", "run", "robots", "--config", config, "--since", "1d", "--", "--content", "rich")
+	require.Nil(t, executeError, "error seen running query: %v", executeError)
+	require.Equal(t, s3.RICH, slogSession.Content, "Extra flags should have overridden the saved ones")
+	require.Equal(t, 24*time.Hour, slogSession.EndDateTime.Sub(slogSession.StartDateTime), "Window set incorrectly")
+	require.WithinDuration(t, time.Now(), slogSession.EndDateTime, time.Minute, "The window should have ended now")
+
+	// A name can only be used once, unless replaced
+	executeCommand("query", "save", "robots", "--config", config, "--", "ls", "log.example.com")
+	require.NotNil(t, executeError, "saving a query under a name already used should have failed")
+	require.Equal(t, "A query named robots already exists; use --replace to replace it", executeError.Error(), "Expected query exists error")
+	executeCommand("query", "save", "robots", "--config", config, "--replace", "--", "lifecycle", "show", "log.example.com")
+	require.Nil(t, executeError, "error seen replacing query: %v", executeError)
+
+	// Which does not cover a window of time
+	executeCommand("query", "run", "robots", "--config", config, "--since", "1d")
+	require.NotNil(t, executeError, "a since for a query without a window should have been rejected")
+	require.Equal(t, "The robots query does not cover a window of time", executeError.Error(), "Expected no window error")
+}
+
+// TestQueryRunSince confirms that --since sets the --start and --window of the query run,
+// replacing those saved with the query or given after the --, rather than adding to them
+func TestQueryRunSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-query")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config.yaml")
+	executeCommand("query", "save", "errors", "--config", config,
+		"--", "histogram", "log.example.com", "--start", "2019-01-01T00:00:00Z", "--window", "7d")
+	require.Nil(t, executeError, "error seen saving query: %v", executeError)
+
+	// Without --since, the saved window holds
+	executeCommand("query", "run", "errors", "--config", config)
+	require.Nil(t, executeError, "error seen running query: %v", executeError)
+	require.Equal(t, "2019-01-01T00:00:00Z", startDateStr, "The saved --start should have taken effect")
+	require.Equal(t, "7d", windowStr, "The saved --window should have taken effect")
+	require.Equal(t, time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC), slogSession.StartDateTime.UTC(), "Start set incorrectly")
+
+	// With it, the window is the last two hours, whatever else was asked for
+	executeCommand("query", "run", "errors", "--config", config, "--since", "2h", "--", "--start", "2018-06-01T00:00:00Z")
+	require.Nil(t, executeError, "error seen running query with --since: %v", executeError)
+	require.Equal(t, "2h", windowStr, "The --window should have been that of --since")
+	start, err := time.Parse(time.RFC3339, startDateStr)
+	require.Nil(t, err, "The --start should have been a time: %s", startDateStr)
+	require.WithinDuration(t, time.Now().Add(-2*time.Hour), start, time.Minute, "The --start should have been two hours ago")
+	require.Equal(t, 2*time.Hour, slogSession.EndDateTime.Sub(slogSession.StartDateTime), "Window set incorrectly")
+	require.WithinDuration(t, time.Now(), slogSession.EndDateTime, time.Minute, "The window should have ended now")
+}
+
+// TestQueryErrors confirms that bad queries are rejected
+func TestQueryErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-query")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config.yaml")
+
+	executeCommand("query", "save", "nothing", "--config", config)
+	require.NotNil(t, executeError, "a query without a command line should have been rejected")
+	require.Equal(t, "A query name must be provided, followed by -- and the command line to save", executeError.Error(), "Expected query name error")
+
+	executeCommand("query", "save", "bad", "--config", config, "--", "frobnicate", "log.example.com")
+	require.NotNil(t, executeError, "a query with an unknown command should have been rejected")
+	require.Equal(t, "Unrecognized command: frobnicate log.example.com", executeError.Error(), "Expected unknown command error")
+
+	executeCommand("query", "save", "loop", "--config", config, "--", "query", "run", "loop")
+	require.NotNil(t, executeError, "a query of a query should have been rejected")
+	require.Equal(t, "A query cannot run other queries", executeError.Error(), "Expected query of a query error")
+
+	executeCommand("query", "run", "missing", "--config", config)
+	require.NotNil(t, executeError, "an unknown query should have been rejected")
+	require.Equal(t, "Unrecognized query: missing", executeError.Error(), "Expected unknown query error")
+}
@@ -3,7 +3,10 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mikebway/slog/s3"
@@ -11,12 +14,35 @@ import (
 )
 
 var (
-	startDateStr   string         // flag value defining the start time of the window to be processed
-	startDateTime  time.Time      // the start time of the window to be processed
-	windowStr      string         // flag value defining the duration / time span to be considered
-	window         time.Duration  // the duration / time span to be considered
-	contentTypeStr string         // Specifies which fields are to be included in the log output
-	contentType    s3.ContentType // Content type as an enumerated value
+	startDateStr   string            // flag value defining the start time of the window to be processed
+	startDateTime  time.Time         // the start time of the window to be processed
+	windowStr      string            // flag value defining the duration / time span to be considered
+	window         time.Duration     // the duration / time span to be considered
+	contentTypeStr string            // Specifies which fields are to be included in the log output
+	contentType    s3.ContentType    // Content type as an enumerated value
+	excludeBots    bool              // when true, entries made by bots are excluded
+	onlyBots       bool              // when true, only entries made by bots are included
+	botSigFile     string            // the name of a file of bot signatures to use in place of the built in list
+	botFilter      s3.BotFilter      // Bot filtering as an enumerated value
+	progressFile   string            // the name of a file in which to record progress, so that interrupted runs can resume
+	anonymizeStr   string            // flag value defining how remote IP addresses are to be obscured
+	anonymizeKey   string            // the key with which remote IP addresses are hashed
+	anonymizeMode  s3.AnonymizeMode  // Anonymization as an enumerated value
+	geoIPFiles     []string          // the names of MaxMind GeoIP database files with which to locate visitors
+	countryStrs    []string          // flag values giving the countries from which entries are to be filtered for
+	countries      []string          // the ISO codes of the countries from which entries are to be filtered for
+	resolveHosts   bool              // when true, the host names of remote IP addresses are looked up
+	resolveTimeout string            // flag value defining the longest that any one host name lookup may take
+	resolveBudget  string            // flag value defining the longest to wait for host name lookups over a run
+	resolveTTL     string            // flag value defining how long host names are remembered
+	resolvePolicy  s3.ResolvePolicy  // The host name lookup settings assembled from the flags
+	resolve        *s3.ResolvePolicy // The host name lookup settings if host names are wanted, otherwise nil
+	fromStrs       []string          // flag values of the form log-bucket[/path][=source-bucket,...] defining sources
+	logSources     []s3.LogSource    // the log bucket folders to be read together, if given with --from
+	colorStr       string            // flag value controlling when the output is colored: auto, always or never
+	slowStr        string            // flag value giving the total time at or beyond which requests are shown as slow
+	useColor       bool              // whether the output is to be colored
+	slowRequest    time.Duration     // the total time at or beyond which requests are shown as slow
 
 	// We build the parameters to be passed to he command execution
 	// as a global so that they can be checked by unit test code
@@ -29,48 +55,104 @@ var readCmd = &cobra.Command{
 	Short: "Display S3 hosted web logs for a given time window",
 	Long: `Given a start date and time, together with a time window, displays the S3 hosted
 web logs from a specified bucket for that time window. Optionally, filters the
-log data to only include those entries that match the list of source buckets.`,
+log data to only include those entries that match the list of source buckets.
+Each source bucket may be a name, a glob such as 'assets-*.example.com', or a
+regular expression between slashes such as '/^assets-[0-9]+\./', and may be
+preceded by ! to exclude the buckets that it matches instead.
+
+Several log buckets and paths may be read together by giving each with --from in
+place of the arguments. Their logs are merged in time order, each line prefixed
+with the bucket/path it came from.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		// There must be an S3 bucket name
-		if len(args) == 0 {
-			return errors.New("An S3 bucket name must be provided")
+		// Several log bucket folders may be given as sources in place of the arguments
+		err := parseSourceFlags(args)
+		if err != nil {
+			return err
+		}
+
+		// Otherwise there must be an S3 bucket name, given or taken from the profile
+		logBucket, sourceBuckets, from := "", []string(nil), sourceNames()
+		if len(logSources) == 0 {
+			args = profileArgs(args)
+			if len(args) == 0 {
+				return errors.New("An S3 bucket name must be provided")
+			}
+			logBucket, sourceBuckets, from = args[0], args[1:], args[0]+"/"+path
 		}
 
 		// Confirm that the content type requested is valid
-		err := validateContentType()
+		err = validateContentType()
 		if err != nil {
 			return err
 		}
 
-		// Parse the start time
-		startDateTime, err = time.Parse(time.RFC3339, startDateStr)
+		// Parse the start time and time window
+		err = parseWindowFlags()
 		if err != nil {
-			return fmt.Errorf("Invalid start date time: %w", err)
+			return err
 		}
 
-		// Parse the time window
-		window, err = parseTimeWindow(windowStr)
+		// Check the bot filtering flags
+		err = parseBotFlags()
 		if err != nil {
-			return fmt.Errorf("Invalid time window: %w", err)
+			return err
+		}
+
+		// And the GeoIP flags
+		err = parseGeoFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the anonymization flags
+		err = parseAnonymizeFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the host name lookup flags
+		err = parseResolveFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the color flags
+		err = parseColorFlags()
+		if err != nil {
+			return err
 		}
 
 		// Populate the SlogSession to wrap our parameters up for the run
 		slogSession = &s3.SlogSession{
-			Region:        region,
-			LogBucket:     args[0],
-			Folder:        path,
-			SourceBuckets: args[1:],
-			StartDateTime: startDateTime,
-			EndDateTime:   startDateTime.Add(window),
-			Content:       contentType,
+			Region:         region,
+			Endpoint:       endpoint,
+			Retry:          retryPolicy,
+			Cache:          logCache,
+			LogBucket:      logBucket,
+			Folder:         path,
+			SourceBuckets:  sourceBuckets,
+			Sources:        logSources,
+			StartDateTime:  startDateTime,
+			EndDateTime:    startDateTime.Add(window),
+			Content:        contentType,
+			BotFilter:      botFilter,
+			BotSignatures:  botSigFile,
+			GeoIPDatabases: geoIPFiles,
+			Countries:      countries,
+			Checkpoint:     progressFile,
+			Anonymize:      anonymizeMode,
+			AnonymizeKey:   anonymizeKey,
+			Resolve:        resolve,
+			Color:          useColor,
+			SlowRequest:    slowRequest,
 		}
 
 		// All is well with the command formating and AWS access (to the best of our present knowledge).
 		// Go ahead and do the work unless we are unit testing.
-		fmt.Printf("Reading logs from %v/%v for with start=%v, window=%v seconds\n",
-			args[0], path, startDateTime.Format(time.RFC3339), window.Seconds())
+		fmt.Printf("Reading logs from %v for with start=%v, window=%v seconds\n",
+			from, startDateTime.Format(time.RFC3339), window.Seconds())
 		if !unitTesting {
 			err = s3.DisplayLog(slogSession)
 		}
@@ -98,12 +180,7 @@ func init() {
 func initReadFlags() {
 
 	// Local flag definitions
-	readCmd.Flags().StringVar(&startDateStr, "start", "2020-01-01T00:00:00-00:00",
-		`Start date time in the form 2020-01-02T15:04:05Z07:00 form with time zone offset
-`)
-	readCmd.Flags().StringVar(&windowStr, "window", "1h",
-		`Time window in the days (d), hours (h), minutes (m) or seconds (s).
-For example '90s' for 90 seconds. '36h' for 36 hours.`)
+	addWindowFlags(readCmd)
 	readCmd.Flags().StringVar(&contentTypeStr, "content", "basic",
 		`Content to include in the log output; must be one of the following:
    basic     - minimal useful content, no bucket names, owners, request IDs etc
@@ -112,8 +189,306 @@ For example '90s' for 90 seconds. '36h' for 36 hours.`)
                logs from multiple buckets into one location)
    rich      - includes bucket, request ID, operation and key values
    raw       - the whole enchilada, as originally recorded by AWS;
-               ignores source bucket filtering; outputs all lines 
+               unchanged lines, but only those for the source buckets
+               if any are given
+   json      - every field, parsed into one JSON object per line, plus
+               the bot_class of entries made by bots
+`)
+	addBotFlags(readCmd)
+	addGeoFlags(readCmd)
+	addCheckpointFlag(readCmd)
+	addAnonymizeFlags(readCmd)
+	addResolveFlags(readCmd)
+	addColorFlags(readCmd)
+	readCmd.Flags().StringArrayVar(&fromStrs, "from", nil,
+		`A log bucket folder to read in the form log-bucket[/path][=source-bucket,...], in place
+of the arguments. The path defaults to the value of --path, and the entries are
+filtered for the source buckets if any are given. May be repeated to merge several,
+for example --from log.example.com/blog=example.com --from log.example.com/shop`)
+}
+
+// parseSourceFlags checks the --from flag values, setting the logSources global. Sources
+// cannot be given both as arguments and with --from.
+func parseSourceFlags(args []string) error {
+	logSources = nil
+	if len(fromStrs) == 0 {
+		return nil
+	}
+	if len(args) > 0 {
+		return errors.New("Log buckets must be given either as arguments or with --from, not both")
+	}
+	for _, fromStr := range fromStrs {
+
+		// Split off the source buckets, if there are any
+		source := s3.LogSource{Folder: strings.TrimSuffix(path, "/")}
+		location := fromStr
+		if i := strings.Index(fromStr, "="); i >= 0 {
+			location = fromStr[:i]
+			for _, sourceBucket := range strings.Split(fromStr[i+1:], ",") {
+				if len(sourceBucket) > 0 {
+					source.SourceBuckets = append(source.SourceBuckets, sourceBucket)
+				}
+			}
+		}
+
+		// Then the path from the bucket
+		parts := strings.SplitN(location, "/", 2)
+		source.LogBucket = parts[0]
+		if len(parts) > 1 {
+			source.Folder = strings.TrimSuffix(parts[1], "/")
+		}
+		if len(source.LogBucket) == 0 || len(source.Folder) == 0 {
+			return fmt.Errorf("Invalid source: %s", fromStr)
+		}
+		logSources = append(logSources, source)
+	}
+	return nil
+}
+
+// sourceNames returns the names of the sources given with --from, separated by commas
+func sourceNames() string {
+	names := make([]string, 0, len(logSources))
+	for _, source := range logSources {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// addResolveFlags defines the flags that control the reverse DNS lookups of remote IP addresses
+func addResolveFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&resolveHosts, "resolve", false,
+		`Look up the host names of remote IP addresses, adding them after the addresses,
+or as remote_host in json content`)
+	cmd.Flags().IntVar(&resolvePolicy.Workers, "resolve-workers", 8,
+		`The number of host name lookups to run at once`)
+	cmd.Flags().IntVar(&resolvePolicy.Rate, "resolve-rate", 50,
+		`The most host name lookups to start each second; zero for no limit`)
+	cmd.Flags().StringVar(&resolveTimeout, "resolve-timeout", "2s",
+		`The longest that any one host name lookup may take, in minutes (m) or seconds (s)`)
+	cmd.Flags().StringVar(&resolveBudget, "resolve-budget", "30s",
+		`The longest to spend waiting for host name lookups over the whole run, in hours (h),
+minutes (m) or seconds (s); once spent, only host names already known are shown`)
+	cmd.Flags().StringVar(&resolveTTL, "resolve-ttl", "7d",
+		`How long to remember the host names found, in days (d), hours (h), minutes (m) or seconds (s)`)
+	cmd.Flags().StringVar(&resolvePolicy.CacheFile, "resolve-cache", defaultResolveCacheFile(),
+		`The file in which to remember the host names found between runs; empty for none`)
+}
+
+// parseResolveFlags checks the host name lookup flag values, setting the resolve global.
+func parseResolveFlags() error {
+	resolve = nil
+	if !resolveHosts {
+		return nil
+	}
+	if anonymizeMode != s3.NOANONYMIZE {
+		return errors.New("Host names cannot be looked up for anonymized entries")
+	}
+	if contentType == s3.RAW {
+		return errors.New("Host names cannot be added to raw content")
+	}
+	var err error
+	resolvePolicy.Timeout, err = parseTimeWindow(resolveTimeout)
+	if err != nil {
+		return fmt.Errorf("Invalid resolve timeout: %w", err)
+	}
+	resolvePolicy.Budget, err = parseTimeWindow(resolveBudget)
+	if err != nil {
+		return fmt.Errorf("Invalid resolve budget: %w", err)
+	}
+	resolvePolicy.TTL, err = parseTimeWindow(resolveTTL)
+	if err != nil {
+		return fmt.Errorf("Invalid resolve TTL: %w", err)
+	}
+	resolve = &resolvePolicy
+	return nil
+}
+
+// addColorFlags defines the flags that control the highlighting of the Web log display
+func addColorFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&colorStr, "color", "auto",
+		`When to color the output: auto, when displayed on a terminal and the NO_COLOR
+environment variable is not set, always or never. Status codes are colored by class,
+error codes in red, slow requests highlighted and every other log object shaded;
+raw and json content are never colored`)
+	cmd.Flags().StringVar(&slowStr, "slow", "1s",
+		`The total time at or beyond which colored requests are highlighted as slow, as a
+number with a unit of ms, s or m, e.g. 500ms or 1.5s`)
+}
+
+// parseColorFlags checks the color flag values, setting the useColor and slowRequest globals.
+// Unlike the time windows, the slow time is parsed as a Go duration, since request times are
+// recorded to the millisecond and are usually well under a second.
+func parseColorFlags() error {
+	switch colorStr {
+	case "auto":
+		useColor = stdoutIsTerminal() && len(os.Getenv("NO_COLOR")) == 0
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	default:
+		return fmt.Errorf("Unrecognized color: %s", colorStr)
+	}
+	var err error
+	slowRequest, err = time.ParseDuration(slowStr)
+	if err != nil {
+		return fmt.Errorf("Invalid slow time: %w", err)
+	}
+	if slowRequest <= 0 {
+		return fmt.Errorf("Invalid slow time: %s is not greater than zero", slowStr)
+	}
+	return nil
+}
+
+var (
+	stdoutIsTerminal = isTerminal // Tests whether standard output is a terminal; can be overridden for unit testing
+)
+
+// isTerminal tests whether standard output is a terminal rather than a file or pipe
+func isTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// defaultResolveCacheFile returns the file in which host names are remembered unless the
+// user asks otherwise, or an empty string if the system has no cache directory.
+func defaultResolveCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "slog-hosts.json")
+}
+
+// addAnonymizeFlags defines the --anonymize and --anonymize-key flags on a command whose
+// output might be shared.
+func addAnonymizeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&anonymizeStr, "anonymize", "",
+		`Obscure personal details so that the output can be shared; must be one of the following:
+   truncate - remote IPv4 addresses are truncated to /24, IPv6 to /48
+   hash     - remote IP addresses are replaced by a keyed hash, see --anonymize-key
+Either way, requesters, host IDs and query strings are redacted`)
+	cmd.Flags().StringVar(&anonymizeKey, "anonymize-key", "",
+		`The secret key with which --anonymize hash hashes remote IP addresses; the
+same key gives the same pseudonyms across runs`)
+}
+
+// parseAnonymizeFlags checks the anonymization flag values, setting the anonymizeMode global.
+func parseAnonymizeFlags() error {
+	switch anonymizeStr {
+	case "":
+		anonymizeMode = s3.NOANONYMIZE
+	case "truncate":
+		anonymizeMode = s3.TRUNCATE
+	case "hash":
+		anonymizeMode = s3.HASH
+		if len(anonymizeKey) == 0 {
+			return errors.New("An anonymization key must be provided with --anonymize-key")
+		}
+	default:
+		return fmt.Errorf("Unrecognized anonymization: %s", anonymizeStr)
+	}
+	return nil
+}
+
+// addCheckpointFlag defines the --checkpoint flag on a command whose runs can be resumed
+// if they are interrupted.
+func addCheckpointFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&progressFile, "checkpoint", "",
+		`The name of a file in which to record the last log object processed. If the
+file exists, the run resumes after that object; delete it to start over`)
+}
+
+// addBotFlags defines the flags that control the filtering of log entries made by bots
+// on a command that reads log entries.
+func addBotFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&excludeBots, "exclude-bots", false,
+		`Exclude entries made by bots, crawlers and other automated clients`)
+	cmd.Flags().BoolVar(&onlyBots, "only-bots", false,
+		`Only include entries made by bots, crawlers and other automated clients`)
+	cmd.Flags().StringVar(&botSigFile, "bot-signatures", "",
+		`The name of a file of bot user agent signatures to use in place of the
+built in list. Each line holds a bot class followed by a case insensitive
+user agent substring, e.g. 'search googlebot'`)
+}
+
+// parseBotFlags checks the bot filtering flag values, setting the botFilter global.
+func parseBotFlags() error {
+	switch {
+	case excludeBots && onlyBots:
+		return errors.New("Only one of --exclude-bots and --only-bots may be given")
+	case excludeBots:
+		botFilter = s3.EXCLUDEBOTS
+	case onlyBots:
+		botFilter = s3.ONLYBOTS
+	default:
+		botFilter = s3.ALLTRAFFIC
+	}
+	return nil
+}
+
+// addGeoFlags defines the flags that control the locating of visitors, and the filtering
+// of log entries by the countries they come from
+func addGeoFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&geoIPFiles, "geoip-db", nil,
+		`The name of a local MaxMind GeoIP database, e.g. GeoLite2-City.mmdb, with which
+to locate visitors; may be repeated to add, for example, GeoLite2-ASN.mmdb`)
+	cmd.Flags().StringSliceVar(&countryStrs, "country", nil,
+		`Only include entries made from the given countries, as ISO codes such as DE;
+may be repeated or comma separated and requires --geoip-db`)
+}
+
+// parseGeoFlags checks the GeoIP flag values, setting the countries global.
+func parseGeoFlags() error {
+	if len(countryStrs) > 0 && len(geoIPFiles) == 0 {
+		return errors.New("A GeoIP database must be provided with --geoip-db to filter by country")
+	}
+	countries = make([]string, 0, len(countryStrs))
+	for _, country := range countryStrs {
+		countries = append(countries, strings.ToUpper(strings.TrimSpace(country)))
+	}
+	return nil
+}
+
+// addWindowFlags defines the --start and --window flags on a command that operates
+// over a window of time.
+func addWindowFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&startDateStr, "start", "2020-01-01T00:00:00-00:00",
+		`Start date time in the form 2020-01-02T15:04:05Z07:00 form with time zone offset,
+or in the form 2020-01-02T15:04:05 in the --timezone time zone
 `)
+	cmd.Flags().StringVar(&windowStr, "window", "1h",
+		`Time window in the days (d), hours (h), minutes (m) or seconds (s).
+For example '90s' for 90 seconds. '36h' for 36 hours.`)
+}
+
+// localTimeFormat is the layout of --start values given without a time zone offset
+const localTimeFormat = "2006-01-02T15:04:05"
+
+// parseWindowFlags parses the --start and --window flag values, setting the
+// startDateTime and window globals.
+func parseWindowFlags() error {
+
+	// Parse the start time
+	var err error
+	startDateTime, err = time.Parse(time.RFC3339, startDateStr)
+	if err != nil {
+
+		// Without an offset, the time is taken to be in the --timezone time zone
+		var zoneErr error
+		startDateTime, zoneErr = time.ParseInLocation(localTimeFormat, startDateStr, timeZone)
+		if zoneErr != nil {
+			return fmt.Errorf("Invalid start date time: %w", err)
+		}
+	}
+
+	// Parse the time window
+	window, err = parseTimeWindow(windowStr)
+	if err != nil {
+		return fmt.Errorf("Invalid time window: %w", err)
+	}
+
+	return nil
 }
 
 // Parse a time window string into a duration
@@ -162,6 +537,8 @@ func validateContentType() error {
 		contentType = s3.RICH
 	case "raw":
 		contentType = s3.RAW
+	case "json":
+		contentType = s3.JSON
 	default:
 		return fmt.Errorf("Unrecognized content type: %s", contentTypeStr)
 	}
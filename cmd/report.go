@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportFile string // flag value naming the HTML file to write the report to
+)
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report log-bucket [source-bucket*]",
+	Short: "Write an HTML traffic report from S3 hosted web logs for a given time window",
+	Long: `Given a start date and time, together with a time window, reads the S3 hosted web
+logs from a specified bucket and writes a traffic report to a single, self contained,
+HTML file. The report summarizes requests and bytes sent over time, the top pages,
+referrers and user agents, the HTTP status codes returned and the requests for keys
+that do not exist. Optionally, filters the log data to only include those entries that
+match the list of source buckets.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// There must be somewhere to write the report
+		if len(reportFile) == 0 {
+			return errors.New("An output file must be provided with --out")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// Check the bot filtering flags
+		err = parseBotFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the GeoIP flags
+		err = parseGeoFlags()
+		if err != nil {
+			return err
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:         region,
+			Endpoint:       endpoint,
+			Retry:          retryPolicy,
+			Checkpoint:     progressFile,
+			Cache:          logCache,
+			LogBucket:      args[0],
+			Folder:         path,
+			SourceBuckets:  args[1:],
+			StartDateTime:  startDateTime,
+			EndDateTime:    startDateTime.Add(window),
+			BotFilter:      botFilter,
+			BotSignatures:  botSigFile,
+			GeoIPDatabases: geoIPFiles,
+			Countries:      countries,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.WriteReport(slogSession, reportFile, siteHosts)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	// Initialize the flags that apply to the report command
+	initReportFlags()
+}
+
+// initReportFlags is called from init() to define the flags that apply to the report command.
+// It is defined separately from init() so that it can be invoked by unit tests when they need
+// to reset the playing field.
+func initReportFlags() {
+
+	// Local flag definitions
+	addWindowFlags(reportCmd)
+	addCheckpointFlag(reportCmd)
+	reportCmd.Flags().StringVar(&reportFile, "out", "report.html",
+		`The HTML file to write the report to`)
+	reportCmd.Flags().StringArrayVar(&siteHosts, "host", nil,
+		`A host name of our own web site, used to recognize internal referrers.
+May be repeated; for example --host example.com --host www.example.com`)
+	addBotFlags(reportCmd)
+	addGeoFlags(reportCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+// Unit tests for the report command line parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareReportCommand examines the case where a report command is requested
+// but no parameters are provided
+func TestBareReportCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("report")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestReportCommand confirms that the output file and site hosts are parsed
+func TestReportCommand(t *testing.T) {
+
+	// The defaults
+	executeCommand("report", "my-bucket", "source-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum report command line")
+	require.Equal(t, "report.html", reportFile, "Default output file set incorrectly")
+	require.Equal(t, []string{"source-bucket"}, slogSession.SourceBuckets, "Source buckets set incorrectly")
+
+	// Explicit values
+	executeCommand("report", "my-bucket", "--out", "weekly.html", "--host", "example.com")
+	require.Nil(t, executeError, "error seen parsing report command line")
+	require.Equal(t, "weekly.html", reportFile, "Output file set incorrectly")
+	require.Equal(t, []string{"example.com"}, siteHosts, "Site hosts set incorrectly")
+
+	// An empty output file
+	executeCommand("report", "my-bucket", "--out", "")
+	require.NotNil(t, executeError, "an empty output file should have been rejected")
+	require.Equal(t, "An output file must be provided with --out", executeError.Error(), "Expected output file required error")
+}
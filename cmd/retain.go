@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	keepStrs   []string // flag values of the form log-bucket[/path]=age defining retention rules
+	policyFile string   // the name of a YAML file defining retention rules
+	dryRun     bool     // when true, report what would be deleted but do not delete anything
+
+	// The retention rules assembled from the flags and policy file, held as
+	// a global so that they can be checked by unit test code
+	retentionRules []s3.RetentionRule
+)
+
+// retentionPolicy is the layout of a YAML retention policy file
+type retentionPolicy struct {
+	Rules []struct {
+		Bucket string `yaml:"bucket"` // The log bucket name
+		Path   string `yaml:"path"`   // The log folder path within the bucket, defaults to --path
+		Keep   string `yaml:"keep"`   // How long to keep the logs, e.g. 30d
+	} `yaml:"rules"`
+}
+
+// retainCmd represents the retain command
+var retainCmd = &cobra.Command{
+	Use:   "retain",
+	Short: "Delete S3 hosted web logs that are older than a retention policy allows",
+	Long: `Applies a retention policy across one or more log buckets and paths, deleting
+the web log objects that are older than the policy allows for their location. The age
+of each object is taken from the timestamp embedded in its key.
+
+Rules may be given with the --keep flag, as a policy file, or both. A policy file
+is written in YAML as follows:
+
+  rules:
+    - bucket: log.example.com
+      path: root
+      keep: 30d
+    - bucket: log.example.com
+      path: archive
+      keep: 365d`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// Assemble the rules from the policy file and the command line
+		var err error
+		retentionRules, err = loadRetentionRules()
+		if err != nil {
+			return err
+		}
+
+		// There must be something to do
+		if len(retentionRules) == 0 {
+			return errors.New("At least one retention rule must be provided with --keep or --policy")
+		}
+
+		// Populate the SlogSession with the parameters shared by all the rules
+		slogSession = &s3.SlogSession{
+			Region:     region,
+			Endpoint:   endpoint,
+			Retry:      retryPolicy,
+			Checkpoint: progressFile,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.ApplyRetention(slogSession, retentionRules, dryRun)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(retainCmd)
+
+	// Initialize the flags that apply to the retain command
+	initRetainFlags()
+}
+
+// initRetainFlags is called from init() to define the flags that apply to the retain
+// command. It is defined separately from init() so that it can be invoked by unit tests
+// when they need to reset the playing field.
+func initRetainFlags() {
+
+	// Local flag definitions
+	retainCmd.Flags().StringArrayVar(&keepStrs, "keep", nil,
+		`A retention rule in the form log-bucket[/path]=age where the age is given in
+days (d), hours (h), minutes (m) or seconds (s). The path defaults to the value
+of --path. May be repeated; for example --keep log.example.com/root=30d`)
+	retainCmd.Flags().StringVar(&policyFile, "policy", "",
+		`The name of a YAML file defining retention rules`)
+	retainCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		`Report what would be deleted without deleting anything`)
+	addCheckpointFlag(retainCmd)
+}
+
+// loadRetentionRules assembles the retention rules defined by the policy file, if there
+// is one, followed by those given with the --keep flag.
+func loadRetentionRules() ([]s3.RetentionRule, error) {
+
+	rules := make([]s3.RetentionRule, 0)
+
+	// Load the policy file first
+	if len(policyFile) > 0 {
+		content, err := ioutil.ReadFile(policyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read policy file: %w", err)
+		}
+		policy := retentionPolicy{}
+		err = yaml.UnmarshalStrict(content, &policy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid policy file: %w", err)
+		}
+		for _, r := range policy.Rules {
+			rule, err := newRetentionRule(r.Bucket, r.Path, r.Keep)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid policy file: %w", err)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	// Then add any rules from the command line
+	for _, keepStr := range keepStrs {
+		i := strings.LastIndex(keepStr, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid retention rule: %s", keepStr)
+		}
+		location := keepStr[:i]
+		bucket, folder := location, ""
+		if j := strings.Index(location, "/"); j >= 0 {
+			bucket, folder = location[:j], location[j+1:]
+		}
+		rule, err := newRetentionRule(bucket, folder, keepStr[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid retention rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// newRetentionRule validates and packages the elements of a retention rule. An empty
+// folder defaults to the value of the --path flag.
+func newRetentionRule(bucket, folder, keep string) (s3.RetentionRule, error) {
+
+	// There must be a bucket name
+	if len(bucket) == 0 {
+		return s3.RetentionRule{}, errors.New("An S3 bucket name must be provided")
+	}
+
+	// Default the folder
+	if len(folder) == 0 {
+		folder = path
+	}
+
+	// The age limit is expressed in the same way as a read time window
+	maxAge, err := parseTimeWindow(keep)
+	if err != nil {
+		return s3.RetentionRule{}, fmt.Errorf("%s: %w", keep, err)
+	}
+
+	// An age of zero or less would put every log object in the folder past the limit
+	if maxAge <= 0 {
+		return s3.RetentionRule{}, fmt.Errorf("%s: The age must be greater than zero", keep)
+	}
+
+	return s3.RetentionRule{
+		LogBucket: bucket,
+		Folder:    strings.TrimSuffix(folder, "/"),
+		MaxAge:    maxAge,
+	}, nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+// Unit tests for the retain command line parser
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareRetainCommand examines the case where a retain command is requested
+// without any rules
+func TestBareRetainCommand(t *testing.T) {
+
+	// Run the command
+	output := executeCommand("retain")
+
+	// We should have a rule required error but no usage displayed
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "At least one retention rule must be provided with --keep or --policy",
+		executeError.Error(), "Expected retention rule required error")
+	require.Empty(t, output, "Expected no usage display")
+}
+
+// TestRetainKeepRules confirms that --keep flag values are parsed into retention rules
+func TestRetainKeepRules(t *testing.T) {
+
+	// The following should parse happily
+	executeCommand("retain", "--keep", "log-bucket/raw=30d", "--keep", "log-bucket=12h", "--dry-run")
+	require.Nil(t, executeError, "error seen parsing retain command line")
+	require.True(t, dryRun, "Dry run flag should have been set")
+	require.Equal(t, 2, len(retentionRules), "Expected two retention rules")
+	require.Equal(t, "log-bucket", retentionRules[0].LogBucket, "First rule bucket set incorrectly")
+	require.Equal(t, "raw", retentionRules[0].Folder, "First rule folder set incorrectly")
+	require.Equal(t, 30*24*time.Hour, retentionRules[0].MaxAge, "First rule age set incorrectly")
+	require.Equal(t, "root", retentionRules[1].Folder, "Second rule folder should have defaulted to --path")
+	require.Equal(t, 12*time.Hour, retentionRules[1].MaxAge, "Second rule age set incorrectly")
+	require.Empty(t, slogSession.Checkpoint, "Checkpointing should be off by default")
+
+	// With a checkpoint
+	executeCommand("retain", "--keep", "log-bucket=30d", "--checkpoint", "retain.json")
+	require.Nil(t, executeError, "error seen parsing retain command line with --checkpoint")
+	require.Equal(t, "retain.json", slogSession.Checkpoint, "Checkpoint file set incorrectly")
+}
+
+// TestRetainBadKeepRules confirms that malformed --keep flag values are rejected
+func TestRetainBadKeepRules(t *testing.T) {
+
+	// No age at all
+	executeCommand("retain", "--keep", "log-bucket/raw")
+	require.NotNil(t, executeError, "a rule without an age should have been rejected")
+	require.Equal(t, "Invalid retention rule: log-bucket/raw", executeError.Error(), "Expected invalid rule error")
+
+	// An unparsable age
+	executeCommand("retain", "--keep", "log-bucket/raw=forever")
+	require.NotNil(t, executeError, "a rule with an invalid age should have been rejected")
+	require.Contains(t, executeError.Error(), "forever", "error description did not contain the bad age")
+
+	// Ages that would delete everything
+	executeCommand("retain", "--keep", "log-bucket/raw=0d")
+	require.NotNil(t, executeError, "a rule with a zero age should have been rejected")
+	require.Equal(t, "Invalid retention rule: 0d: The age must be greater than zero", executeError.Error(), "Expected zero age error")
+	executeCommand("retain", "--keep", "log-bucket/raw=-1d")
+	require.NotNil(t, executeError, "a rule with a negative age should have been rejected")
+	require.Equal(t, "Invalid retention rule: -1d: The age must be greater than zero", executeError.Error(), "Expected negative age error")
+
+	// No bucket name
+	executeCommand("retain", "--keep", "=30d")
+	require.NotNil(t, executeError, "a rule without a bucket should have been rejected")
+	require.Contains(t, executeError.Error(), "An S3 bucket name must be provided", "Expected bucket required error")
+}
+
+// TestRetainPolicyFile confirms that rules are loaded from a YAML policy file ahead
+// of those given on the command line
+func TestRetainPolicyFile(t *testing.T) {
+
+	// Write a policy file to a temporary location
+	file, err := ioutil.TempFile("", "slog-policy-*.yaml")
+	require.Nil(t, err, "failed to create temporary policy file: %v", err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`rules:
+  - bucket: log.example.com
+    path: root
+    keep: 30d
+  - bucket: log.example.com
+    path: archive/
+    keep: 365d
+`)
+	file.Close()
+	require.Nil(t, err, "failed to write temporary policy file: %v", err)
+
+	// Load it together with a command line rule
+	executeCommand("retain", "--policy", file.Name(), "--keep", "other-bucket/root=1d")
+	require.Nil(t, executeError, "error seen loading policy file: %v", executeError)
+	require.Equal(t, 3, len(retentionRules), "Expected three retention rules")
+	require.Equal(t, "log.example.com", retentionRules[0].LogBucket, "First rule bucket set incorrectly")
+	require.Equal(t, 30*24*time.Hour, retentionRules[0].MaxAge, "First rule age set incorrectly")
+	require.Equal(t, "archive", retentionRules[1].Folder, "Trailing slash should have been trimmed from the folder")
+	require.Equal(t, 365*24*time.Hour, retentionRules[1].MaxAge, "Second rule age set incorrectly")
+	require.Equal(t, "other-bucket", retentionRules[2].LogBucket, "Command line rule should have followed the file rules")
+}
+
+// TestRetainBadPolicyFile confirms that missing and malformed policy files are reported
+func TestRetainBadPolicyFile(t *testing.T) {
+
+	// A file that does not exist
+	executeCommand("retain", "--policy", "/there/is/no/such/policy.yaml")
+	require.NotNil(t, executeError, "a missing policy file should have been reported")
+	require.Contains(t, executeError.Error(), "Unable to read policy file", "Expected unreadable policy file error")
+
+	// A file with an unexpected field
+	file, err := ioutil.TempFile("", "slog-policy-*.yaml")
+	require.Nil(t, err, "failed to create temporary policy file: %v", err)
+	defer os.Remove(file.Name())
+	file.WriteString("rules:\n  - bucket: log.example.com\n    retain: 30d\n")
+	file.Close()
+	executeCommand("retain", "--policy", file.Name())
+	require.NotNil(t, executeError, "a malformed policy file should have been reported")
+	require.Contains(t, executeError.Error(), "Invalid policy file", "Expected invalid policy file error")
+
+	// A rule that would delete everything
+	zero, err := ioutil.TempFile("", "slog-policy-*.yaml")
+	require.Nil(t, err, "failed to create temporary policy file: %v", err)
+	defer os.Remove(zero.Name())
+	zero.WriteString("rules:\n  - bucket: log.example.com\n    keep: 0d\n")
+	zero.Close()
+	executeCommand("retain", "--policy", zero.Name())
+	require.NotNil(t, executeError, "a policy rule with a zero age should have been rejected")
+	require.Equal(t, "Invalid policy file: 0d: The age must be greater than zero", executeError.Error(), "Expected zero age error")
+}
@@ -6,10 +6,15 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mikebway/slog/s3"
 	"github.com/spf13/cobra"
 )
 
@@ -18,6 +23,24 @@ var (
 	executeError error   // The error value obtained by Execute(), captured for unit test purposes
 	region       string  // The AWS regon to target
 	path         string  // the log folder path within the S3 bucket
+	retryWaitStr string  // flag value defining the longest wait between retries of a failed S3 request
+	useCache     bool    // true if log objects are to be cached locally
+	cacheDir     string  // the directory in which log objects are cached
+	cacheSizeStr string  // flag value defining the most that the cache may hold
+	cacheMaxSize int64   // the most bytes that the cache may hold
+	endpoint     string  // the URL of an S3 compatible service to use in place of AWS
+	timeZoneStr  string  // flag value naming the time zone of --start values given without an offset
+	configFile   string  // the name of the configuration file defining profiles
+	profileName  string  // the name of the profile to apply
+
+	// The time zone of --start values given without an offset
+	timeZone = time.UTC
+
+	// The retry policy assembled from the flags, shared by every command
+	retryPolicy s3.RetryPolicy
+
+	// The cache of log objects, nil unless --cache was given, shared by every command that reads them
+	logCache *s3.LogCache
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -31,6 +54,35 @@ themselves served directly from S3.`,
 
 	SilenceUsage:  true, // Only display help when explicitly requested
 	SilenceErrors: true, // Only display errors once
+
+	// Parse the flags shared by every command before the command runs
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+
+		// Apply the profile first, so that the flags that follow see its values
+		err := applyProfile(cmd)
+		if err != nil {
+			return err
+		}
+		timeZone, err = time.LoadLocation(timeZoneStr)
+		if err != nil {
+			return fmt.Errorf("Invalid time zone: %w", err)
+		}
+		retryPolicy.MaxWait, err = parseTimeWindow(retryWaitStr)
+		if err != nil {
+			return fmt.Errorf("Invalid retry wait: %w", err)
+		}
+		cacheMaxSize, err = parseByteSize(cacheSizeStr)
+		if err != nil {
+			return fmt.Errorf("Invalid cache size: %w", err)
+		}
+		if useCache {
+			if len(cacheDir) == 0 {
+				return errors.New("A cache directory must be provided with --cache-dir")
+			}
+			logCache = &s3.LogCache{Dir: cacheDir, MaxSize: cacheMaxSize}
+		}
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -61,12 +113,75 @@ func initRootFlags() {
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&region, "region", "us-east-1", "the aws region to target")
 	rootCmd.PersistentFlags().StringVar(&path, "path", "root", `The path of the log data within the S3 bucket`)
+	rootCmd.PersistentFlags().StringVar(&endpoint, "endpoint", "",
+		`The URL of an S3 compatible service to use in place of AWS`)
+	rootCmd.PersistentFlags().StringVar(&timeZoneStr, "timezone", "UTC",
+		`The time zone, e.g. Europe/Berlin, of --start values given without an offset`)
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", defaultConfigFile(),
+		`The configuration file defining profiles`)
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile-name", "",
+		`The profile of default settings to apply from the configuration file; flags that are
+given override its settings`)
+	rootCmd.PersistentFlags().IntVar(&retryPolicy.Retries, "retries", 5,
+		`The number of times to retry S3 requests that are throttled or fail transiently`)
+	rootCmd.PersistentFlags().StringVar(&retryWaitStr, "retry-max-wait", "20s",
+		`The longest to wait before retrying a failed S3 request, in days (d), hours (h),
+minutes (m) or seconds (s)`)
+	rootCmd.PersistentFlags().BoolVar(&retryPolicy.SkipMissing, "skip-missing", false,
+		`Skip log objects that go missing between being listed and downloaded rather than
+giving up`)
+	rootCmd.PersistentFlags().BoolVar(&useCache, "cache", false,
+		`Keep local copies of the log objects read from S3, to read them again quickly or offline`)
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", defaultCacheDir(),
+		`The directory in which to keep cached log objects`)
+	rootCmd.PersistentFlags().StringVar(&cacheSizeStr, "cache-max-size", "1G",
+		`The most that the cache may hold, in bytes or with a K, M or G suffix; the least
+recently used log objects are removed to make room`)
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	//  rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// defaultCacheDir returns the directory in which log objects are cached unless the
+// user asks otherwise, or an empty string if the system has no cache directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "slog")
+}
+
+// parseByteSize parses a size given as a number of bytes, optionally followed by a K,
+// M or G suffix counting kibibytes, mebibytes or gibibytes.
+func parseByteSize(sstr string) (int64, error) {
+
+	// Work out the multiplier from the suffix, if there is one
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.ToUpper(sstr), "B")
+	if l := len(number); l > 0 {
+		switch number[l-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			number = number[:l-1]
+		}
+	}
+
+	// What is left must be a count
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Cannot parse size %s", sstr)
+	}
+	return n * multiplier, nil
+}
+
 // ============================================================================
 // The following ar provided to support unit tests. In particular, they allow
 // the tests for the main package to ensure that the environment is reset
@@ -117,16 +232,142 @@ func resetCommand() {
 	windowStr = ""
 	window = time.Duration(0)
 	contentTypeStr = ""
+	excludeBots = false
+	onlyBots = false
+	botSigFile = ""
+	botFilter = s3.ALLTRAFFIC
+	progressFile = ""
+	anonymizeStr = ""
+	anonymizeKey = ""
+	anonymizeMode = s3.NOANONYMIZE
+	geoIPFiles = nil
+	countryStrs = nil
+	countries = nil
+	resolveHosts = false
+	resolveTimeout = ""
+	resolveBudget = ""
+	resolveTTL = ""
+	resolvePolicy = s3.ResolvePolicy{}
+	resolve = nil
+	fromStrs = nil
+	logSources = nil
+	colorStr = ""
+	slowStr = ""
+	useColor = false
+	slowRequest = time.Duration(0)
 	slogSession = nil
 
+	// Reset retain command specific values
+	keepStrs = nil
+	policyFile = ""
+	dryRun = false
+	retentionRules = nil
+
+	// Reset lifecycle command specific values
+	expireStr = ""
+	expireDays = 0
+
+	// Reset ls command specific values
+	periodStr = ""
+	period = time.Duration(0)
+
+	// Reset health command specific values
+	maxGapStr = ""
+	maxDelayStr = ""
+	healthSince = ""
+	healthThresholds = s3.HealthThresholds{}
+
+	// Reset sessions command specific values
+	timeoutStr = ""
+	sessionTimeout = time.Duration(0)
+
+	// Reset 404s command specific values
+	siteHosts = nil
+	outputFormatStr = ""
+	outputFormat = s3.TEXT
+
+	// Reset latency command specific values
+	breakdownStrs = nil
+	breakdowns = nil
+	topCount = 0
+	displayHistogram = false
+
+	// Reset histogram command specific values
+	intervalStr = ""
+	interval = time.Duration(0)
+	splitStatus = false
+
+	// Reset report command specific values
+	reportFile = ""
+
+	// Reset serve command specific values
+	listenAddr = ""
+
+	// Reset exporter command specific values
+	metricsAddr = ""
+	checkpointFile = ""
+	pollStr = ""
+	pollInterval = time.Duration(0)
+
+	// Reset purge command specific values
+	purgeIPs = nil
+	purgeRequesters = nil
+	deleteVersions = false
+	purgeTarget = s3.PurgeTarget{}
+
+	// Reset query command specific values
+	queryDescription = ""
+	replaceQuery = false
+	sinceStr = ""
+
 	// Reset the global values
 	executeError = nil
 	region = ""
 	path = ""
+	retryWaitStr = ""
+	retryPolicy = s3.RetryPolicy{}
+	useCache = false
+	cacheDir = ""
+	cacheSizeStr = ""
+	cacheMaxSize = 0
+	logCache = nil
+	endpoint = ""
+	timeZoneStr = ""
+	timeZone = time.UTC
+	configFile = ""
+	profileName = ""
+	activeProfile = profile{}
 
 	// Clear and then re-initialize all the flags definitions
 	rootCmd.ResetFlags()
 	readCmd.ResetFlags()
+	retainCmd.ResetFlags()
+	lifecycleApplyCmd.ResetFlags()
+	lsCmd.ResetFlags()
+	healthCmd.ResetFlags()
+	sessionsCmd.ResetFlags()
+	notFoundCmd.ResetFlags()
+	latencyCmd.ResetFlags()
+	histogramCmd.ResetFlags()
+	reportCmd.ResetFlags()
+	serveCmd.ResetFlags()
+	exporterCmd.ResetFlags()
+	purgeCmd.ResetFlags()
+	querySaveCmd.ResetFlags()
+	queryRunCmd.ResetFlags()
 	initRootFlags()
 	initReadFlags()
+	initRetainFlags()
+	initLifecycleFlags()
+	initLsFlags()
+	initHealthFlags()
+	initSessionsFlags()
+	initNotFoundFlags()
+	initLatencyFlags()
+	initHistogramFlags()
+	initReportFlags()
+	initServeFlags()
+	initExporterFlags()
+	initPurgeFlags()
+	initQueryFlags()
 }
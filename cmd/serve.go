@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listenAddr string // flag value giving the address on which to serve the UI and API
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [log-bucket]",
+	Short: "Serve a local web UI and JSON API for browsing S3 hosted web logs",
+	Long: `Starts an HTTP server offering a small web UI in which to pick a log bucket, path,
+time window, content type and filters, and then watch the matching log entries stream in
+as they are fetched. The same logs are available to scripts through a JSON API; see the
+README for details. The log bucket, if given, and the --path are the initial selections.
+
+The server has no authentication of its own and acts with your AWS credentials, so it
+listens on the loopback interface unless told otherwise.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// The log bucket is optional, since it can be chosen in the UI
+		args = profileArgs(args)
+		logBucket := ""
+		if len(args) > 0 {
+			logBucket = args[0]
+		}
+
+		// Populate the SlogSession to wrap our defaults up for the run
+		slogSession = &s3.SlogSession{
+			Region:        region,
+			Endpoint:      endpoint,
+			Retry:         retryPolicy,
+			Cache:         logCache,
+			LogBucket:     logBucket,
+			Folder:        path,
+			BotSignatures: botSigFile,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			return s3.Serve(slogSession, listenAddr)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	// Initialize the flags that apply to the serve command
+	initServeFlags()
+}
+
+// initServeFlags is called from init() to define the flags that apply to the serve command.
+// It is defined separately from init() so that it can be invoked by unit tests when they need
+// to reset the playing field.
+func initServeFlags() {
+
+	// Local flag definitions
+	serveCmd.Flags().StringVar(&listenAddr, "listen", "127.0.0.1:8080",
+		`The address and port on which to serve the UI and API`)
+	serveCmd.Flags().StringVar(&botSigFile, "bot-signatures", "",
+		`The name of a file of bot user agent signatures to use in place of the
+built in list. Each line holds a bot class followed by a case insensitive
+user agent substring, e.g. 'search googlebot'`)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+// Unit tests for the serve command line parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestServeCommand confirms that the listen address and default log bucket are parsed
+func TestServeCommand(t *testing.T) {
+
+	// The defaults, without a bucket
+	executeCommand("serve")
+	require.Nil(t, executeError, "error seen parsing minimum serve command line")
+	require.Equal(t, "127.0.0.1:8080", listenAddr, "Default listen address set incorrectly")
+	require.Equal(t, "", slogSession.LogBucket, "No log bucket should have been set")
+
+	// Explicit values
+	executeCommand("serve", "my-bucket", "--listen", ":9090", "--path", "logs")
+	require.Nil(t, executeError, "error seen parsing serve command line")
+	require.Equal(t, ":9090", listenAddr, "Listen address set incorrectly")
+	require.Equal(t, "my-bucket", slogSession.LogBucket, "Log bucket set incorrectly")
+	require.Equal(t, "logs", slogSession.Folder, "Path set incorrectly")
+}
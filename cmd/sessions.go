@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mikebway/slog/s3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	timeoutStr     string        // flag value defining the period of inactivity that ends a visitor session
+	sessionTimeout time.Duration // the period of inactivity that ends a visitor session
+)
+
+// sessionsCmd represents the sessions command
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions log-bucket [source-bucket*]",
+	Short: "Reconstruct visitor sessions from S3 hosted web logs for a given time window",
+	Long: `Given a start date and time, together with a time window, reads the S3 hosted web
+logs from a specified bucket and groups the requests they record into visitor sessions.
+A visitor is identified by their remote IP address and user agent; a session ends when
+the visitor makes no requests for the --timeout period. For each session, displays the
+start time, duration, page count, landing and exit pages and the referrer of the landing
+page. Optionally, filters the log data to only include those entries that match the list
+of source buckets.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// There must be an S3 bucket name, given or taken from the profile
+		args = profileArgs(args)
+		if len(args) == 0 {
+			return errors.New("An S3 bucket name must be provided")
+		}
+
+		// Parse the start time and time window
+		err := parseWindowFlags()
+		if err != nil {
+			return err
+		}
+
+		// Check the bot filtering flags
+		err = parseBotFlags()
+		if err != nil {
+			return err
+		}
+
+		// And the GeoIP flags
+		err = parseGeoFlags()
+		if err != nil {
+			return err
+		}
+
+		// Parse the session timeout
+		sessionTimeout, err = parseTimeWindow(timeoutStr)
+		if err != nil {
+			return fmt.Errorf("Invalid session timeout: %w", err)
+		}
+		if sessionTimeout <= 0 {
+			return fmt.Errorf("Invalid session timeout: %s is not greater than zero", timeoutStr)
+		}
+
+		// Populate the SlogSession to wrap our parameters up for the run
+		slogSession = &s3.SlogSession{
+			Region:         region,
+			Endpoint:       endpoint,
+			Retry:          retryPolicy,
+			Checkpoint:     progressFile,
+			Cache:          logCache,
+			LogBucket:      args[0],
+			Folder:         path,
+			SourceBuckets:  args[1:],
+			StartDateTime:  startDateTime,
+			EndDateTime:    startDateTime.Add(window),
+			BotFilter:      botFilter,
+			BotSignatures:  botSigFile,
+			GeoIPDatabases: geoIPFiles,
+			Countries:      countries,
+		}
+
+		// Go ahead and do the work unless we are unit testing
+		if !unitTesting {
+			err = s3.DisplaySessions(slogSession, sessionTimeout)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+
+	// Initialize the flags that apply to the sessions command
+	initSessionsFlags()
+}
+
+// initSessionsFlags is called from init() to define the flags that apply to the sessions
+// command. It is defined separately from init() so that it can be invoked by unit tests
+// when they need to reset the playing field.
+func initSessionsFlags() {
+
+	// Local flag definitions
+	addWindowFlags(sessionsCmd)
+	addCheckpointFlag(sessionsCmd)
+	sessionsCmd.Flags().StringVar(&timeoutStr, "timeout", "30m",
+		`The period of inactivity that ends a visitor session, in days (d), hours (h),
+minutes (m) or seconds (s)`)
+	addBotFlags(sessionsCmd)
+	addGeoFlags(sessionsCmd)
+}
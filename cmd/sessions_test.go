@@ -0,0 +1,46 @@
+package cmd
+
+// Unit tests for the sessions command line parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBareSessionsCommand examines the case where a sessions command is requested
+// but no parameters are provided
+func TestBareSessionsCommand(t *testing.T) {
+
+	// Run the command
+	executeCommand("sessions")
+
+	// We should have a bucket required error
+	require.NotNil(t, executeError, "there should have been an error")
+	require.Equal(t, "An S3 bucket name must be provided", executeError.Error(), "Expected S3 bucket name required error")
+}
+
+// TestSessionsCommand confirms that the timeout and source bucket filters are parsed
+func TestSessionsCommand(t *testing.T) {
+
+	// The default timeout
+	executeCommand("sessions", "my-bucket")
+	require.Nil(t, executeError, "error seen parsing minimum sessions command line")
+	require.Equal(t, 30*time.Minute, sessionTimeout, "Default timeout set incorrectly")
+	require.Zero(t, len(slogSession.SourceBuckets), "Default of zero filter buckets set incorrectly")
+
+	// An explicit timeout with source bucket filtering
+	executeCommand("sessions", "my-bucket", "filter-bucket", "--timeout", "1h")
+	require.Nil(t, executeError, "error seen parsing sessions command line")
+	require.Equal(t, time.Hour, sessionTimeout, "Timeout set incorrectly")
+	require.Equal(t, []string{"filter-bucket"}, slogSession.SourceBuckets, "Filter buckets set incorrectly")
+
+	// A bad timeout
+	executeCommand("sessions", "my-bucket", "--timeout", "blargle")
+	require.NotNil(t, executeError, "an invalid timeout should have been rejected")
+	require.Equal(t, "Invalid session timeout: Cannot parse time window length", executeError.Error(), "Expected invalid timeout error")
+	executeCommand("sessions", "my-bucket", "--timeout", "0m")
+	require.NotNil(t, executeError, "a zero timeout should have been rejected")
+	require.Equal(t, "Invalid session timeout: 0m is not greater than zero", executeError.Error(), "Expected zero timeout error")
+}
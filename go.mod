@@ -4,6 +4,9 @@ go 1.14
 
 require (
 	github.com/aws/aws-sdk-go v1.29.27
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/spf13/cobra v0.0.6
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/sys v0.7.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
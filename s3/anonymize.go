@@ -0,0 +1,95 @@
+package s3
+
+// The functions in this file obscure the personal details recorded in web log
+// entries so that log excerpts can be shared.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+const (
+	redacted = "redacted" // Replaces the values of fields that are removed entirely
+
+	// The fields of a web log line that are anonymized, counting from zero
+	remoteIPField   = 3
+	requesterField  = 4
+	requestURIField = 8
+	hostIDField     = 18
+)
+
+// AnonymizeMode is an enumeration controlling how the remote IP addresses of web log entries are obscured
+type AnonymizeMode int
+
+// The possible values of AnonymizeMode; defaults to NOANONYMIZE
+const (
+	NOANONYMIZE AnonymizeMode = iota // Entries are displayed as recorded
+	TRUNCATE                         // IPv4 addresses are truncated to their /24 network, IPv6 to their /48
+	HASH                             // Addresses are replaced by a keyed hash, the same for every entry from the address
+)
+
+// anonymizeLine returns a web log line with the remote IP address obscured as the session
+// anonymize mode requires, and the requester, host ID and any query string in the Request-URI
+// redacted. All other fields are left exactly as they were recorded.
+func anonymizeLine(session *SlogSession, line string) string {
+
+	// Work backwards through the fields that need changing, so that replacing one does
+	// not move those still to come
+	spans := logLineSpans(line)
+	for _, i := range []int{hostIDField, requestURIField, requesterField, remoteIPField} {
+		if i >= len(spans) {
+			continue
+		}
+		start, end := spans[i][0], spans[i][1]
+		value := line[start:end]
+		if value == "-" {
+			continue
+		}
+		switch i {
+		case remoteIPField:
+			value = anonymizeIP(session, value)
+		case requestURIField:
+			value = redactQuery(value)
+		default:
+			value = redacted
+		}
+		line = line[:start] + value + line[end:]
+	}
+	return line
+}
+
+// anonymizeIP obscures an IP address as the session anonymize mode requires. Values that are not
+// IP addresses are hashed or redacted, since we cannot know what they might reveal.
+func anonymizeIP(session *SlogSession, address string) string {
+	if session.Anonymize == HASH {
+		mac := hmac.New(sha256.New, []byte(session.AnonymizeKey))
+		mac.Write([]byte(address))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return redacted
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// redactQuery removes the query string from the path of a Request-URI field value, which has
+// the form "GET /path?query HTTP/1.1"
+func redactQuery(requestURI string) string {
+	q := strings.IndexByte(requestURI, '?')
+	if q < 0 {
+		return requestURI
+	}
+	end := strings.IndexByte(requestURI[q:], ' ')
+	if end < 0 {
+		return requestURI[:q]
+	}
+	return requestURI[:q] + requestURI[q+end:]
+}
@@ -0,0 +1,73 @@
+package s3
+
+// Unit tests for the anonymization of web log entries
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAnonymizeLineTruncate confirms that personal details are removed from a log line while
+// everything else is left as it was
+func TestAnonymizeLineTruncate(t *testing.T) {
+	session := &SlogSession{Anonymize: TRUNCATE}
+	line := anonymizeLine(session, sampleLogLine)
+
+	entry, err := parseLogEntry(line)
+	require.Nil(t, err, "The anonymized line should still parse: %v", err)
+	require.Equal(t, "192.0.2.0", entry.RemoteIP, "Remote IP should have been truncated")
+	require.Equal(t, redacted, entry.Requester, "Requester should have been redacted")
+	require.Equal(t, redacted, entry.HostID, "Host ID should have been redacted")
+	require.Equal(t, "GET /awsexamplebucket1 HTTP/1.1", entry.RequestURI, "Query string should have been removed")
+
+	// Only those fields should have changed
+	original := splitLogLine(sampleLogLine)
+	anonymized := splitLogLine(line)
+	require.Equal(t, len(original), len(anonymized), "The number of fields should not have changed")
+	for i := range original {
+		switch i {
+		case remoteIPField, requesterField, requestURIField, hostIDField:
+		default:
+			require.Equal(t, original[i], anonymized[i], "Field %d should not have changed", i)
+		}
+	}
+}
+
+// TestAnonymizeIP examines the truncation and hashing of IPv4 and IPv6 addresses
+func TestAnonymizeIP(t *testing.T) {
+
+	// Truncation to /24 and /48 networks
+	session := &SlogSession{Anonymize: TRUNCATE}
+	require.Equal(t, "203.0.113.0", anonymizeIP(session, "203.0.113.77"), "IPv4 address truncated incorrectly")
+	require.Equal(t, "2001:db8:85a3::", anonymizeIP(session, "2001:db8:85a3:8d3:1319:8a2e:370:7348"), "IPv6 address truncated incorrectly")
+	require.Equal(t, redacted, anonymizeIP(session, "not-an-address"), "A value that is not an address should have been redacted")
+
+	// Hashes are consistent for a given key, and differ between addresses and keys
+	session = &SlogSession{Anonymize: HASH, AnonymizeKey: "secret"}
+	first := anonymizeIP(session, "203.0.113.77")
+	require.Len(t, first, 16, "Hashed address length incorrect")
+	require.Equal(t, first, anonymizeIP(session, "203.0.113.77"), "The same address should hash the same")
+	require.NotEqual(t, first, anonymizeIP(session, "203.0.113.78"), "Different addresses should hash differently")
+	require.NotEqual(t, first, anonymizeIP(&SlogSession{Anonymize: HASH, AnonymizeKey: "other"}, "203.0.113.77"),
+		"Different keys should hash differently")
+}
+
+// TestRedactQuery examines the removal of query strings from Request-URI values
+func TestRedactQuery(t *testing.T) {
+	require.Equal(t, "GET /index.html HTTP/1.1", redactQuery("GET /index.html?utm_source=mail HTTP/1.1"), "Query string not removed")
+	require.Equal(t, "GET /index.html HTTP/1.1", redactQuery("GET /index.html HTTP/1.1"), "A URI without a query should be unchanged")
+	require.Equal(t, "GET /index.html", redactQuery("GET /index.html?a=b"), "Query string not removed from a truncated URI")
+}
+
+// TestAnonymizeRawDisplay confirms that raw output is anonymized too
+func TestAnonymizeRawDisplay(t *testing.T) {
+	var out bytes.Buffer
+	session := &SlogSession{Content: RAW, Anonymize: TRUNCATE, Output: &out}
+	err := displaySelectLogData(session, strings.NewReader(sampleLogLine+"\n"))
+	require.Nil(t, err, "Displaying the log data should not have failed: %v", err)
+	require.Equal(t, anonymizeLine(session, sampleLogLine)+"\n", out.String(), "Raw output should have been anonymized")
+	require.NotContains(t, out.String(), "192.0.2.3", "The remote IP should not have been displayed")
+}
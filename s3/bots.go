@@ -0,0 +1,239 @@
+package s3
+
+// The functions in this file classify web log entries as having been made by
+// bots and crawlers rather than by humans.
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// BotFilter is an enumeration controlling whether log entries made by bots are displayed
+type BotFilter int
+
+// The possible values of BotFilter; defaults to ALLTRAFFIC
+const (
+	ALLTRAFFIC  BotFilter = iota // Entries are displayed regardless of who made them
+	EXCLUDEBOTS                  // Only entries believed to have been made by humans are displayed
+	ONLYBOTS                     // Only entries believed to have been made by bots are displayed
+)
+
+const (
+	botRateLimit  = 120              // The number of requests per botRatePeriod beyond which a remote IP is assumed to be a scraper
+	botRatePeriod = time.Minute      // The period over which botRateLimit is measured
+	botIdlePeriod = 30 * time.Minute // How long a remote IP that has stopped making requests is remembered
+)
+
+// defaultBotSignatures is the built in list of user agent signatures, in the same format as a
+// signature file: one signature per line, each being a bot class followed by a case insensitive
+// user agent substring. The first matching signature wins, so the generic catch-all signatures
+// come last.
+const defaultBotSignatures = `
+# Known vulnerability scanners
+scanner   nikto
+scanner   sqlmap
+scanner   nmap
+scanner   masscan
+scanner   zgrab
+scanner   nuclei
+scanner   wpscan
+scanner   acunetix
+scanner   nessus
+scanner   openvas
+scanner   censysinspect
+scanner   expanse
+
+# Headless and automated browsers
+headless  headlesschrome
+headless  phantomjs
+headless  puppeteer
+headless  playwright
+headless  selenium
+
+# Monitoring probes
+monitor   pingdom
+monitor   uptimerobot
+monitor   statuscake
+monitor   site24x7
+monitor   newrelicpinger
+monitor   datadog
+monitor   betteruptime
+monitor   amazon-route53-health-check
+
+# Search engines
+search    googlebot
+search    bingbot
+search    slurp
+search    duckduckbot
+search    baiduspider
+search    yandexbot
+search    applebot
+search    sogou
+search    seznambot
+search    petalbot
+
+# Scrapers, SEO tools and HTTP libraries
+scraper   ahrefsbot
+scraper   semrushbot
+scraper   mj12bot
+scraper   dotbot
+scraper   python-requests
+scraper   python-urllib
+scraper   curl/
+scraper   wget/
+scraper   go-http-client
+scraper   scrapy
+scraper   okhttp
+scraper   libwww-perl
+scraper   java/
+
+# Anything else that admits to being automated
+scraper   bot
+scraper   crawl
+scraper   spider
+`
+
+// botSignature pairs a user agent substring with the class of bot that it identifies
+type botSignature struct {
+	class   string // The bot class, e.g. search
+	pattern string // The lower case user agent substring
+}
+
+// botVisitor records what the heuristics have learned of the behavior of a remote IP
+type botVisitor struct {
+	class       string    // The class of bot that the heuristics have identified the remote IP as, empty if none
+	periodStart time.Time // The time of the first request of the current rate period
+	requests    int       // The number of requests made since periodStart
+	lastSeen    time.Time // The time of the latest request
+}
+
+// botDetector classifies log entries using user agent signatures together with heuristics based
+// on the behavior of each remote IP address. The heuristics are applied as entries stream past,
+// so an address is only classified as a bot from the point at which its behavior gives it away.
+//
+// Remote IPs that have made no requests for botIdlePeriod are forgotten, so that the memory
+// needed does not grow with the number of visitors over long time windows.
+type botDetector struct {
+	signatures []botSignature         // The user agent signatures, in the order that they are tested
+	visitors   map[string]*botVisitor // The behavior of each remote IP seen recently
+	latest     time.Time              // The time of the latest request seen
+	nextPrune  time.Time              // The time after which idle remote IPs are next looked for
+}
+
+// newBotDetector returns a bot detector using the signatures from the named file or, if no
+// file is named, the built in signatures.
+func newBotDetector(signatureFile string) (*botDetector, error) {
+
+	// Load the signature file if there is one
+	text := defaultBotSignatures
+	if len(signatureFile) > 0 {
+		content, err := ioutil.ReadFile(signatureFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read bot signature file: %w", err)
+		}
+		text = string(content)
+	}
+
+	// Parse the signatures
+	signatures, err := parseBotSignatures(text)
+	if err != nil {
+		return nil, err
+	}
+	return &botDetector{
+		signatures: signatures,
+		visitors:   make(map[string]*botVisitor),
+	}, nil
+}
+
+// parseBotSignatures parses bot signatures, one per line, each being a class name followed
+// by a user agent substring. Blank lines and lines beginning with # are ignored.
+func parseBotSignatures(text string) ([]botSignature, error) {
+	signatures := make([]botSignature, 0)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Invalid bot signature: %s", line)
+		}
+		pattern := strings.TrimSpace(line[len(fields[0]):])
+		signatures = append(signatures, botSignature{class: fields[0], pattern: strings.ToLower(pattern)})
+	}
+	return signatures, nil
+}
+
+// classify returns the class of bot that made the request recorded by a log entry, or an
+// empty string if the request appears to have been made by a human.
+func (d *botDetector) classify(entry *LogEntry) string {
+
+	// The user agent is the most reliable clue
+	userAgent := strings.ToLower(entry.UserAgent)
+	for _, signature := range d.signatures {
+		if strings.Contains(userAgent, signature.pattern) {
+			return signature.class
+		}
+	}
+
+	// Forget the remote IPs that have gone quiet
+	d.prune(entry.Time)
+	visitor := d.visitors[entry.RemoteIP]
+	if visitor == nil {
+		visitor = &botVisitor{periodStart: entry.Time}
+		d.visitors[entry.RemoteIP] = visitor
+	}
+	if entry.Time.After(visitor.lastSeen) {
+		visitor.lastSeen = entry.Time
+	}
+
+	// Humans' browsers always give a user agent, and humans do not read robots.txt
+	if len(userAgent) == 0 || entry.Key == "robots.txt" {
+		visitor.class = "scraper"
+	}
+
+	// Nor do humans make requests faster than they can read
+	if entry.Time.Sub(visitor.periodStart) > botRatePeriod {
+		visitor.periodStart = entry.Time
+		visitor.requests = 0
+	}
+	visitor.requests++
+	if visitor.requests > botRateLimit {
+		visitor.class = "scraper"
+	}
+
+	// Return whatever the heuristics have concluded about this address
+	return visitor.class
+}
+
+// prune forgets the remote IPs that have made no requests for botIdlePeriod, looking for them
+// no more than once every botRatePeriod of log time so that the cost is spread thinly.
+func (d *botDetector) prune(now time.Time) {
+	if now.After(d.latest) {
+		d.latest = now
+	}
+	if d.latest.Before(d.nextPrune) {
+		return
+	}
+	for ip, visitor := range d.visitors {
+		if d.latest.Sub(visitor.lastSeen) > botIdlePeriod {
+			delete(d.visitors, ip)
+		}
+	}
+	d.nextPrune = d.latest.Add(botRatePeriod)
+}
+
+// accepts returns true if entries of the given bot class, empty for humans, satisfy the filter
+func (f BotFilter) accepts(botClass string) bool {
+	switch f {
+	case EXCLUDEBOTS:
+		return len(botClass) == 0
+	case ONLYBOTS:
+		return len(botClass) > 0
+	}
+	return true
+}
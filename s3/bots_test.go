@@ -0,0 +1,118 @@
+package s3
+
+// Unit tests for the slog bot classification functions
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBotSignatures confirms that user agents are classified by the built in signatures
+func TestBotSignatures(t *testing.T) {
+
+	detector, err := newBotDetector("")
+	require.Nil(t, err, "The built in signatures should have loaded: %v", err)
+
+	classes := map[string]string{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":          "search",
+		"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)":                "scraper",
+		"Pingdom.com_bot_version_1.4_(http://www.pingdom.com/)":                             "monitor",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 HeadlessChrome/80.0.3987.0":     "headless",
+		"Mozilla/5.00 (Nikto/2.1.6) (Evasions:None) (Test:Port Check)":                      "scanner",
+		"Mozilla/5.0 (compatible; SomeNewCrawler/1.0)":                                      "scraper",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) AppleWebKit/605.1.15 Safari/605.1": "",
+	}
+	for userAgent, class := range classes {
+		entry := &LogEntry{RemoteIP: "192.0.2.1", UserAgent: userAgent, Key: "index.html"}
+		require.Equal(t, class, detector.classify(entry), "User agent classified incorrectly: %s", userAgent)
+	}
+}
+
+// TestBotHeuristics confirms that remote IPs are flagged by their behavior
+func TestBotHeuristics(t *testing.T) {
+
+	detector, err := newBotDetector("")
+	require.Nil(t, err, "The built in signatures should have loaded: %v", err)
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	browser := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) AppleWebKit/605.1.15 Safari/605.1"
+
+	// Fetching robots.txt gives the game away, for that request and all that follow
+	require.Equal(t, "", detector.classify(&LogEntry{RemoteIP: "192.0.2.1", UserAgent: browser, Key: "index.html", Time: start}),
+		"An ordinary page request should look human")
+	require.Equal(t, "scraper", detector.classify(&LogEntry{RemoteIP: "192.0.2.1", UserAgent: browser, Key: "robots.txt", Time: start}),
+		"Fetching robots.txt should look like a bot")
+	require.Equal(t, "scraper", detector.classify(&LogEntry{RemoteIP: "192.0.2.1", UserAgent: browser, Key: "index.html", Time: start}),
+		"Requests after fetching robots.txt should look like a bot")
+
+	// As does making requests too quickly
+	for i := 0; i < botRateLimit; i++ {
+		require.Equal(t, "", detector.classify(&LogEntry{RemoteIP: "192.0.2.2", UserAgent: browser, Key: "index.html", Time: start}),
+			"Requests within the rate limit should look human")
+	}
+	require.Equal(t, "scraper", detector.classify(&LogEntry{RemoteIP: "192.0.2.2", UserAgent: browser, Key: "index.html", Time: start}),
+		"Requests beyond the rate limit should look like a bot")
+
+	// Or not giving a user agent at all
+	require.Equal(t, "scraper", detector.classify(&LogEntry{RemoteIP: "192.0.2.3", Key: "index.html", Time: start}),
+		"Requests without a user agent should look like a bot")
+	require.Len(t, detector.visitors, 3, "Each remote IP should have been remembered")
+
+	// Remote IPs that go quiet are forgotten, while those still active are not
+	later := start.Add(botIdlePeriod + botRatePeriod)
+	detector.classify(&LogEntry{RemoteIP: "192.0.2.3", UserAgent: browser, Key: "index.html", Time: later.Add(-time.Minute)})
+	require.Equal(t, "", detector.classify(&LogEntry{RemoteIP: "192.0.2.1", UserAgent: browser, Key: "index.html", Time: later}),
+		"A remote IP idle for longer than the idle period should have been forgotten")
+	require.Len(t, detector.visitors, 2, "Idle remote IPs should have been forgotten")
+	require.Contains(t, detector.visitors, "192.0.2.3", "An active remote IP should have been remembered")
+}
+
+// TestBotSignatureFile confirms that the built in signatures can be replaced from a file
+func TestBotSignatureFile(t *testing.T) {
+
+	// Write a signature file to a temporary location
+	file, err := ioutil.TempFile("", "slog-bots-*.txt")
+	require.Nil(t, err, "failed to create temporary signature file: %v", err)
+	defer os.Remove(file.Name())
+	file.WriteString("# Our own monitoring\nmonitor   Our Uptime Checker\n")
+	file.Close()
+
+	// Our signature should be recognized and the built in ones should not
+	detector, err := newBotDetector(file.Name())
+	require.Nil(t, err, "The signature file should have loaded: %v", err)
+	require.Equal(t, "monitor", detector.classify(&LogEntry{RemoteIP: "192.0.2.1", UserAgent: "our uptime checker/1.0"}),
+		"Our signature should have been matched")
+	require.Equal(t, "", detector.classify(&LogEntry{RemoteIP: "192.0.2.2", UserAgent: "Googlebot/2.1"}),
+		"The built in signatures should have been replaced")
+
+	// Missing and malformed files are reported
+	_, err = newBotDetector("/there/is/no/such/file.txt")
+	require.NotNil(t, err, "A missing signature file should have been reported")
+	_, err = parseBotSignatures("search\n")
+	require.NotNil(t, err, "A signature without a pattern should have been rejected")
+}
+
+// TestBotFilter checks which bot classes each filter accepts
+func TestBotFilter(t *testing.T) {
+	require.True(t, ALLTRAFFIC.accepts(""), "All traffic should accept humans")
+	require.True(t, ALLTRAFFIC.accepts("search"), "All traffic should accept bots")
+	require.True(t, EXCLUDEBOTS.accepts(""), "Excluding bots should accept humans")
+	require.False(t, EXCLUDEBOTS.accepts("search"), "Excluding bots should reject bots")
+	require.False(t, ONLYBOTS.accepts(""), "Only bots should reject humans")
+	require.True(t, ONLYBOTS.accepts("search"), "Only bots should accept bots")
+}
+
+// TestJSONContent confirms that structured output includes the bot class
+func TestJSONContent(t *testing.T) {
+	entry, err := parseLogEntry(sampleLogLine)
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	content := jsonContent(jsonEntry{LogEntry: entry, BotClass: "monitor"})
+	require.True(t, strings.HasPrefix(content, "{"), "JSON content should be an object")
+	require.Contains(t, content, `"bucket":"awsexamplebucket1"`, "JSON content should include the bucket")
+	require.Contains(t, content, `"bot_class":"monitor"`, "JSON content should include the bot class")
+	require.NotContains(t, jsonContent(jsonEntry{LogEntry: entry}), "bot_class", "JSON content should omit the bot class of humans")
+}
@@ -0,0 +1,103 @@
+package s3
+
+// The functions in this file select web log entries by the source bucket to which
+// the request was made.
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// bucketFilter selects web log entries by the name of the source bucket to which the request was
+// made. A bucket is accepted if it matches any of the include patterns, or if there are none,
+// and matches none of the exclude patterns.
+type bucketFilter struct {
+	include []bucketPattern // The patterns of which a bucket must match one, if there are any
+	exclude []bucketPattern // The patterns of which a bucket must match none
+}
+
+// bucketPattern tests whether a source bucket name matches a pattern
+type bucketPattern func(bucket string) bool
+
+// newBucketFilter compiles the source bucket patterns of a session, returning nil if there are none.
+// Each pattern is one of:
+//
+//	example.com          a bucket name, matched exactly
+//	assets-*.example.com a glob, in which * matches any run of characters, ? any one and [a-z] any in the set
+//	/^assets-[0-9]+\./   a regular expression, between slashes
+//
+// and any of them may be preceded by ! to exclude the buckets that it matches instead.
+//
+// An error is returned if a glob or regular expression is malformed.
+func newBucketFilter(patterns []string) (*bucketFilter, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	filter := &bucketFilter{}
+	for _, pattern := range patterns {
+
+		// Negated patterns exclude what they match
+		negated := strings.HasPrefix(pattern, "!")
+		match, err := compileBucketPattern(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid source bucket pattern %s: %w", pattern, err)
+		}
+		if negated {
+			filter.exclude = append(filter.exclude, match)
+		} else {
+			filter.include = append(filter.include, match)
+		}
+	}
+	return filter, nil
+}
+
+// compileBucketPattern returns the function that tests for a match with a single, un-negated, pattern
+func compileBucketPattern(pattern string) (bucketPattern, error) {
+
+	// Bucket names cannot contain slashes, so a pattern between them can only be a regular expression
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	// Nor can they contain glob characters, so without any the name is matched exactly
+	if !strings.ContainsAny(pattern, "*?[") {
+		return func(bucket string) bool { return bucket == pattern }, nil
+	}
+
+	// Reject a malformed glob now, rather than have every match with it quietly fail
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(bucket string) bool {
+		matched, _ := path.Match(pattern, bucket)
+		return matched
+	}, nil
+}
+
+// accepts tests whether entries made to the given source bucket are to be included. A nil filter
+// accepts every bucket.
+func (f *bucketFilter) accepts(bucket string) bool {
+	if f == nil {
+		return true
+	}
+	for _, match := range f.exclude {
+		if match(bucket) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, match := range f.include {
+		if match(bucket) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package s3
+
+// Unit tests for selecting web log entries by their source bucket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBucketFilter examines the matching of source buckets against names, globs, regular expressions
+// and negations of them
+func TestBucketFilter(t *testing.T) {
+
+	// No patterns, no filtering
+	filter, err := newBucketFilter(nil)
+	require.Nil(t, err, "An empty filter should have compiled: %v", err)
+	require.Nil(t, filter, "An empty filter should be nil")
+	require.True(t, filter.accepts("example.com"), "A nil filter should accept every bucket")
+
+	// Names and globs
+	filter, err = newBucketFilter([]string{"example.com", "assets-*.example.com", "cdn?.example.com"})
+	require.Nil(t, err, "The filter should have compiled: %v", err)
+	require.True(t, filter.accepts("example.com"), "An exact name should match")
+	require.True(t, filter.accepts("assets-eu.example.com"), "A glob should match")
+	require.True(t, filter.accepts("cdn1.example.com"), "A single character wildcard should match")
+	require.False(t, filter.accepts("cdn10.example.com"), "A single character wildcard should match only one")
+	require.False(t, filter.accepts("www.example.com"), "Unmatched buckets should be rejected")
+
+	// Regular expressions, with negations taking precedence
+	filter, err = newBucketFilter([]string{`/^assets-[0-9]+\./`, "!assets-9*"})
+	require.Nil(t, err, "The filter should have compiled: %v", err)
+	require.True(t, filter.accepts("assets-1.example.com"), "A regular expression should match")
+	require.False(t, filter.accepts("assets-eu.example.com"), "A regular expression should not match too much")
+	require.False(t, filter.accepts("assets-99.example.com"), "A negation should exclude what it matches")
+
+	// Only negations accept everything else
+	filter, err = newBucketFilter([]string{"!staging-*", "!/test/"})
+	require.Nil(t, err, "The filter should have compiled: %v", err)
+	require.True(t, filter.accepts("example.com"), "Buckets not negated should be accepted")
+	require.False(t, filter.accepts("staging-www.example.com"), "A negated glob should exclude")
+	require.False(t, filter.accepts("www.test.example.com"), "A negated regular expression should exclude")
+
+	// Malformed patterns
+	_, err = newBucketFilter([]string{"assets-[.example.com"})
+	require.NotNil(t, err, "A malformed glob should have been rejected")
+	require.Contains(t, err.Error(), "Invalid source bucket pattern assets-[.example.com", "Expected invalid glob error")
+	_, err = newBucketFilter([]string{"!/assets-(/"})
+	require.NotNil(t, err, "A malformed regular expression should have been rejected")
+	require.Contains(t, err.Error(), "Invalid source bucket pattern !/assets-(/", "Expected invalid regular expression error")
+}
+
+// TestBucketFilterRawDisplay confirms that raw content is filtered for source buckets when asked
+func TestBucketFilterRawDisplay(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	wanted := testLogLine(testEntry{bucket: "assets-eu.example.com", time: now, remoteIP: "192.0.2.1", status: 200})
+	unwanted := testLogLine(testEntry{bucket: "staging-www.example.com", time: now, remoteIP: "192.0.2.2", status: 200})
+
+	session := &SlogSession{Content: RAW, Output: &out}
+	session.buckets, _ = newBucketFilter([]string{"!staging-*"})
+	dataChan := make(chan *logObject, 1)
+	dataChan <- &logObject{session: session, key: "root/2020-03-20-13-00-00-A",
+		body: ioutil.NopCloser(strings.NewReader(wanted + "\n" + unwanted + "\n"))}
+	close(dataChan)
+	doneChan := make(chan struct{})
+	displayLogData(session, dataChan, doneChan, make(chan error, 1))
+	<-doneChan
+	require.Equal(t, wanted+"\n", out.String(), "Raw content should have been filtered for source buckets")
+}
@@ -0,0 +1,404 @@
+package s3
+
+// The functions in this file keep local copies of the web log objects read from
+// S3 so that reading them again is quick, and possible without a network connection.
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	cacheTempPrefix = ".slog-" // The prefix of the files that objects are written to until they have been read in full
+	cacheLowWater   = 90       // The percentage of its size limit to which eviction reduces the cache, so that it is not needed again for a while
+)
+
+// LogCache is an on disk cache of web log objects. Log objects never change once they have
+// been written, so each is filed under its bucket and key, suffixed with its ETag, and can be
+// read from the cache rather than S3 for as long as it is kept.
+type LogCache struct {
+	Dir     string // The directory holding the cached objects
+	MaxSize int64  // The most bytes to keep; the least recently used objects are evicted beyond this, zero for no limit
+
+	mutex    sync.Mutex // Guards the running total, since objects can be added by several pipelines at once
+	total    int64      // The number of bytes held in the cache, once measured
+	measured bool       // True once the cache has been measured, after which the total is kept up to date
+}
+
+// cacheFile describes an object held in the cache
+type cacheFile struct {
+	path    string    // The path of the file holding the object
+	bucket  string    // The bucket from which the object came
+	size    int64     // The size of the object
+	modTime time.Time // When the object was last used
+}
+
+// cacheFiller passes the content of a log object through from S3 while writing a copy to the
+// cache. The copy is only kept if the object is read in full and matches its ETag.
+type cacheFiller struct {
+	cache *LogCache     // The cache being filled
+	body  io.ReadCloser // The content of the object, streamed from S3
+	file  *os.File      // The temporary file being written, nil if writing it failed
+	path  string        // Where the file should be kept once it is complete
+	etag  string        // The ETag of the object, without its quotes
+	hash  hash.Hash     // The MD5 of the content read so far
+	done  bool          // True once the whole of the object has been read
+}
+
+// DisplayCacheStats prints the number of objects held in the cache, and their total size, for
+// each log bucket.
+//
+// An error is returned if there is a problem, otherwise nil.
+func DisplayCacheStats(cache *LogCache) error {
+
+	// Take stock of the cache
+	files, err := cache.files()
+	if err != nil {
+		return err
+	}
+	objects := make(map[string]int)
+	bytes := make(map[string]int64)
+	buckets := make([]string, 0)
+	for _, f := range files {
+		if objects[f.bucket] == 0 {
+			buckets = append(buckets, f.bucket)
+		}
+		objects[f.bucket]++
+		bytes[f.bucket] += f.size
+	}
+	sort.Strings(buckets)
+
+	// Display the buckets in columns
+	fmt.Printf("Cache directory: %s\n", cache.Dir)
+	if cache.MaxSize > 0 {
+		fmt.Printf("Size limit: %d bytes\n", cache.MaxSize)
+	}
+	fmt.Println()
+	var totalObjects int
+	var totalBytes int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BUCKET\tOBJECTS\tBYTES\t")
+	for _, bucket := range buckets {
+		fmt.Fprintf(w, "%s\t%d\t%d\t\n", bucket, objects[bucket], bytes[bucket])
+		totalObjects += objects[bucket]
+		totalBytes += bytes[bucket]
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %d objects, %d bytes\n", totalObjects, totalBytes)
+	return nil
+}
+
+// ClearCache removes the objects cached for the given log bucket, or every object in the
+// cache if no bucket is given.
+//
+// An error is returned if the bucket name could not be that of a bucket in the cache, or if
+// there is a problem, otherwise nil.
+func ClearCache(cache *LogCache, bucket string) error {
+
+	// Make sure that we remove nothing outside of the cache
+	dir := cache.Dir
+	if len(bucket) > 0 {
+		if !isCacheBucket(bucket) {
+			return fmt.Errorf("Invalid log bucket name: %q", bucket)
+		}
+		dir = filepath.Join(cache.Dir, bucket)
+		rel, err := filepath.Rel(cache.Dir, dir)
+		if err != nil || rel != bucket {
+			return fmt.Errorf("Invalid log bucket name: %q", bucket)
+		}
+	}
+
+	// The running total no longer holds
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.measured = false
+	return os.RemoveAll(dir)
+}
+
+// isCacheBucket returns true if a bucket name can name a directory of the cache, i.e. it is not
+// empty, contains no path separator, and is not . or .. so cannot refer outside of the cache.
+func isCacheBucket(bucket string) bool {
+	return len(bucket) > 0 && bucket != "." && bucket != ".." &&
+		!strings.ContainsAny(bucket, "/"+string(filepath.Separator)) && filepath.Clean(bucket) == bucket
+}
+
+// path returns the name of the file in which a log object is cached, or false if the object
+// cannot be cached because it has no ETag or its key would place it outside of the cache.
+func (c *LogCache) path(bucket string, obj *s3.Object) (string, bool) {
+	key := aws.StringValue(obj.Key)
+	etag := strings.Trim(aws.StringValue(obj.ETag), `"`)
+	if len(etag) == 0 || len(key) == 0 || !isCacheBucket(bucket) {
+		return "", false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+	}
+	return filepath.Join(c.Dir, bucket, filepath.FromSlash(key)+"."+etag), true
+}
+
+// open returns the cached content of a log object, or nil if it is not in the cache
+func (c *LogCache) open(bucket string, obj *s3.Object) io.ReadCloser {
+	path, ok := c.path(bucket, obj)
+	if !ok {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+
+	// Mark the object as recently used so that it is the last to be evicted
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return file
+}
+
+// remove discards the cached copy of a log object, if there is one
+func (c *LogCache) remove(bucket string, obj *s3.Object) {
+	path, ok := c.path(bucket, obj)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(path)
+	if err == nil && os.Remove(path) == nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.total -= info.Size()
+	}
+}
+
+// fill returns a reader passing the content of a log object through from S3 that adds the
+// object to the cache once it has been read in full. If the object cannot be cached, the
+// content from S3 is returned as it is.
+func (c *LogCache) fill(bucket string, obj *s3.Object, body io.ReadCloser) io.ReadCloser {
+	path, ok := c.path(bucket, obj)
+	if !ok {
+		return body
+	}
+
+	// Write to a temporary file alongside the one we want, so that a partial copy is never
+	// mistaken for the real thing
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return body
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), cacheTempPrefix)
+	if err != nil {
+		return body
+	}
+	return &cacheFiller{
+		cache: c,
+		body:  body,
+		file:  file,
+		path:  path,
+		etag:  strings.Trim(aws.StringValue(obj.ETag), `"`),
+		hash:  md5.New(),
+	}
+}
+
+// Read reads from the S3 object, copying what is read to the cache file
+func (f *cacheFiller) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+	if n > 0 && f.file != nil {
+		f.hash.Write(p[:n])
+		_, werr := f.file.Write(p[:n])
+		if werr != nil {
+			// We can do without the cache; the reader still gets what it asked for
+			f.discard()
+		}
+	}
+	if err == io.EOF {
+		f.done = true
+	}
+	return n, err
+}
+
+// Close closes the S3 object and either keeps or discards the cache file, depending on whether
+// it holds the whole of the object
+func (f *cacheFiller) Close() error {
+	err := f.body.Close()
+	if f.file == nil {
+		return err
+	}
+
+	// Only keep complete copies. The ETag of an object that was not uploaded in parts is the MD5
+	// of its content, which AWS web log objects never are, so we can check that too.
+	if !f.done || (len(f.etag) == 32 && hex.EncodeToString(f.hash.Sum(nil)) != f.etag) {
+		f.discard()
+		return err
+	}
+	name := f.file.Name()
+	info, statErr := f.file.Stat()
+	f.file.Close()
+	f.file = nil
+	if statErr != nil || os.Rename(name, f.path) != nil {
+		os.Remove(name)
+		return err
+	}
+
+	// Make room for the new object
+	f.cache.added(info.Size())
+	return err
+}
+
+// discard abandons the cache file
+func (f *cacheFiller) discard() {
+	f.file.Close()
+	os.Remove(f.file.Name())
+	f.file = nil
+}
+
+// files lists the objects held in the cache
+func (c *LogCache) files() ([]cacheFile, error) {
+	files := make([]cacheFile, 0)
+	err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), cacheTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(c.Dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, cacheFile{
+			path:    path,
+			bucket:  strings.SplitN(filepath.ToSlash(rel), "/", 2)[0],
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// added accounts for an object of the given size having been added to the cache, evicting
+// objects if that takes the cache over its size limit. The cache is only measured, by walking
+// it, the first time that an object is added and when objects have to be evicted.
+func (c *LogCache) added(size int64) {
+	if c.MaxSize <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Find out how much is cached, if we do not already know
+	if !c.measured {
+		total, err := c.measure()
+		if err != nil {
+			return
+		}
+		c.total = total
+		c.measured = true
+	} else {
+		c.total += size
+	}
+
+	// Make room if need be
+	if c.total > c.MaxSize {
+		c.evict()
+	}
+}
+
+// measure returns the number of bytes held in the cache
+func (c *LogCache) measure() (int64, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	return total, nil
+}
+
+// evict removes the least recently used objects until the cache is no bigger than cacheLowWater
+// percent of its size limit, leaving room for more to be added before it has to evict again.
+// The caller must hold the mutex.
+func (c *LogCache) evict() {
+
+	// Find out exactly what is cached, since the running total may have drifted from it
+	files, err := c.files()
+	if err != nil {
+		return
+	}
+	c.total = 0
+	for _, f := range files {
+		c.total += f.size
+	}
+
+	// Remove the oldest files first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	limit := c.MaxSize - c.MaxSize/100*(100-cacheLowWater)
+	for _, f := range files {
+		if c.total <= limit {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			c.total -= f.size
+		}
+	}
+}
+
+// list returns the cached objects whose keys follow startAfter in the given bucket folder, in key
+// order, as S3 would have listed them
+func (c *LogCache) list(bucket, folder, startAfter string) []*s3.Object {
+	infos, err := ioutil.ReadDir(filepath.Join(c.Dir, bucket, filepath.FromSlash(folder)))
+	if err != nil {
+		return nil
+	}
+	objects := make([]*s3.Object, 0, len(infos))
+	for _, info := range infos {
+
+		// Split the ETag from the name of the file to recover the key
+		name := info.Name()
+		dot := strings.LastIndexByte(name, '.')
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, cacheTempPrefix) || dot <= 0 {
+			continue
+		}
+		key := folder + "/" + name[:dot]
+		if key <= startAfter {
+			continue
+		}
+		objects = append(objects, &s3.Object{
+			Key:  aws.String(key),
+			ETag: aws.String(`"` + name[dot+1:] + `"`),
+			Size: aws.Int64(info.Size()),
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return *objects[i].Key < *objects[j].Key
+	})
+	return objects
+}
+
+// isOffline returns true if an S3 request failed because S3 could not be reached at all
+func isOffline(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == request.ErrCodeRequestError
+}
@@ -0,0 +1,179 @@
+package s3
+
+// Unit tests for the local cache of web log objects
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheTestObject returns the description of a log object with the given key and content,
+// with the ETag that S3 would have given it
+func cacheTestObject(key, content string) *s3.Object {
+	sum := md5.Sum([]byte(content))
+	return &s3.Object{Key: aws.String(key), ETag: aws.String(`"` + hex.EncodeToString(sum[:]) + `"`)}
+}
+
+// newTestCache returns a cache in a new temporary directory, which the caller must remove
+func newTestCache(t *testing.T, maxSize int64) *LogCache {
+	dir, err := ioutil.TempDir("", "slog-cache")
+	require.Nil(t, err, "failed to create a temporary directory")
+	return &LogCache{Dir: dir, MaxSize: maxSize}
+}
+
+// fillTestCache passes content through the cache as though it had been read from S3
+func fillTestCache(t *testing.T, cache *LogCache, obj *s3.Object, content string) {
+	body := cache.fill("my-logs", obj, ioutil.NopCloser(strings.NewReader(content)))
+	data, err := ioutil.ReadAll(body)
+	require.Nil(t, err, "Reading through the cache should not have failed")
+	require.Equal(t, content, string(data), "Content passed through the cache incorrectly")
+	require.Nil(t, body.Close(), "Closing the cached object should not have failed")
+}
+
+// TestCacheFillAndOpen confirms that objects read in full are cached and can be read back
+func TestCacheFillAndOpen(t *testing.T) {
+	cache := newTestCache(t, 0)
+	defer os.RemoveAll(cache.Dir)
+
+	// Nothing is cached to start with
+	obj := cacheTestObject("root/2020-03-20-13-00-01-AAAA", "line one\nline two\n")
+	require.Nil(t, cache.open("my-logs", obj), "Nothing should have been cached yet")
+
+	// Read it through the cache, then read it back
+	fillTestCache(t, cache, obj, "line one\nline two\n")
+	body := cache.open("my-logs", obj)
+	require.NotNil(t, body, "The object should have been cached")
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	require.Nil(t, err, "Reading the cached object should not have failed")
+	require.Equal(t, "line one\nline two\n", string(data), "Cached content incorrect")
+
+	// An object with a different ETag is not the one we cached
+	other := cacheTestObject("root/2020-03-20-13-00-01-AAAA", "something else\n")
+	require.Nil(t, cache.open("my-logs", other), "An object with another ETag should not be found")
+}
+
+// TestCacheIncomplete confirms that objects that are not read in full, or do not match their
+// ETag, are not cached
+func TestCacheIncomplete(t *testing.T) {
+	cache := newTestCache(t, 0)
+	defer os.RemoveAll(cache.Dir)
+
+	// Abandon an object part way through
+	obj := cacheTestObject("root/2020-03-20-13-00-01-AAAA", "line one\nline two\n")
+	body := cache.fill("my-logs", obj, ioutil.NopCloser(strings.NewReader("line one\nline two\n")))
+	_, err := body.Read(make([]byte, 4))
+	require.Nil(t, err, "Reading through the cache should not have failed")
+	body.Close()
+	require.Nil(t, cache.open("my-logs", obj), "A partly read object should not have been cached")
+
+	// Content that does not match the ETag
+	fillTestCache(t, cache, obj, "corrupted\n")
+	require.Nil(t, cache.open("my-logs", obj), "A corrupted object should not have been cached")
+
+	// And nothing is left lying around
+	files, err := cache.files()
+	require.Nil(t, err, "Listing the cache should not have failed")
+	require.Empty(t, files, "The cache should be empty")
+	infos, err := ioutil.ReadDir(filepath.Join(cache.Dir, "my-logs", "root"))
+	require.Nil(t, err, "Listing the cache folder should not have failed")
+	require.Empty(t, infos, "Temporary files should have been removed")
+}
+
+// TestCacheEviction confirms that the least recently used objects are evicted to keep the cache
+// within its size limit
+func TestCacheEviction(t *testing.T) {
+	cache := newTestCache(t, 30)
+	defer os.RemoveAll(cache.Dir)
+
+	// Cache two objects, then use the first so that the second is the least recently used
+	first := cacheTestObject("root/2020-03-20-13-00-01-AAAA", "first object\n")
+	second := cacheTestObject("root/2020-03-20-13-00-02-BBBB", "second object\n")
+	fillTestCache(t, cache, first, "first object\n")
+	fillTestCache(t, cache, second, "second object\n")
+	past := time.Now().Add(-time.Hour)
+	path, _ := cache.path("my-logs", second)
+	require.Nil(t, os.Chtimes(path, past, past), "failed to age the second object")
+	cache.open("my-logs", first).Close()
+
+	// A third object pushes the cache over its limit
+	third := cacheTestObject("root/2020-03-20-13-00-03-CCCC", "third\n")
+	fillTestCache(t, cache, third, "third\n")
+	require.NotNil(t, cache.open("my-logs", first), "The recently used object should have been kept")
+	require.Nil(t, cache.open("my-logs", second), "The least recently used object should have been evicted")
+	require.NotNil(t, cache.open("my-logs", third), "The newest object should have been kept")
+	require.Equal(t, int64(len("first object\n")+len("third\n")), cache.total, "The running total should match what is left")
+
+	// Objects added within the limit are counted without walking the cache
+	fourth := cacheTestObject("root/2020-03-20-13-00-04-DDDD", "4\n")
+	fillTestCache(t, cache, fourth, "4\n")
+	require.Equal(t, int64(len("first object\n")+len("third\n")+len("4\n")), cache.total, "The new object should have been counted")
+}
+
+// TestCacheList confirms that cached objects are listed as S3 would list them
+func TestCacheList(t *testing.T) {
+	cache := newTestCache(t, 0)
+	defer os.RemoveAll(cache.Dir)
+	for _, key := range []string{"root/2020-03-20-13-00-02-BBBB", "root/2020-03-20-13-00-01-AAAA", "root/2020-03-20-13-00-03-CCCC"} {
+		fillTestCache(t, cache, cacheTestObject(key, key), key)
+	}
+
+	objects := cache.list("my-logs", "root", "root/2020-03-20-13-00-01-AAAA")
+	require.Len(t, objects, 2, "Keys after the start should have been listed")
+	require.Equal(t, "root/2020-03-20-13-00-02-BBBB", *objects[0].Key, "Keys should be listed in order")
+	require.Equal(t, "root/2020-03-20-13-00-03-CCCC", *objects[1].Key, "Keys should be listed in order")
+	require.Equal(t, *cacheTestObject(*objects[0].Key, *objects[0].Key).ETag, *objects[0].ETag, "ETag recovered incorrectly")
+	require.Empty(t, cache.list("other-logs", "root", ""), "Another bucket should have nothing cached")
+}
+
+// TestClearCache confirms that the cache can be cleared a bucket at a time, or all at once
+func TestClearCache(t *testing.T) {
+	cache := newTestCache(t, 0)
+	defer os.RemoveAll(cache.Dir)
+	obj := cacheTestObject("root/2020-03-20-13-00-01-AAAA", "content\n")
+	fillTestCache(t, cache, obj, "content\n")
+
+	// Names that could reach outside of the cache are refused
+	for _, bucket := range []string{".", "..", "../my-logs", "my-logs/..", "my-logs/root", "./my-logs"} {
+		err := ClearCache(cache, bucket)
+		require.NotNil(t, err, "Clearing %s should have been refused", bucket)
+		require.Contains(t, err.Error(), "Invalid log bucket name", "Expected an invalid bucket name error for %s", bucket)
+	}
+	require.NotNil(t, cache.open("my-logs", obj), "Refused names should have left the object")
+
+	require.Nil(t, ClearCache(cache, "other-logs"), "Clearing another bucket should not have failed")
+	require.NotNil(t, cache.open("my-logs", obj), "Clearing another bucket should have left the object")
+	require.Nil(t, ClearCache(cache, ""), "Clearing the cache should not have failed")
+	require.Nil(t, cache.open("my-logs", obj), "Clearing the cache should have removed the object")
+}
+
+// TestCachePath confirms that objects whose keys would escape the cache directory are not cached
+func TestCachePath(t *testing.T) {
+	cache := &LogCache{Dir: "/tmp/cache"}
+	_, ok := cache.path("my-logs", &s3.Object{Key: aws.String("root/../../etc/passwd"), ETag: aws.String(`"abc"`)})
+	require.False(t, ok, "A key climbing out of the cache should not be cached")
+	_, ok = cache.path("..", &s3.Object{Key: aws.String("root/2020-03-20-13-00-01-AAAA"), ETag: aws.String(`"abc"`)})
+	require.False(t, ok, "A bucket climbing out of the cache should not be cached")
+	_, ok = cache.path("my-logs", &s3.Object{Key: aws.String("root/2020-03-20-13-00-01-AAAA")})
+	require.False(t, ok, "An object without an ETag should not be cached")
+	path, ok := cache.path("my-logs", &s3.Object{Key: aws.String("root/2020-03-20-13-00-01-AAAA"), ETag: aws.String(`"abc"`)})
+	require.True(t, ok, "A log object should be cached")
+	require.Equal(t, filepath.FromSlash("/tmp/cache/my-logs/root/2020-03-20-13-00-01-AAAA.abc"), path, "Cache path incorrect")
+}
+
+// TestIsOffline confirms that only failures to reach S3 at all are treated as being offline
+func TestIsOffline(t *testing.T) {
+	require.True(t, isOffline(awserr.New("RequestError", "send request failed", nil)), "A request error should be offline")
+	require.False(t, isOffline(awserr.New("NoSuchBucket", "no such bucket", nil)), "A missing bucket should not be offline")
+}
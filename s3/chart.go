@@ -0,0 +1,26 @@
+package s3
+
+// The functions in this file draw simple charts with text characters for
+// display in a terminal.
+
+import (
+	"strings"
+)
+
+const (
+	barWidth = 50 // The number of characters in the longest bar of a chart
+)
+
+// textBar returns a bar of block characters whose length is proportional to value's share
+// of max, such that a value equal to max fills the given width. Any non-zero value is
+// given at least one character so that it can be distinguished from nothing at all.
+func textBar(value, max int64, width int) string {
+	if value <= 0 || max <= 0 {
+		return ""
+	}
+	length := int(value * int64(width) / max)
+	if length == 0 {
+		length = 1
+	}
+	return strings.Repeat("█", length)
+}
@@ -0,0 +1,193 @@
+package s3
+
+// The functions in this file record the progress of long running operations so
+// that, if they are interrupted, they can resume from where they left off
+// rather than starting over.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// checkpointInterval is the least time between saves of the checkpoint of an aggregation, whose
+// state can be large enough that saving it after every log object would slow the run down
+const checkpointInterval = 10 * time.Second
+
+// accumulator is implemented by the aggregations of log entries whose runs can be resumed. What
+// they have accumulated is saved in the checkpoint file with the progress made, and restored from
+// it when the run resumes, so that the result covers the whole time window and not just the part
+// read after resumption.
+type accumulator interface {
+	save() interface{}                  // Returns the state to be saved, which must marshal to JSON
+	restore(data json.RawMessage) error // Restores a saved state, failing if it was saved by another kind of run
+}
+
+// aggregateCheckpoint is the content of the checkpoint file of an aggregation
+type aggregateCheckpoint struct {
+	Run      *aggregateRun     `json:"run"`      // What the run that made the checkpoint read
+	Progress map[string]string `json:"progress"` // The last key processed in each bucket folder
+	State    json.RawMessage   `json:"state"`    // What had been accumulated when the last key was processed
+}
+
+// aggregateRun describes the log entries read by the run of an aggregation. Only a run that
+// reads the same entries can resume from its checkpoint, since what it has accumulated would
+// otherwise be mixed with what a different run would have.
+type aggregateRun struct {
+	LogBucket      string      `json:"log_bucket"`
+	Folder         string      `json:"folder"`
+	Sources        []LogSource `json:"sources"`
+	SourceBuckets  []string    `json:"source_buckets"`
+	Start          time.Time   `json:"start"`
+	End            time.Time   `json:"end"`
+	BotFilter      BotFilter   `json:"bot_filter"`
+	BotSignatures  string      `json:"bot_signatures"`
+	GeoIPDatabases []string    `json:"geoip_databases"`
+	Countries      []string    `json:"countries"`
+}
+
+// loadProgress reads the session checkpoint file, if the session has one and it exists, and
+// notes the last key recorded for the session bucket folder as the key after which listing is
+// to resume. The checkpoint file holds the last key processed for each bucket folder so that
+// one file can serve operations, like retention, that work through several. If the session has
+// an accumulator, the file also holds its state, which is restored as long as the checkpoint was
+// made by a run reading the same log entries.
+//
+// An error is returned if the checkpoint file exists but cannot be read, otherwise nil.
+func loadProgress(session *SlogSession) error {
+
+	// Nothing to do if we are not checkpointing
+	session.progress = make(map[string]string)
+	session.resumeKey = ""
+	if session.accumulator != nil {
+		session.run = newAggregateRun(session)
+	}
+	if len(session.Checkpoint) == 0 {
+		return nil
+	}
+
+	// A missing checkpoint is not a problem, we just start from the beginning
+	data, err := ioutil.ReadFile(session.Checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if session.accumulator != nil {
+		err = loadAggregate(session, data)
+	} else {
+		err = json.Unmarshal(data, &session.progress)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid checkpoint file %s: %w", session.Checkpoint, err)
+	}
+	session.resumeKey = session.progress[progressID(session)]
+	return nil
+}
+
+// recordProgress saves the key of the last object to have been fully processed in the session
+// bucket folder to the session checkpoint file, if the session has one, along with the state of
+// the session accumulator, if it has one of those.
+//
+// An error is returned if the checkpoint file cannot be written, otherwise nil.
+func recordProgress(session *SlogSession, key string) error {
+
+	// Nothing to do if we are not checkpointing
+	if len(session.Checkpoint) == 0 {
+		return nil
+	}
+
+	// Save the lot
+	if session.progress == nil {
+		session.progress = make(map[string]string)
+	}
+	session.progress[progressID(session)] = key
+	var data []byte
+	var err error
+	if session.accumulator != nil {
+		data, err = saveAggregate(session)
+	} else {
+		data, err = json.MarshalIndent(session.progress, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return saveCheckpoint(session.Checkpoint, data)
+}
+
+// loadAggregate restores the progress and accumulator state of an aggregation from the content
+// of its checkpoint file
+func loadAggregate(session *SlogSession, data []byte) error {
+	checkpoint := &aggregateCheckpoint{}
+	err := json.Unmarshal(data, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// Only resume the same run
+	current, err := json.Marshal(session.run)
+	if err != nil {
+		return err
+	}
+	previous, err := json.Marshal(checkpoint.Run)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, previous) {
+		return errors.New("the checkpoint was made by a run with a different log bucket, time window or filters")
+	}
+	if checkpoint.Progress != nil {
+		session.progress = checkpoint.Progress
+	}
+	if len(checkpoint.State) == 0 {
+		return nil
+	}
+	return session.accumulator.restore(checkpoint.State)
+}
+
+// saveAggregate returns the content of the checkpoint file of an aggregation
+func saveAggregate(session *SlogSession) ([]byte, error) {
+	state, err := json.Marshal(session.accumulator.save())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&aggregateCheckpoint{Run: session.run, Progress: session.progress, State: state})
+}
+
+// newAggregateRun returns the description of the log entries read by the given session
+func newAggregateRun(session *SlogSession) *aggregateRun {
+	return &aggregateRun{
+		LogBucket:      session.LogBucket,
+		Folder:         session.Folder,
+		Sources:        session.Sources,
+		SourceBuckets:  session.SourceBuckets,
+		Start:          session.StartDateTime.UTC(),
+		End:            session.EndDateTime.UTC(),
+		BotFilter:      session.BotFilter,
+		BotSignatures:  session.BotSignatures,
+		GeoIPDatabases: session.GeoIPDatabases,
+		Countries:      session.Countries,
+	}
+}
+
+// progressID returns the name under which the progress through the session bucket folder is
+// recorded in the checkpoint file
+func progressID(session *SlogSession) string {
+	return session.LogBucket + "/" + session.Folder
+}
+
+// saveCheckpoint writes a checkpoint file, replacing the previous version in a single step so
+// that a crash part way through cannot leave a corrupt checkpoint behind
+func saveCheckpoint(checkpoint string, data []byte) error {
+	tmp := checkpoint + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, checkpoint)
+}
@@ -0,0 +1,177 @@
+package s3
+
+// Unit tests for the slog checkpoint functions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestProgress confirms that progress through several bucket folders is recorded and resumed
+func TestProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-checkpoint")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "progress.json")
+
+	// Without a checkpoint file, nothing is recorded
+	session := &SlogSession{LogBucket: "my-logs", Folder: "root"}
+	require.Nil(t, loadProgress(session), "loading without a checkpoint should succeed")
+	require.Nil(t, recordProgress(session, "root/2020-03-20-13-00-00-AAAA"), "recording without a checkpoint should succeed")
+	_, err = os.Stat(checkpoint)
+	require.True(t, os.IsNotExist(err), "No checkpoint file should have been written")
+
+	// A missing checkpoint file means starting from the beginning
+	session.Checkpoint = checkpoint
+	require.Nil(t, loadProgress(session), "loading a missing checkpoint should succeed")
+	require.Empty(t, session.resumeKey, "There should be nothing to resume from")
+
+	// Record progress through two folders
+	require.Nil(t, recordProgress(session, "root/2020-03-20-13-00-00-AAAA"), "recording progress failed")
+	require.Nil(t, recordProgress(session, "root/2020-03-20-13-05-00-BBBB"), "recording further progress failed")
+	other := &SlogSession{LogBucket: "my-logs", Folder: "archive", Checkpoint: checkpoint}
+	require.Nil(t, loadProgress(other), "loading for another folder failed")
+	require.Empty(t, other.resumeKey, "The other folder should have nothing to resume from")
+	require.Nil(t, recordProgress(other, "archive/2019-01-01-00-00-00-CCCC"), "recording progress for another folder failed")
+
+	// Both resume from where they left off
+	session = &SlogSession{LogBucket: "my-logs", Folder: "root", Checkpoint: checkpoint}
+	require.Nil(t, loadProgress(session), "reloading the checkpoint failed")
+	require.Equal(t, "root/2020-03-20-13-05-00-BBBB", session.resumeKey, "Resume key incorrect")
+	other = &SlogSession{LogBucket: "my-logs", Folder: "archive", Checkpoint: checkpoint}
+	require.Nil(t, loadProgress(other), "reloading the checkpoint for another folder failed")
+	require.Equal(t, "archive/2019-01-01-00-00-00-CCCC", other.resumeKey, "Other folder resume key incorrect")
+
+	// A corrupt checkpoint is an error
+	require.Nil(t, ioutil.WriteFile(checkpoint, []byte("not json"), 0644), "failed to corrupt the checkpoint")
+	err = loadProgress(session)
+	require.NotNil(t, err, "A corrupt checkpoint should have been refused")
+	require.Contains(t, err.Error(), "Invalid checkpoint file", "Expected an invalid checkpoint error")
+}
+
+// TestAggregateCheckpoint confirms that what the aggregations have accumulated is saved with their
+// progress and restored when they resume, but only into an aggregation of the same kind
+func TestAggregateCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-checkpoint")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "aggregate.json")
+
+	// Some traffic to accumulate
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	entries := make([]*LogEntry, 0)
+	for i, e := range []testEntry{
+		{bucket: "example.com", time: start, remoteIP: "192.0.2.1", operation: "WEBSITE.GET.OBJECT", key: "index.html",
+			status: 200, bytesSent: 1000, totalTime: 20, referrer: "https://www.google.com/", userAgent: "Firefox"},
+		{bucket: "example.com", time: start.Add(time.Minute), remoteIP: "192.0.2.1", operation: "WEBSITE.GET.OBJECT",
+			key: "missing.html", status: 404, totalTime: 5, referrer: "https://example.com/", userAgent: "Firefox"},
+		{bucket: "example.com", time: start.Add(time.Hour), remoteIP: "192.0.2.2", operation: "REST.GET.OBJECT",
+			key: "logo.png", status: 200, bytesSent: 5000, totalTime: 300, userAgent: "Safari"},
+	} {
+		entry, err := parseLogEntry(testLogLine(e))
+		require.Nil(t, err, "failed to parse test entry %d: %v", i, err)
+		entries = append(entries, entry)
+	}
+
+	// Each kind of aggregation, made twice with the same settings, plus one with other settings
+	end := start.Add(2 * time.Hour)
+	newSeries := func(interval time.Duration) *timeSeries {
+		series, err := newTimeSeries(start, end, interval)
+		require.Nil(t, err, "failed to create a time series")
+		return series
+	}
+	for _, c := range []struct {
+		name  string
+		add   func(acc accumulator, entry *LogEntry)
+		new   func() accumulator
+		other accumulator
+	}{
+		{"sessions", func(acc accumulator, entry *LogEntry) { acc.(*sessionTracker).add(entry) },
+			func() accumulator { return newSessionTracker(30 * time.Minute) }, newSessionTracker(time.Hour)},
+		{"latency", func(acc accumulator, entry *LogEntry) { acc.(*latencyTracker).add(entry) },
+			func() accumulator { return newLatencyTracker([]LatencyBreakdown{BYKEY, BYSIZE}) },
+			newLatencyTracker([]LatencyBreakdown{BYOPERATION})},
+		{"histogram", func(acc accumulator, entry *LogEntry) { acc.(*timeSeries).add(entry) },
+			func() accumulator { return newSeries(time.Hour) }, newSeries(time.Minute)},
+		{"404s", func(acc accumulator, entry *LogEntry) { acc.(*notFoundTracker).add(entry) },
+			func() accumulator { return newNotFoundTracker([]string{"example.com"}) }, newNotFoundTracker(nil)},
+		{"report", func(acc accumulator, entry *LogEntry) { acc.(*trafficReport).add(entry) },
+			func() accumulator { return newTrafficReport(start, end, nil) },
+			newTrafficReport(start, start.Add(time.Hour), nil)},
+	} {
+		os.Remove(checkpoint)
+
+		// Accumulate and save the lot
+		acc := c.new()
+		for _, entry := range entries {
+			c.add(acc, entry)
+		}
+		session := &SlogSession{LogBucket: "my-logs", Folder: "root", Checkpoint: checkpoint, accumulator: acc}
+		require.Nil(t, loadProgress(session), "%s: loading a missing checkpoint failed", c.name)
+		require.Nil(t, recordProgress(session, "root/2020-03-20-14-00-00-AAAA"), "%s: recording progress failed", c.name)
+
+		// Resuming restores the progress and what was accumulated
+		resumed := &SlogSession{LogBucket: "my-logs", Folder: "root", Checkpoint: checkpoint, accumulator: c.new()}
+		require.Nil(t, loadProgress(resumed), "%s: reloading the checkpoint failed", c.name)
+		require.Equal(t, "root/2020-03-20-14-00-00-AAAA", resumed.resumeKey, "%s: resume key incorrect", c.name)
+		saved, err := json.Marshal(acc.save())
+		require.Nil(t, err, "%s: failed to marshal the saved state", c.name)
+		restored, err := json.Marshal(resumed.accumulator.save())
+		require.Nil(t, err, "%s: failed to marshal the restored state", c.name)
+		require.JSONEq(t, string(saved), string(restored), "%s: state restored incorrectly", c.name)
+
+		// But not into an aggregation with other settings
+		other := &SlogSession{LogBucket: "my-logs", Folder: "root", Checkpoint: checkpoint, accumulator: c.other}
+		err = loadProgress(other)
+		require.NotNil(t, err, "%s: a checkpoint made with other settings should have been refused", c.name)
+		require.Contains(t, err.Error(), "Invalid checkpoint file", "%s: expected an invalid checkpoint error", c.name)
+	}
+}
+
+// TestAggregateCheckpointRun confirms that an aggregation only resumes from a checkpoint made by a
+// run reading the same log entries, with the same time window and filters
+func TestAggregateCheckpointRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-checkpoint")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "aggregate.json")
+
+	// Save a checkpoint for a run over a day of human traffic to one source bucket
+	start := time.Date(2020, time.March, 20, 0, 0, 0, 0, time.UTC)
+	newSession := func() *SlogSession {
+		return &SlogSession{LogBucket: "my-logs", Folder: "root", Checkpoint: checkpoint, StartDateTime: start,
+			EndDateTime: start.Add(24 * time.Hour), SourceBuckets: []string{"example.com"}, BotFilter: EXCLUDEBOTS,
+			accumulator: newNotFoundTracker(nil)}
+	}
+	session := newSession()
+	require.Nil(t, loadProgress(session), "loading a missing checkpoint failed")
+	require.Nil(t, recordProgress(session, "root/2020-03-20-13-00-00-AAAA"), "recording progress failed")
+
+	// The same run resumes, even with its start in another time zone
+	resumed := newSession()
+	resumed.StartDateTime = start.In(time.FixedZone("EST", -5*3600))
+	require.Nil(t, loadProgress(resumed), "the same run should have resumed")
+	require.Equal(t, "root/2020-03-20-13-00-00-AAAA", resumed.resumeKey, "Resume key incorrect")
+
+	// Any other run does not
+	for name, change := range map[string]func(s *SlogSession){
+		"start":          func(s *SlogSession) { s.StartDateTime = start.Add(time.Hour) },
+		"window":         func(s *SlogSession) { s.EndDateTime = start.Add(48 * time.Hour) },
+		"log bucket":     func(s *SlogSession) { s.LogBucket = "other-logs" },
+		"source buckets": func(s *SlogSession) { s.SourceBuckets = []string{"example.com", "assets.example.com"} },
+		"bot filter":     func(s *SlogSession) { s.BotFilter = ONLYBOTS },
+		"countries":      func(s *SlogSession) { s.Countries = []string{"GB"} },
+	} {
+		other := newSession()
+		change(other)
+		err := loadProgress(other)
+		require.NotNil(t, err, "A run with a different %s should not have resumed", name)
+		require.Contains(t, err.Error(), "different log bucket, time window or filters", "Expected a different run error for %s", name)
+	}
+}
@@ -0,0 +1,93 @@
+package s3
+
+// The functions in this file highlight the Web log display with ANSI colors, so that
+// errors and slow requests stand out when reading it on a terminal.
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The ANSI escape sequences with which the Web log display is highlighted
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiSlow   = "\x1b[1;35m"     // Bold magenta, for the total time of slow requests
+	ansiShade  = "\x1b[48;5;236m" // A dark grey background, for the lines of every other log object
+)
+
+const (
+	requestWord = 9 // The index of the first word of the Request-URI in a web log line
+)
+
+// colorParts returns a copy of the words of a web log line with the HTTP status colored by its
+// class, the error code, if there is one, in red and the total time highlighted if the request
+// was slow. Each colored word restores the given line style after it.
+//
+// The words are returned unchanged if the HTTP status cannot be found among them.
+func colorParts(session *SlogSession, parts []string, style string) []string {
+
+	// The fields of interest follow the Request-URI
+	status := statusWord(parts)
+	if status < 0 || status+4 >= len(parts) {
+		return parts
+	}
+	colored := append([]string(nil), parts...)
+	paint := func(i int, color string) {
+		if len(color) > 0 {
+			colored[i] = color + colored[i] + ansiReset + style
+		}
+	}
+
+	// Color them as they deserve
+	paint(status, statusColor(parts[status]))
+	if parts[status+1] != "-" {
+		paint(status+1, ansiRed)
+	}
+	totalTime, err := strconv.ParseInt(parts[status+4], 10, 64)
+	if err == nil && session.SlowRequest > 0 && time.Duration(totalTime)*time.Millisecond >= session.SlowRequest {
+		paint(status+4, ansiSlow)
+	}
+	return colored
+}
+
+// statusWord returns the index of the HTTP status among the words of a web log line, following the
+// Request-URI which, being quoted, is usually several words. If the HTTP status cannot be found,
+// -1 is returned.
+func statusWord(parts []string) int {
+	if len(parts) <= requestWord {
+		return -1
+	}
+	if !strings.HasPrefix(parts[requestWord], `"`) {
+		return requestWord + 1
+	}
+	for i := requestWord; i < len(parts); i++ {
+		if (i > requestWord || len(parts[i]) > 1) && strings.HasSuffix(parts[i], `"`) {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// statusColor returns the color of an HTTP status by its class: green for success, cyan for
+// redirection, yellow for client errors and red for server errors. Anything else is not colored.
+func statusColor(status string) string {
+	if len(status) != 3 {
+		return ""
+	}
+	switch status[0] {
+	case '2':
+		return ansiGreen
+	case '3':
+		return ansiCyan
+	case '4':
+		return ansiYellow
+	case '5':
+		return ansiRed
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package s3
+
+// Unit tests for highlighting the Web log display with ANSI colors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestColorParts examines the coloring of the status, error code and total time of log lines
+func TestColorParts(t *testing.T) {
+	session := &SlogSession{SlowRequest: time.Second}
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+
+	// Statuses by class
+	for status, color := range map[int]string{200: ansiGreen, 301: ansiCyan, 404: ansiYellow, 503: ansiRed} {
+		parts := strings.Split(testLogLine(testEntry{bucket: "example.com", time: now, key: "index.html", status: status}), " ")
+		colored := colorParts(session, parts, "")
+		require.Contains(t, strings.Join(colored, " "), color+parts[12]+ansiReset,
+			"Status %d colored incorrectly", status)
+	}
+
+	// Errors in red, and slow requests highlighted, restoring the line style after each
+	parts := strings.Split(testLogLine(testEntry{bucket: "example.com", time: now, key: "missing.html", status: 404,
+		errorCode: "NoSuchKey", totalTime: 1500}), " ")
+	line := strings.Join(colorParts(session, parts, ansiShade), " ")
+	require.Contains(t, line, ansiRed+"NoSuchKey"+ansiReset+ansiShade, "Error code colored incorrectly")
+	require.Contains(t, line, ansiSlow+"1500"+ansiReset+ansiShade, "Slow total time highlighted incorrectly")
+
+	// Quick requests are not
+	parts = strings.Split(testLogLine(testEntry{bucket: "example.com", time: now, status: 200, totalTime: 999}), " ")
+	require.NotContains(t, strings.Join(colorParts(session, parts, ""), " "), ansiSlow, "A quick request should not have been highlighted")
+
+	// Lines that are too short are left alone
+	parts = []string{"short", "line"}
+	require.Equal(t, parts, colorParts(session, parts, ""), "A short line should have been left alone")
+}
+
+// TestColorDisplay confirms that colored lines are shaded object by object, and that raw content is not colored
+func TestColorDisplay(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	line := testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "192.0.2.1", key: "index.html", status: 200})
+	display := func(session *SlogSession) []string {
+		out.Reset()
+		dataChan := make(chan *logObject, 3)
+		for _, key := range []string{"root/2020-03-20-13-00-00-A", "root/2020-03-20-13-10-00-B", "root/2020-03-20-13-20-00-C"} {
+			dataChan <- &logObject{session: session, key: key, body: ioutil.NopCloser(strings.NewReader(line + "\n"))}
+		}
+		close(dataChan)
+		doneChan := make(chan struct{})
+		displayLogData(session, dataChan, doneChan, make(chan error, 1))
+		<-doneChan
+		return strings.Split(strings.TrimSpace(out.String()), "\n")
+	}
+
+	// Every other object is shaded
+	lines := display(&SlogSession{Content: BASIC, Color: true, Output: &out})
+	require.Len(t, lines, 3, "Every object should have been displayed")
+	require.False(t, strings.HasPrefix(lines[0], ansiShade), "The first object should not have been shaded")
+	require.Contains(t, lines[0], ansiGreen+"200"+ansiReset, "The status should have been colored")
+	require.True(t, strings.HasPrefix(lines[1], ansiShade), "The second object should have been shaded")
+	require.True(t, strings.HasSuffix(lines[1], ansiReset), "The shading should have been reset at the end of the line")
+	require.False(t, strings.HasPrefix(lines[2], ansiShade), "The third object should not have been shaded")
+
+	// Raw content stays as AWS recorded it, as does everything without color
+	lines = display(&SlogSession{Content: RAW, Color: true, Output: &out})
+	require.Equal(t, line, lines[1], "Raw content should not have been colored")
+	lines = display(&SlogSession{Content: BASIC, Output: &out})
+	require.NotContains(t, strings.Join(lines, "\n"), "\x1b[", "Nothing should have been colored")
+}
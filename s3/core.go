@@ -5,6 +5,9 @@ package s3
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -22,19 +25,64 @@ const (
 	BUCKET                       // BASIC plus the bucket named from which the request was served
 	RICH                         // Includes bucket, request ID, operation and key values
 	RAW                          // The whole enchilada, as originally recorded by AWS
+	JSON                         // Every field, parsed and rendered as one JSON object per line
+)
+
+// OutputFormat is an enumeration controlling how reports are rendered
+type OutputFormat int
+
+// The possible values of OutputFormat; defaults to TEXT
+const (
+	TEXT OutputFormat = iota // Human readable text, in columns
+	CSV                      // Comma separated values, for spreadsheets
 )
 
 // SlogSession is a structure packing the various parameters for a given run.
 type SlogSession struct {
-	awsSession    *session.Session // The S3 session
-	s3            *s3.S3           // The S3 client
-	Region        string           // The AWS region where the S3 bucket is hosted
-	LogBucket     string           // The name of the bucket from which logs are to be processed
-	Folder        string           // The name of the folder to be walked within the bucket
-	SourceBuckets []string         // Optionally, the names of Web content source buckets that are to be filtered for
-	StartDateTime time.Time        // When reading logs, the timestamp of the earliest entry sought
-	EndDateTime   time.Time        // When reading logs, the timestamp of the latest entry sought
-	Content       ContentType      // Controls which fields to include in the Web log display
+	awsSession     *session.Session  // The S3 session
+	s3             *s3.S3            // The S3 client
+	Region         string            // The AWS region where the S3 bucket is hosted
+	Endpoint       string            // Optionally, the URL of an S3 compatible service to use in place of AWS
+	LogBucket      string            // The name of the bucket from which logs are to be processed
+	Folder         string            // The name of the folder to be walked within the bucket
+	SourceBuckets  []string          // Optionally, the names of Web content source buckets that are to be filtered for
+	Sources        []LogSource       // Optionally, several log bucket folders to read together in place of LogBucket and Folder
+	StartDateTime  time.Time         // When reading logs, the timestamp of the earliest entry sought
+	EndDateTime    time.Time         // When reading logs, the timestamp of the latest entry sought
+	Content        ContentType       // Controls which fields to include in the Web log display
+	BotFilter      BotFilter         // Controls whether entries made by bots are included in the Web log display
+	BotSignatures  string            // Optionally, the name of a file of bot signatures to use in place of the built in list
+	Output         io.Writer         // Where the Web log display is written; os.Stdout if nil
+	Cancel         <-chan struct{}   // Optionally, closed to abandon a run part way through
+	Checkpoint     string            // Optionally, the name of a file recording progress, from which interrupted runs resume
+	Retry          RetryPolicy       // Controls how failed S3 requests are retried
+	Cache          *LogCache         // Optionally, where to keep copies of the log objects read, for reading again
+	Anonymize      AnonymizeMode     // Controls how the remote IP addresses of entries are obscured in the Web log display
+	AnonymizeKey   string            // The key used to hash remote IP addresses when Anonymize is HASH
+	GeoIPDatabases []string          // Optionally, the names of MaxMind GeoIP database files with which to locate visitors
+	Countries      []string          // Optionally, the ISO codes of the countries from which entries are to be filtered for
+	Resolve        *ResolvePolicy    // Optionally, how to look up the host names of remote IP addresses for the Web log display
+	Color          bool              // Whether to highlight the Web log display with ANSI colors
+	SlowRequest    time.Duration     // When highlighting, the total time at or beyond which requests are shown as slow
+	stats          *transferStats    // Counts the retries and skipped objects of a run
+	buckets        *bucketFilter     // Selects entries by their source bucket, nil if all are wanted
+	bots           *botDetector      // Classifies entries as made by bots or humans, nil if not needed
+	geo            geoLocator        // Locates the remote IP addresses of entries, nil if not needed
+	hosts          *hostResolver     // Looks up the host names of remote IP addresses, nil if not needed
+	progress       map[string]string // The last key processed in each bucket folder, as recorded in the checkpoint
+	resumeKey      string            // The key after which to resume the listing of the session folder
+	accumulator    accumulator       // The aggregation whose state is saved with the checkpoint progress, nil if none
+	run            *aggregateRun     // What the aggregation reads, as recorded in its checkpoint
+	sourceName     string            // The name of the source that the session reads, if it is one of several
+	shaded         bool              // When highlighting, whether the object being displayed is shaded, as every other one is
+}
+
+// output returns the writer to which the Web log display should be written
+func (s *SlogSession) output() io.Writer {
+	if s.Output == nil {
+		return os.Stdout
+	}
+	return s.Output
 }
 
 // activateSession adds an AWS session and and S3 client to a SlogSession
@@ -43,17 +91,27 @@ type SlogSession struct {
 // If all goes well, returns nil, otherwise an error.
 func activateSession(slogSession *SlogSession) error {
 
+	// Every run counts its own retries
+	slogSession.stats = &transferStats{}
+
 	// If the session has already been actived, we have nothing to do
 	if slogSession.s3 != nil {
 		return nil
 	}
 
-	// Request a session with the default credentials for the default region
-	awsSession, err := session.NewSession(
-		&aws.Config{
-			Region: &slogSession.Region,
-		},
-	)
+	// Request a session with the default credentials for the default region. We do our
+	// own retrying, according to the session retry policy, so the SDK should not.
+	config := &aws.Config{
+		Region:     &slogSession.Region,
+		MaxRetries: aws.Int(0),
+	}
+
+	// Other S3 compatible services rarely support bucket names in host names as AWS does
+	if len(slogSession.Endpoint) > 0 {
+		config.Endpoint = aws.String(slogSession.Endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	awsSession, err := session.NewSession(config)
 	if err != nil {
 		fmt.Println("Error creating session: ", err)
 		return err
@@ -74,26 +132,35 @@ func activateSession(slogSession *SlogSession) error {
 	return nil
 }
 
-// fetchLogObjectKeys loops requesting pages of object keys starting from, approximately,
-// the time given until there are no more keys or the keys fall outside the given
-// time window (more recent than endDateTime). It posts those keys to keyChan. When there
-// are no more keys fitting the time window to post, it closes keyChan and returns.
+// keyTimeFormat is the layout of the timestamp that AWS embeds in the name of each
+// web log object, immediately following the folder prefix.
+const keyTimeFormat = "2006-01-02-15-04-05"
+
+// parseKeyTime extracts the timestamp that AWS embeds in the name of a web log object.
+// The key is expected to begin with the given folder prefix; an error is returned
+// if it does not or if the characters following the prefix are not a timestamp.
+func parseKeyTime(prefix, key string) (time.Time, error) {
+
+	// The timestamp immediately follows the prefix
+	if !strings.HasPrefix(key, prefix) || len(key) < len(prefix)+len(keyTimeFormat) {
+		return time.Time{}, fmt.Errorf("Not a web log object key: %s", key)
+	}
+
+	// Web log key timestamps are always recorded in UTC
+	return time.Parse(keyTimeFormat, key[len(prefix):len(prefix)+len(keyTimeFormat)])
+}
+
+// listLogObjects pages through the objects found in the session folder, starting after
+// the startAfter key, passing each in turn to the visit function. Listing stops when there
+// are no more objects, when an object key sorts beyond endAfter, or when visit returns
+// false. An empty endAfter value places no upper limit on the listing.
 //
-// If a problem occurs, fetchLogObjectKeys posts an error to errChan and terminates // returns
-// after closing keyChan.
-func fetchLogObjectKeys(session *SlogSession, keyChan chan<- string, errChan chan<- error) {
+// Returns nil if all goes well, otherwise the error reported by S3.
+func listLogObjects(session *SlogSession, startAfter, endAfter string, visit func(obj *s3.Object) bool) error {
 
 	// Form the folder prefix from the path provided
 	prefix := session.Folder + "/"
 
-	// Format the start time to the nearest minute and combine with the prefix
-	// to form the "start after" key
-	const keyTimeFormat = "2006-01-02-15-04-05"
-	startAfter := prefix + session.StartDateTime.UTC().Format(keyTimeFormat)
-
-	// Calculate the key prefix that will signal we have reached the end
-	endAfter := prefix + session.EndDateTime.UTC().Format(keyTimeFormat)
-
 	// Set up our starting point for paging through S3 bucket keynames
 	input := &s3.ListObjectsV2Input{
 		MaxKeys:    aws.Int64(maxListKeys),
@@ -102,36 +169,91 @@ func fetchLogObjectKeys(session *SlogSession, keyChan chan<- string, errChan cha
 		StartAfter: &startAfter,
 	}
 
-	// Ask for the object list, with a callback function to receive pages of data
-	err := session.s3.ListObjectsV2Pages(input,
-		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+	// Ask for the object list a page at a time, retrying pages that fail
+	for {
+		var page *s3.ListObjectsV2Output
+		err := retry(session, func() error {
+			var err error
+			page, err = session.s3.ListObjectsV2(input)
+			return err
+		})
 
-			// Loop through all the objects, sending their keys on to the next stage through keyChan
-			for _, obj := range page.Contents {
+		// If S3 cannot be reached at all, make do with what we have in the cache
+		if err != nil && session.Cache != nil && input.ContinuationToken == nil && isOffline(err) {
+			fmt.Fprintln(os.Stderr, "S3 is unreachable, reading cached log objects only:", err)
+			page, err = &s3.ListObjectsV2Output{Contents: session.Cache.list(session.LogBucket, session.Folder, startAfter)}, nil
+		}
+		if err != nil {
+			return err
+		}
 
-				// Confirm that we have a valid key that is not the parent folder
-				key := obj.Key
-				if key == nil || *key == session.Folder {
-					continue
-				}
+		// Loop through all the objects, handing them to the visit function
+		for _, obj := range page.Contents {
 
-				// Test if the key is beyond our end time
-				if *key > endAfter {
+			// Confirm that we have a valid key that is not the parent folder
+			key := obj.Key
+			if key == nil || *key == session.Folder {
+				continue
+			}
 
-					// we are done - stop paging now
-					return false
-				}
+			// Test if the key is beyond our end time
+			if len(endAfter) > 0 && *key > endAfter {
 
-				// Pass the key down the processing chain
-				keyChan <- *key
+				// we are done - stop paging now
+				return nil
 			}
 
-			// Go round for the next page if there is one still to come
-			return !lastPage
-		})
+			// Let the visitor have its way with the object, stopping if it has had enough
+			if !visit(obj) {
+				return nil
+			}
+		}
+
+		// Go round for the next page if there is one still to come
+		if !aws.BoolValue(page.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// fetchLogObjectKeys loops requesting pages of object keys starting from, approximately,
+// the time given until there are no more keys or the keys fall outside the given
+// time window (more recent than endDateTime). It posts those keys to keyChan. When there
+// are no more keys fitting the time window to post, it closes keyChan and returns. The keys are
+// posted as the S3 object descriptions listed so that their ETags can be checked against the cache.
+//
+// If a problem occurs, fetchLogObjectKeys posts an error to errChan and terminates // returns
+// after closing keyChan.
+func fetchLogObjectKeys(session *SlogSession, keyChan chan<- *s3.Object, errChan chan<- error) {
+
+	// Form the folder prefix from the path provided
+	prefix := session.Folder + "/"
+
+	// Format the start time to the nearest second and combine with the prefix
+	// to form the "start after" key
+	startAfter := prefix + session.StartDateTime.UTC().Format(keyTimeFormat)
+
+	// Calculate the key prefix that will signal we have reached the end
+	endAfter := prefix + session.EndDateTime.UTC().Format(keyTimeFormat)
+
+	// Skip what an interrupted run has already processed
+	if session.resumeKey > startAfter {
+		startAfter = session.resumeKey
+	}
+
+	// Page through the object list, sending the keys on to the next stage through keyChan
+	err := listLogObjects(session, startAfter, endAfter, func(obj *s3.Object) bool {
+		select {
+		case keyChan <- obj:
+			return true
+		case <-session.Cancel:
+			return false
+		}
+	})
 	if err != nil {
 		// The ListObjectsV2Pages request failed, report the error
-		errChan <- err
+		postError(session, errChan, err)
 	}
 
 	// We are done - close the key channel
@@ -138,3 +138,21 @@ func TestActivateSessiontFailure(t *testing.T) {
 	err := activateSession(slogSess)
 	require.True(t, err != nil, "activateSession should have failed with a fad environment")
 }
+
+// TestParseKeyTime confirms that timestamps are extracted from web log object keys
+// and that keys from which no timestamp can be extracted are rejected.
+func TestParseKeyTime(t *testing.T) {
+
+	// A well formed key
+	keyTime, err := parseKeyTime("root/", "root/2020-03-20-13-45-07-AA960FCC76F5673E")
+	require.Nil(t, err, "parseKeyTime should have succeeded: %v", err)
+	require.Equal(t, time.Date(2020, time.March, 20, 13, 45, 7, 0, time.UTC), keyTime, "Key time parsed incorrectly")
+
+	// Keys that are in the wrong folder, too short, or not timestamps at all
+	_, err = parseKeyTime("root/", "other/2020-03-20-13-45-07-AA960FCC76F5673E")
+	require.NotNil(t, err, "A key in the wrong folder should have been rejected")
+	_, err = parseKeyTime("root/", "root/2020-03-20")
+	require.NotNil(t, err, "A truncated key should have been rejected")
+	_, err = parseKeyTime("root/", "root/not-a-timestamp-at-all-AA960F")
+	require.NotNil(t, err, "A key without a timestamp should have been rejected")
+}
@@ -0,0 +1,159 @@
+package s3
+
+// The functions in this file parse the lines of AWS web logs into their
+// constituent fields.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	entryTimeFormat = "02/Jan/2006:15:04:05 -0700" // The layout of the timestamp recorded in each log line
+	minEntryFields  = 17                           // Every log line has at least the fields up to and including User-Agent
+)
+
+// LogEntry holds the fields of a single line of an AWS web log. Fields that AWS records
+// as "-" because they have no value are left empty, or zero for numeric fields.
+type LogEntry struct {
+	BucketOwner    string       `json:"bucket_owner"`       // The canonical user ID of the owner of the source bucket
+	Bucket         string       `json:"bucket"`             // The name of the bucket that the request was served from
+	Time           time.Time    `json:"time"`               // The time at which the request was received
+	RemoteIP       string       `json:"remote_ip"`          // The apparent internet address of the requester
+	Requester      string       `json:"requester"`          // The canonical user ID or ARN of the requester, empty for unauthenticated requests
+	RequestID      string       `json:"request_id"`         // The ID that Amazon generated for the request
+	Operation      string       `json:"operation"`          // The operation, e.g. WEBSITE.GET.OBJECT
+	Key            string       `json:"key"`                // The key part of the request, URL encoded
+	RequestURI     string       `json:"request_uri"`        // The Request-URI part of the HTTP request message
+	HTTPStatus     int          `json:"http_status"`        // The HTTP status code of the response
+	ErrorCode      string       `json:"error_code"`         // The Amazon S3 error code, e.g. NoSuchKey
+	BytesSent      int64        `json:"bytes_sent"`         // The number of response bytes sent, excluding HTTP protocol overhead
+	ObjectSize     int64        `json:"object_size"`        // The total size of the object in question
+	TotalTime      int64        `json:"total_time"`         // The number of milliseconds that the request was in flight from the server's perspective
+	TurnAroundTime int64        `json:"turn_around_time"`   // The number of milliseconds that Amazon S3 spent processing the request
+	HasTotalTime   bool         `json:"-"`                  // Whether the Total Time was recorded, rather than "-"
+	HasTurnAround  bool         `json:"-"`                  // Whether the Turn-Around Time was recorded, rather than "-"
+	Referrer       string       `json:"referrer"`           // The value of the HTTP Referer header
+	UserAgent      string       `json:"user_agent"`         // The value of the HTTP User-Agent header
+	HostID         string       `json:"host_id"`            // The x-amz-id-2 or Amazon S3 extended request ID
+	HostHeader     string       `json:"host_header"`        // The endpoint used to connect to Amazon S3
+	Location       *GeoLocation `json:"location,omitempty"` // Where the remote IP address is, if GeoIP databases were given
+}
+
+// parseLogEntry breaks a web log line into its fields, returning an error if the line
+// does not have the layout of an AWS web log entry.
+func parseLogEntry(line string) (*LogEntry, error) {
+
+	// Break the line into fields, respecting quoted and bracketed values
+	fields := splitLogLine(line)
+	if len(fields) < minEntryFields {
+		return nil, fmt.Errorf("Too few fields in web log line: %s", line)
+	}
+
+	// The time and numeric fields need converting
+	entryTime, err := time.Parse(entryTimeFormat, fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time in web log line: %w", err)
+	}
+	var numbers [6]int64
+	for i, field := range fields[9:15] {
+
+		// The error code sits among the numbers but is not one of them
+		if i == 1 || field == "-" {
+			continue
+		}
+		numbers[i], err = strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number in web log line: %w", err)
+		}
+	}
+
+	// Package it all up
+	entry := &LogEntry{
+		BucketOwner:    entryField(fields, 0),
+		Bucket:         entryField(fields, 1),
+		Time:           entryTime,
+		RemoteIP:       entryField(fields, 3),
+		Requester:      entryField(fields, 4),
+		RequestID:      entryField(fields, 5),
+		Operation:      entryField(fields, 6),
+		Key:            entryField(fields, 7),
+		RequestURI:     entryField(fields, 8),
+		HTTPStatus:     int(numbers[0]),
+		ErrorCode:      entryField(fields, 10),
+		BytesSent:      numbers[2],
+		ObjectSize:     numbers[3],
+		TotalTime:      numbers[4],
+		TurnAroundTime: numbers[5],
+		HasTotalTime:   fields[13] != "-",
+		HasTurnAround:  fields[14] != "-",
+		Referrer:       entryField(fields, 15),
+		UserAgent:      entryField(fields, 16),
+		HostID:         entryField(fields, 18),
+		HostHeader:     entryField(fields, 22),
+	}
+	return entry, nil
+}
+
+// entryField returns the field at the given index, or an empty string if the line did
+// not have that many fields or AWS recorded the field as "-".
+func entryField(fields []string, i int) string {
+	if i >= len(fields) || fields[i] == "-" {
+		return ""
+	}
+	return fields[i]
+}
+
+// splitLogLine breaks a web log line into fields separated by spaces. Fields enclosed in
+// double quotes or square brackets may themselves contain spaces; the enclosing characters
+// are not included in the returned field values.
+func splitLogLine(line string) []string {
+	spans := logLineSpans(line)
+	fields := make([]string, len(spans))
+	for i, span := range spans {
+		fields[i] = line[span[0]:span[1]]
+	}
+	return fields
+}
+
+// logLineSpans finds the fields of a web log line, as splitLogLine does, returning the start
+// and end offsets of each field value within the line.
+func logLineSpans(line string) [][2]int {
+	spans := make([][2]int, 0, 25)
+	for i := 0; i < len(line); {
+
+		// Skip the separator
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+
+		// Find the end of the field, which depends on how it starts
+		var closer string
+		switch line[i] {
+		case '"':
+			closer = `"`
+		case '[':
+			closer = "]"
+		default:
+			closer = " "
+		}
+		start := i
+		if closer != " " {
+			start++
+		}
+		end := strings.Index(line[start:], closer)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += start
+		}
+
+		// Note where the field is and move beyond it
+		spans = append(spans, [2]int{start, end})
+		i = end + 1
+	}
+	return spans
+}
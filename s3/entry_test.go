@@ -0,0 +1,109 @@
+package s3
+
+// Unit tests for the slog web log line parsing functions
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sampleLogLine is a real world example of a web log line, courtesy of the AWS documentation
+const sampleLogLine = `79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be 3E57427F3EXAMPLE REST.GET.VERSIONING - "GET /awsexamplebucket1?versioning HTTP/1.1" 200 - 113 - 7 - "-" "S3Console/0.4" - s9lzHYrFp76ZVxRcpX9+5cjAnEH2ROuNkd2BHfIa6UkFVdtjf5mKR3/eTPFvsiP/XV/VLi31234= SigV2 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.1`
+
+// testEntry describes the fields of a web log line built by testLogLine. Fields
+// left empty are recorded as "-" in the same way that AWS does.
+type testEntry struct {
+	bucket    string
+	time      time.Time
+	remoteIP  string
+	operation string
+	key       string
+	status    int
+	errorCode string
+	bytesSent int64
+	totalTime int64
+	referrer  string
+	userAgent string
+}
+
+// testLogLine formats a web log line for use as test data
+func testLogLine(e testEntry) string {
+	uri := "-"
+	if len(e.key) > 0 {
+		uri = fmt.Sprintf("GET /%s HTTP/1.1", e.key)
+	}
+	return fmt.Sprintf(`79a59df900b949e5 %s [%s] %s - 3E57427F3EXAMPLE %s %s "%s" %d %s %d %d %d %d "%s" "%s" - s9lzHYrFp76ZVxRcp= SigV4 - - %s TLSv1.2`,
+		orDash(e.bucket), e.time.Format(entryTimeFormat), orDash(e.remoteIP), orDash(e.operation), orDash(e.key), uri,
+		e.status, orDash(e.errorCode), e.bytesSent, e.bytesSent, e.totalTime, e.totalTime/2,
+		orDash(e.referrer), orDash(e.userAgent), orDash(e.bucket))
+}
+
+// TestParseLogEntry confirms that a real world log line is parsed into the right fields
+func TestParseLogEntry(t *testing.T) {
+
+	entry, err := parseLogEntry(sampleLogLine)
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	require.Equal(t, "awsexamplebucket1", entry.Bucket, "Bucket parsed incorrectly")
+	require.Equal(t, time.Date(2019, time.February, 6, 0, 0, 38, 0, time.UTC), entry.Time.UTC(), "Time parsed incorrectly")
+	require.Equal(t, "192.0.2.3", entry.RemoteIP, "Remote IP parsed incorrectly")
+	require.Equal(t, "3E57427F3EXAMPLE", entry.RequestID, "Request ID parsed incorrectly")
+	require.Equal(t, "REST.GET.VERSIONING", entry.Operation, "Operation parsed incorrectly")
+	require.Equal(t, "", entry.Key, "A dash key should have been parsed as empty")
+	require.Equal(t, "GET /awsexamplebucket1?versioning HTTP/1.1", entry.RequestURI, "Request URI parsed incorrectly")
+	require.Equal(t, 200, entry.HTTPStatus, "HTTP status parsed incorrectly")
+	require.Equal(t, "", entry.ErrorCode, "A dash error code should have been parsed as empty")
+	require.Equal(t, int64(113), entry.BytesSent, "Bytes sent parsed incorrectly")
+	require.Equal(t, int64(0), entry.ObjectSize, "A dash object size should have been parsed as zero")
+	require.Equal(t, int64(7), entry.TotalTime, "Total time parsed incorrectly")
+	require.Equal(t, "", entry.Referrer, "A quoted dash referrer should have been parsed as empty")
+	require.Equal(t, "S3Console/0.4", entry.UserAgent, "User agent parsed incorrectly")
+	require.Equal(t, "awsexamplebucket1.s3.us-west-1.amazonaws.com", entry.HostHeader, "Host header parsed incorrectly")
+}
+
+// TestParseLogEntryErrorCode confirms that the error code of a failed request is parsed as such,
+// rather than taken for a number
+func TestParseLogEntryErrorCode(t *testing.T) {
+	line := testLogLine(testEntry{bucket: "www.example.com", time: time.Date(2020, time.March, 20, 13, 45, 7, 0, time.UTC),
+		operation: "WEBSITE.GET.OBJECT", key: "missing.html", status: 404, errorCode: "NoSuchKey", bytesSent: 300, totalTime: 12})
+	entry, err := parseLogEntry(line)
+	require.Nil(t, err, "A line with an error code should have been parsed: %v", err)
+	require.Equal(t, 404, entry.HTTPStatus, "HTTP status parsed incorrectly")
+	require.Equal(t, "NoSuchKey", entry.ErrorCode, "Error code parsed incorrectly")
+	require.Equal(t, int64(300), entry.BytesSent, "Bytes sent parsed incorrectly")
+	require.Equal(t, int64(12), entry.TotalTime, "Total time parsed incorrectly")
+}
+
+// TestParseLogEntryUserAgent confirms that user agents containing spaces are kept whole
+func TestParseLogEntryUserAgent(t *testing.T) {
+	line := testLogLine(testEntry{
+		bucket:    "www.example.com",
+		time:      time.Date(2020, time.March, 20, 13, 45, 7, 0, time.UTC),
+		remoteIP:  "203.0.113.7",
+		operation: "WEBSITE.GET.OBJECT",
+		key:       "index.html",
+		status:    200,
+		bytesSent: 2048,
+		totalTime: 30,
+		referrer:  "https://www.google.com/",
+		userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:74.0) Gecko/20100101 Firefox/74.0",
+	})
+	entry, err := parseLogEntry(line)
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	require.Equal(t, "Mozilla/5.0 (X11; Linux x86_64; rv:74.0) Gecko/20100101 Firefox/74.0", entry.UserAgent, "User agent parsed incorrectly")
+	require.Equal(t, "https://www.google.com/", entry.Referrer, "Referrer parsed incorrectly")
+	require.Equal(t, int64(30), entry.TotalTime, "Total time parsed incorrectly")
+	require.Equal(t, int64(15), entry.TurnAroundTime, "Turn around time parsed incorrectly")
+}
+
+// TestParseLogEntryFailures confirms that lines that are not web log entries are rejected
+func TestParseLogEntryFailures(t *testing.T) {
+	_, err := parseLogEntry("this is not a web log line")
+	require.NotNil(t, err, "A short line should have been rejected")
+	_, err = parseLogEntry(`owner bucket [yesterday] 192.0.2.3 - ID OP key "GET / HTTP/1.1" 200 - 1 1 1 1 "-" "-"`)
+	require.NotNil(t, err, "A line with an invalid time should have been rejected")
+	_, err = parseLogEntry(`owner bucket [06/Feb/2019:00:00:38 +0000] 192.0.2.3 - ID OP key "GET / HTTP/1.1" OK - 1 1 1 1 "-" "-"`)
+	require.NotNil(t, err, "A line with an invalid status should have been rejected")
+}
@@ -0,0 +1,381 @@
+package s3
+
+// The functions in this file tail the web logs continuously, exposing counts
+// of the traffic as Prometheus metrics.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// requestLabels identifies one of the request counters
+type requestLabels struct {
+	Bucket    string `json:"bucket"`    // The bucket from which the request was served
+	Operation string `json:"operation"` // The S3 operation
+	Status    int    `json:"status"`    // The HTTP status returned
+}
+
+// requestCount is the saved form of one of the request counters
+type requestCount struct {
+	requestLabels
+	Count int64 `json:"count"`
+}
+
+// exporterState is the checkpoint of the exporter, saved after each log object is counted so
+// that the counters carry on from where they left off when the exporter is restarted
+type exporterState struct {
+	LogBucket     string           `json:"log_bucket"`      // The bucket holding the logs
+	Folder        string           `json:"folder"`          // The folder within the bucket
+	LastKey       string           `json:"last_key"`        // The key of the last log object counted
+	Objects       int64            `json:"objects"`         // The number of log objects counted
+	Requests      []requestCount   `json:"requests"`        // Requests by bucket, operation and status
+	BytesSent     map[string]int64 `json:"bytes_sent"`      // Bytes sent by bucket
+	DurationBins  []int64          `json:"duration_bins"`   // Requests by Total Time histogram bin
+	DurationSumMS int64            `json:"duration_sum_ms"` // The sum of the Total Time of all requests
+}
+
+// exporterMetrics holds the counters exposed by the exporter
+type exporterMetrics struct {
+	mutex         sync.Mutex              // Guards the counters from concurrent scrapes and updates
+	lastKey       string                  // The key of the last log object counted
+	objects       int64                   // The number of log objects counted
+	requests      map[requestLabels]int64 // Requests by bucket, operation and status
+	bytesSent     map[string]int64        // Bytes sent by bucket
+	durationBins  []int64                 // Requests by Total Time histogram bin, not cumulative
+	durationSumMS int64                   // The sum of the Total Time of all requests
+}
+
+// RunExporter tails the web logs defined in the given session structure, counting the requests
+// recorded in each new log object as it arrives, and serves the counts in the Prometheus text
+// exposition format at /metrics on the given listen address. The bucket is polled for new log
+// objects at the given interval.
+//
+// After each log object is counted, the counters and the key of the object are saved to the
+// checkpoint file so that, when restarted, the exporter resumes with the same counts from the
+// next object. If the checkpoint file does not exist yet, counting starts from the current
+// time. An empty checkpoint file name does without a checkpoint altogether: nothing is loaded
+// or saved, and counting always starts from the current time.
+//
+// RunExporter only returns if there is a problem, in which case the error is returned.
+func RunExporter(session *SlogSession, listen, checkpoint string, interval time.Duration) error {
+
+	// The bucket cannot be polled continuously
+	if interval <= 0 {
+		return fmt.Errorf("Invalid poll interval: %v is not greater than zero", interval)
+	}
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// Prepare to filter for the source buckets
+	session.buckets, err = newBucketFilter(session.SourceBuckets)
+	if err != nil {
+		return err
+	}
+
+	// Carry on from where we left off, if we have been here before
+	metrics := newExporterMetrics(session.Folder + "/" + time.Now().UTC().Format(keyTimeFormat))
+	if len(checkpoint) > 0 {
+		err = metrics.load(session, checkpoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Serve the metrics in the background
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.write(w)
+	})
+	errChan := make(chan error)
+	go func() {
+		errChan <- http.ListenAndServe(listen, mux)
+	}()
+	fmt.Printf("Serving metrics on http://%s/metrics\n", listen)
+
+	// Poll for new log objects until the server fails
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err = metrics.poll(session, checkpoint)
+		if err != nil {
+			// S3 hiccups are not fatal, we will try again next time round
+			fmt.Println("Error polling for log objects:", err)
+		}
+		select {
+		case err = <-errChan:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// newExporterMetrics returns a set of zeroed counters that will begin counting log objects
+// following the given key
+func newExporterMetrics(lastKey string) *exporterMetrics {
+	return &exporterMetrics{
+		lastKey:      lastKey,
+		requests:     make(map[requestLabels]int64),
+		bytesSent:    make(map[string]int64),
+		durationBins: make([]int64, len(latencyHistogramBins)+1),
+	}
+}
+
+// poll counts each of the log objects that have arrived since the last one counted, saving a
+// checkpoint after each unless the checkpoint file name is empty.
+//
+// An error is returned if there is a problem, otherwise nil.
+func (m *exporterMetrics) poll(session *SlogSession, checkpoint string) error {
+
+	// Collect the new objects
+	objects := make([]*s3.Object, 0)
+	err := listLogObjects(session, m.lastKey, "", func(obj *s3.Object) bool {
+		objects = append(objects, obj)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// Count each in turn
+	for _, obj := range objects {
+		key := *obj.Key
+		body, err := openLogObject(session, obj)
+		if err != nil && session.Retry.SkipMissing && isMissing(err) {
+			// Count nothing, but move on past the missing object
+			fmt.Println("Skipped missing log object:", key)
+			body, err = ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Count the object as it streams in, only adding it to the exposed counters once it
+		// has been read in full so that they never include part of an object
+		counts := newExporterMetrics(key)
+		err = counts.addObject(session, key, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		err = m.commit(session, checkpoint, counts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit adds the counts of a log object to the exposed counters. The checkpoint of the result
+// is saved first, so that the exposed counters never get ahead of it: if the save fails they
+// are left as they were, and the object is counted again on the next poll. Only poll changes
+// the counters, so it can read them without the mutex.
+//
+// An error is returned if the checkpoint cannot be saved, otherwise nil.
+func (m *exporterMetrics) commit(session *SlogSession, checkpoint string, counts *exporterMetrics) error {
+	if len(checkpoint) > 0 {
+		next := newExporterMetrics(m.lastKey)
+		next.merge(m)
+		next.merge(counts)
+		data, err := json.Marshal(next.state(session))
+		if err != nil {
+			return err
+		}
+		err = saveCheckpoint(checkpoint, data)
+		if err != nil {
+			return err
+		}
+	}
+	m.mutex.Lock()
+	m.merge(counts)
+	m.mutex.Unlock()
+	return nil
+}
+
+// addObject counts the requests recorded in a log object as it is read. The caller must hold
+// the mutex, or be the only user of the counters.
+//
+// An error is returned if the object cannot be read in full, otherwise nil.
+func (m *exporterMetrics) addObject(session *SlogSession, key string, r io.Reader) error {
+	err := forEachLine(r, func(line string) error {
+		if entry := selectEntry(session, line); entry != nil {
+			m.add(entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.objects++
+	m.lastKey = key
+	return nil
+}
+
+// merge adds the counts of another set of counters to these, which move on to the last key
+// that the other counted. The caller must hold the mutex.
+func (m *exporterMetrics) merge(other *exporterMetrics) {
+	for l, count := range other.requests {
+		m.requests[l] += count
+	}
+	for bucket, bytes := range other.bytesSent {
+		m.bytesSent[bucket] += bytes
+	}
+	for i, count := range other.durationBins {
+		m.durationBins[i] += count
+	}
+	m.durationSumMS += other.durationSumMS
+	m.objects += other.objects
+	m.lastKey = other.lastKey
+}
+
+// add counts a single request, leaving it out of the duration histogram if AWS recorded its
+// Total Time as "-". The caller must hold the mutex.
+func (m *exporterMetrics) add(entry *LogEntry) {
+	m.requests[requestLabels{Bucket: entry.Bucket, Operation: entry.Operation, Status: entry.HTTPStatus}]++
+	m.bytesSent[entry.Bucket] += entry.BytesSent
+	if !entry.HasTotalTime {
+		return
+	}
+	bin := sort.Search(len(latencyHistogramBins), func(i int) bool {
+		return entry.TotalTime <= latencyHistogramBins[i]
+	})
+	m.durationBins[bin]++
+	m.durationSumMS += entry.TotalTime
+}
+
+// write renders the counters in the Prometheus text exposition format
+func (m *exporterMetrics) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Requests by bucket, operation and status, in a stable order
+	fmt.Fprintln(w, "# HELP slog_requests_total Requests recorded in the S3 web logs.")
+	fmt.Fprintln(w, "# TYPE slog_requests_total counter")
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Bucket != labels[j].Bucket {
+			return labels[i].Bucket < labels[j].Bucket
+		}
+		if labels[i].Operation != labels[j].Operation {
+			return labels[i].Operation < labels[j].Operation
+		}
+		return labels[i].Status < labels[j].Status
+	})
+	for _, l := range labels {
+		fmt.Fprintf(w, "slog_requests_total{bucket=\"%s\",operation=\"%s\",status=\"%d\"} %d\n",
+			escapeLabel(l.Bucket), escapeLabel(l.Operation), l.Status, m.requests[l])
+	}
+
+	// Bytes sent by bucket
+	fmt.Fprintln(w, "# HELP slog_bytes_sent_total Bytes sent in response to the requests recorded in the S3 web logs.")
+	fmt.Fprintln(w, "# TYPE slog_bytes_sent_total counter")
+	buckets := make([]string, 0, len(m.bytesSent))
+	for bucket := range m.bytesSent {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		fmt.Fprintf(w, "slog_bytes_sent_total{bucket=\"%s\"} %d\n", escapeLabel(bucket), m.bytesSent[bucket])
+	}
+
+	// The Total Time histogram, whose buckets are cumulative
+	fmt.Fprintln(w, "# HELP slog_request_duration_seconds The Total Time of the requests recorded in the S3 web logs.")
+	fmt.Fprintln(w, "# TYPE slog_request_duration_seconds histogram")
+	var cumulative int64
+	for i, count := range m.durationBins {
+		cumulative += count
+		le := "+Inf"
+		if i < len(latencyHistogramBins) {
+			le = strconv.FormatFloat(float64(latencyHistogramBins[i])/1000, 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "slog_request_duration_seconds_bucket{le=\"%s\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(w, "slog_request_duration_seconds_sum %s\n", strconv.FormatFloat(float64(m.durationSumMS)/1000, 'g', -1, 64))
+	fmt.Fprintf(w, "slog_request_duration_seconds_count %d\n", cumulative)
+
+	// Progress through the bucket
+	fmt.Fprintln(w, "# HELP slog_log_objects_total Log objects read from the S3 log bucket.")
+	fmt.Fprintln(w, "# TYPE slog_log_objects_total counter")
+	fmt.Fprintf(w, "slog_log_objects_total %d\n", m.objects)
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// state returns the checkpoint of the counters. The caller must hold the mutex.
+func (m *exporterMetrics) state(session *SlogSession) *exporterState {
+	state := &exporterState{
+		LogBucket:     session.LogBucket,
+		Folder:        session.Folder,
+		LastKey:       m.lastKey,
+		Objects:       m.objects,
+		Requests:      make([]requestCount, 0, len(m.requests)),
+		BytesSent:     m.bytesSent,
+		DurationBins:  m.durationBins,
+		DurationSumMS: m.durationSumMS,
+	}
+	for l, count := range m.requests {
+		state.Requests = append(state.Requests, requestCount{requestLabels: l, Count: count})
+	}
+	return state
+}
+
+// load restores the counters from the checkpoint file, if it exists. An error is returned if
+// the file cannot be read or if it belongs to a different log bucket or folder.
+func (m *exporterMetrics) load(session *SlogSession, checkpoint string) error {
+
+	// No checkpoint is not a problem, we start from scratch
+	data, err := ioutil.ReadFile(checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := &exporterState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return fmt.Errorf("Invalid checkpoint file %s: %w", checkpoint, err)
+	}
+
+	// Counts from another bucket would be meaningless
+	if state.LogBucket != session.LogBucket || state.Folder != session.Folder {
+		return fmt.Errorf("Checkpoint file %s belongs to %s/%s", checkpoint, state.LogBucket, state.Folder)
+	}
+	if len(state.DurationBins) != len(m.durationBins) {
+		return fmt.Errorf("Invalid checkpoint file %s: wrong number of duration bins", checkpoint)
+	}
+
+	// Restore the counters
+	m.lastKey = state.LastKey
+	m.objects = state.Objects
+	for _, c := range state.Requests {
+		m.requests[c.requestLabels] = c.Count
+	}
+	for bucket, bytes := range state.BytesSent {
+		m.bytesSent[bucket] = bytes
+	}
+	copy(m.durationBins, state.DurationBins)
+	m.durationSumMS = state.DurationSumMS
+	return nil
+}
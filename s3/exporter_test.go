@@ -0,0 +1,177 @@
+package s3
+
+// Unit tests for the slog Prometheus exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// exporterTestObject builds the content of a log object holding the given entries
+func exporterTestObject(entries ...testEntry) io.Reader {
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, testLogLine(e))
+	}
+	return strings.NewReader(strings.Join(lines, "\n") + "\n")
+}
+
+// TestExporterMetrics confirms that requests are counted and exposed in the Prometheus format
+func TestExporterMetrics(t *testing.T) {
+	session := &SlogSession{LogBucket: "my-logs", Folder: "root", SourceBuckets: []string{"example.com"}}
+	session.buckets, _ = newBucketFilter(session.SourceBuckets)
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+
+	// Count an object, with an entry for another source bucket to be ignored
+	m := newExporterMetrics("root/2020-03-20-13-00-00")
+	err := m.addObject(session, "root/2020-03-20-13-00-01-AAAA", exporterTestObject(
+		testEntry{bucket: "example.com", time: now, operation: "WEBSITE.GET.OBJECT", key: "index.html", status: 200, bytesSent: 1000, totalTime: 10},
+		testEntry{bucket: "example.com", time: now, operation: "WEBSITE.GET.OBJECT", key: "index.html", status: 200, bytesSent: 1000, totalTime: 20},
+		testEntry{bucket: "example.com", time: now, operation: "WEBSITE.GET.OBJECT", key: "missing.html", status: 404, totalTime: 6000},
+		testEntry{bucket: "other.com", time: now, operation: "WEBSITE.GET.OBJECT", key: "index.html", status: 200, bytesSent: 1000},
+	))
+	require.Nil(t, err, "Counting the object should not have failed")
+	require.Equal(t, "root/2020-03-20-13-00-01-AAAA", m.lastKey, "Last key should have been recorded")
+	require.Equal(t, int64(1), m.objects, "Object count incorrect")
+
+	// Render the metrics
+	var out bytes.Buffer
+	m.write(&out)
+	metrics := out.String()
+	require.Contains(t, metrics, `slog_requests_total{bucket="example.com",operation="WEBSITE.GET.OBJECT",status="200"} 2`, "200 count incorrect")
+	require.Contains(t, metrics, `slog_requests_total{bucket="example.com",operation="WEBSITE.GET.OBJECT",status="404"} 1`, "404 count incorrect")
+	require.NotContains(t, metrics, "other.com", "Other source buckets should have been ignored")
+	require.Contains(t, metrics, `slog_bytes_sent_total{bucket="example.com"} 2000`, "Bytes sent incorrect")
+	require.Contains(t, metrics, `slog_request_duration_seconds_bucket{le="0.01"} 1`, "First histogram bucket incorrect")
+	require.Contains(t, metrics, `slog_request_duration_seconds_bucket{le="0.025"} 2`, "Histogram buckets should be cumulative")
+	require.Contains(t, metrics, `slog_request_duration_seconds_bucket{le="5"} 2`, "Last finite histogram bucket incorrect")
+	require.Contains(t, metrics, `slog_request_duration_seconds_bucket{le="+Inf"} 3`, "Infinite histogram bucket incorrect")
+	require.Contains(t, metrics, "slog_request_duration_seconds_sum 6.03\n", "Histogram sum incorrect")
+	require.Contains(t, metrics, "slog_request_duration_seconds_count 3\n", "Histogram count incorrect")
+	require.Contains(t, metrics, "slog_log_objects_total 1\n", "Log object count incorrect")
+}
+
+// TestExporterMerge confirms that the counts of one object are added to the running totals
+func TestExporterMerge(t *testing.T) {
+	m := newExporterMetrics("root/2020-03-20-13-00-00")
+	m.add(&LogEntry{Bucket: "example.com", Operation: "WEBSITE.GET.OBJECT", HTTPStatus: 200, BytesSent: 10, TotalTime: 30, HasTotalTime: true})
+	m.objects++
+
+	// Count a second object separately, then merge it in
+	counts := newExporterMetrics("root/2020-03-20-13-05-00-BBBB")
+	counts.add(&LogEntry{Bucket: "example.com", Operation: "WEBSITE.GET.OBJECT", HTTPStatus: 200, BytesSent: 5, TotalTime: 20, HasTotalTime: true})
+	counts.add(&LogEntry{Bucket: "other.com", Operation: "WEBSITE.GET.OBJECT", HTTPStatus: 404, TotalTime: 10, HasTotalTime: true})
+	counts.objects++
+	counts.lastKey = "root/2020-03-20-13-05-00-BBBB"
+	m.merge(counts)
+
+	require.Equal(t, "root/2020-03-20-13-05-00-BBBB", m.lastKey, "Last key should have moved on")
+	require.Equal(t, int64(2), m.objects, "Object count incorrect")
+	require.Equal(t, int64(2), m.requests[requestLabels{Bucket: "example.com", Operation: "WEBSITE.GET.OBJECT", Status: 200}], "Request count incorrect")
+	require.Equal(t, int64(1), m.requests[requestLabels{Bucket: "other.com", Operation: "WEBSITE.GET.OBJECT", Status: 404}], "Request count incorrect")
+	require.Equal(t, int64(15), m.bytesSent["example.com"], "Bytes sent incorrect")
+	require.Equal(t, int64(60), m.durationSumMS, "Duration sum incorrect")
+}
+
+// TestEscapeLabel confirms that label values are escaped as Prometheus requires
+func TestEscapeLabel(t *testing.T) {
+	require.Equal(t, `a\\b\"c\nd`, escapeLabel("a\\b\"c\nd"), "Label escaped incorrectly")
+}
+
+// TestExporterCheckpoint confirms that the counters survive a round trip through the checkpoint file
+func TestExporterCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-exporter")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "exporter.json")
+	session := &SlogSession{LogBucket: "my-logs", Folder: "root"}
+
+	// A missing checkpoint leaves the counters as they were
+	m := newExporterMetrics("root/2020-03-20-13-00-00")
+	require.Nil(t, m.load(session, checkpoint), "A missing checkpoint should not be an error")
+	require.Equal(t, "root/2020-03-20-13-00-00", m.lastKey, "The starting key should be unchanged")
+
+	// Count something and save it
+	m.add(&LogEntry{Bucket: "example.com", Operation: "WEBSITE.GET.OBJECT", HTTPStatus: 200, BytesSent: 10, TotalTime: 30, HasTotalTime: true})
+	m.objects++
+	m.lastKey = "root/2020-03-20-13-05-00-BBBB"
+	data, err := json.Marshal(m.state(session))
+	require.Nil(t, err, "failed to marshal the checkpoint")
+	require.Nil(t, saveCheckpoint(checkpoint, data), "failed to save the checkpoint")
+
+	// Restore into fresh counters
+	restored := newExporterMetrics("root/2020-03-21-00-00-00")
+	require.Nil(t, restored.load(session, checkpoint), "failed to load the checkpoint")
+	require.Equal(t, m.lastKey, restored.lastKey, "Last key not restored")
+	require.Equal(t, m.objects, restored.objects, "Object count not restored")
+	require.Equal(t, m.requests, restored.requests, "Request counts not restored")
+	require.Equal(t, m.bytesSent, restored.bytesSent, "Bytes sent not restored")
+	require.Equal(t, m.durationBins, restored.durationBins, "Duration histogram not restored")
+	require.Equal(t, m.durationSumMS, restored.durationSumMS, "Duration sum not restored")
+
+	// A checkpoint for another folder is refused
+	err = newExporterMetrics("").load(&SlogSession{LogBucket: "my-logs", Folder: "other"}, checkpoint)
+	require.NotNil(t, err, "A checkpoint for another folder should have been refused")
+	require.Contains(t, err.Error(), "belongs to my-logs/root", "Expected a checkpoint mismatch error")
+}
+
+// TestExporterCommit confirms that counts are only exposed once their checkpoint has been saved,
+// and that an empty checkpoint file name saves nothing
+func TestExporterCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-exporter")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	session := &SlogSession{LogBucket: "my-logs", Folder: "root"}
+	newCounts := func(key string) *exporterMetrics {
+		counts := newExporterMetrics(key)
+		counts.add(&LogEntry{Bucket: "example.com", Operation: "WEBSITE.GET.OBJECT", HTTPStatus: 200, BytesSent: 10, TotalTime: 30, HasTotalTime: true})
+		counts.objects++
+		return counts
+	}
+
+	// A checkpoint that cannot be saved leaves the exposed counters as they were
+	m := newExporterMetrics("root/2020-03-20-13-00-00")
+	err = m.commit(session, filepath.Join(dir, "missing", "exporter.json"), newCounts("root/2020-03-20-13-05-00-BBBB"))
+	require.NotNil(t, err, "Saving to a missing directory should have failed")
+	require.Equal(t, "root/2020-03-20-13-00-00", m.lastKey, "The last key should not have moved on")
+	require.Equal(t, int64(0), m.objects, "The object should not have been counted")
+	require.Empty(t, m.requests, "The requests should not have been counted")
+
+	// One that can is saved with the counts that are exposed
+	checkpoint := filepath.Join(dir, "exporter.json")
+	require.Nil(t, m.commit(session, checkpoint, newCounts("root/2020-03-20-13-05-00-BBBB")), "Commit failed")
+	require.Equal(t, "root/2020-03-20-13-05-00-BBBB", m.lastKey, "The last key should have moved on")
+	restored := newExporterMetrics("")
+	require.Nil(t, restored.load(session, checkpoint), "failed to load the checkpoint")
+	require.Equal(t, m.lastKey, restored.lastKey, "Saved last key incorrect")
+	require.Equal(t, m.objects, restored.objects, "Saved object count incorrect")
+	require.Equal(t, m.requests, restored.requests, "Saved request counts incorrect")
+
+	// Without a checkpoint file, the counts are exposed and nothing is saved
+	require.Nil(t, os.Remove(checkpoint), "failed to remove the checkpoint")
+	require.Nil(t, m.commit(session, "", newCounts("root/2020-03-20-13-10-00-CCCC")), "Commit without a checkpoint failed")
+	require.Equal(t, int64(2), m.objects, "The object should have been counted")
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err, "failed to list the temporary directory")
+	require.Empty(t, files, "Nothing should have been saved")
+}
+
+// TestExporterPollInterval confirms that an interval that would poll continuously is rejected
+// rather than left to panic
+func TestExporterPollInterval(t *testing.T) {
+	session := &SlogSession{LogBucket: "my-logs", Folder: "root"}
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		err := RunExporter(session, "127.0.0.1:0", "", interval)
+		require.NotNil(t, err, "A poll interval of %v should have been rejected", interval)
+		require.Contains(t, err.Error(), "Invalid poll interval", "Expected invalid poll interval error")
+	}
+}
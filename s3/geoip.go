@@ -0,0 +1,130 @@
+package s3
+
+// The functions in this file work out where visitors are from, using local
+// copies of MaxMind GeoIP databases so that no network access is needed.
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoLocation describes where the remote IP address of a web log entry is found
+type GeoLocation struct {
+	Country string `json:"country,omitempty"` // The ISO 3166-1 code of the country, e.g. DE
+	Region  string `json:"region,omitempty"`  // The name of the region, state or province within the country
+	City    string `json:"city,omitempty"`    // The name of the city
+	ASN     uint   `json:"asn,omitempty"`     // The number of the autonomous system that announces the address
+	ASOrg   string `json:"as_org,omitempty"`  // The organization that operates the autonomous system
+}
+
+// geoRecord is the layout of the GeoIP database records that we are interested in. City and
+// country databases fill some fields, ASN databases others, so any of them will do.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoLocator finds where IP addresses are
+type geoLocator interface {
+	locate(ip net.IP) *GeoLocation // Returns nil if nothing is known of the address
+	close()                        // Releases the resources held by the locator
+}
+
+// geoDatabases locates IP addresses using one or more MaxMind GeoIP databases, remembering
+// the addresses that it has already looked up
+type geoDatabases struct {
+	readers []*maxminddb.Reader     // The open databases, consulted in order
+	known   map[string]*GeoLocation // The locations found for each address, by address
+}
+
+// openGeoDatabases opens the named MaxMind GeoIP databases, e.g. GeoLite2-City.mmdb and
+// GeoLite2-ASN.mmdb, which are read locally without any network access.
+//
+// Returns the locator if all goes well, otherwise an error.
+func openGeoDatabases(files []string) (geoLocator, error) {
+	g := &geoDatabases{known: make(map[string]*GeoLocation)}
+	for _, file := range files {
+		reader, err := maxminddb.Open(file)
+		if err != nil {
+			g.close()
+			return nil, fmt.Errorf("Unable to open GeoIP database %s: %w", file, err)
+		}
+		g.readers = append(g.readers, reader)
+	}
+	return g, nil
+}
+
+// locate looks an IP address up in each of the databases, merging what they know of it
+func (g *geoDatabases) locate(ip net.IP) *GeoLocation {
+
+	// We may have seen this visitor before
+	key := string(ip)
+	if loc, ok := g.known[key]; ok {
+		return loc
+	}
+
+	// Ask each database in turn, keeping the first answer to each question
+	loc := &GeoLocation{}
+	for _, reader := range g.readers {
+		var record geoRecord
+		if reader.Lookup(ip, &record) != nil {
+			continue
+		}
+		if len(loc.Country) == 0 {
+			loc.Country = record.Country.ISOCode
+		}
+		if len(loc.Region) == 0 && len(record.Subdivisions) > 0 {
+			loc.Region = record.Subdivisions[0].Names["en"]
+		}
+		if len(loc.City) == 0 {
+			loc.City = record.City.Names["en"]
+		}
+		if loc.ASN == 0 {
+			loc.ASN = record.ASN
+			loc.ASOrg = record.ASOrg
+		}
+	}
+	if *loc == (GeoLocation{}) {
+		loc = nil
+	}
+	g.known[key] = loc
+	return loc
+}
+
+// close closes the databases
+func (g *geoDatabases) close() {
+	for _, reader := range g.readers {
+		reader.Close()
+	}
+}
+
+// locateEntry fills in the location of a web log entry, if the session has GeoIP databases
+// and they know where its remote IP address is
+func locateEntry(session *SlogSession, entry *LogEntry) {
+	if session.geo == nil {
+		return
+	}
+	if ip := net.ParseIP(entry.RemoteIP); ip != nil {
+		entry.Location = session.geo.locate(ip)
+	}
+}
+
+// acceptsCountry returns true if the session is not filtering by country, or if the entry was
+// made from one of the countries sought
+func acceptsCountry(session *SlogSession, entry *LogEntry) bool {
+	if len(session.Countries) == 0 {
+		return true
+	}
+	return entry.Location != nil && stringSliceContains(session.Countries, entry.Location.Country)
+}
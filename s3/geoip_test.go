@@ -0,0 +1,183 @@
+package s3
+
+// Unit tests for locating visitors with GeoIP databases
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbControl returns the control bytes that introduce a MaxMind DB data field of the given
+// type and size. Sizes are assumed to be less than 285.
+func mmdbControl(fieldType, size int) []byte {
+	sizeBits, extra := size, []byte{}
+	if size >= 29 {
+		sizeBits, extra = 29, []byte{byte(size - 29)}
+	}
+	if fieldType < 8 {
+		return append([]byte{byte(fieldType<<5 | sizeBits)}, extra...)
+	}
+	return append([]byte{byte(sizeBits), byte(fieldType - 7)}, extra...)
+}
+
+// mmdbEncode encodes a string, uint32, map or array value in the MaxMind DB data format
+func mmdbEncode(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return append(mmdbControl(2, len(v)), v...)
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		return append(mmdbControl(6, len(b)), b...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := mmdbControl(7, len(v))
+		for _, k := range keys {
+			out = append(out, mmdbEncode(k)...)
+			out = append(out, mmdbEncode(v[k])...)
+		}
+		return out
+	case []interface{}:
+		out := mmdbControl(11, len(v))
+		for _, e := range v {
+			out = append(out, mmdbEncode(e)...)
+		}
+		return out
+	}
+	panic("unsupported MaxMind DB value")
+}
+
+// writeTestGeoDatabase writes an IPv4 MaxMind DB file to the given directory that holds a
+// single record for a single network, returning the name of the file
+func writeTestGeoDatabase(t *testing.T, dir, name, network string, record map[string]interface{}) string {
+	_, ipNet, err := net.ParseCIDR(network)
+	require.Nil(t, err, "failed to parse the test network")
+	bits, _ := ipNet.Mask.Size()
+	ip := ipNet.IP.To4()
+
+	// The search tree has one node per bit of the network, each of whose records leads either
+	// to the next node, or to the data for the last, or to nothing at all
+	nodeCount := uint32(bits)
+	dataPointer := nodeCount + 16
+	tree := make([]byte, 0, bits*6)
+	for i := 0; i < bits; i++ {
+		next := uint32(i + 1)
+		if i == bits-1 {
+			next = dataPointer
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[(ip[i/8]>>(7-uint(i%8)))&1] = next
+		for _, r := range records {
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	// Followed by the separator, the data, and the metadata
+	content := append(tree, make([]byte, 16)...)
+	content = append(content, mmdbEncode(record)...)
+	content = append(content, "\xAB\xCD\xEFMaxMind.com"...)
+	content = append(content, mmdbEncode(map[string]interface{}{
+		"node_count":  nodeCount,
+		"record_size": uint32(24),
+		"ip_version":  uint32(4),
+	})...)
+	file := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(file, content, 0644), "failed to write the test GeoIP database")
+	return file
+}
+
+// writeTestGeoDatabases writes a city database and an ASN database, both covering 203.0.113.0/24,
+// returning their names
+func writeTestGeoDatabases(t *testing.T, dir string) []string {
+	return []string{
+		writeTestGeoDatabase(t, dir, "city.mmdb", "203.0.113.0/24", map[string]interface{}{
+			"country":      map[string]interface{}{"iso_code": "DE"},
+			"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"en": "Land Berlin"}}},
+			"city":         map[string]interface{}{"names": map[string]interface{}{"en": "Berlin"}},
+		}),
+		writeTestGeoDatabase(t, dir, "asn.mmdb", "203.0.113.0/24", map[string]interface{}{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example Networks",
+		}),
+	}
+}
+
+// TestGeoDatabases confirms that what the databases know of an address is merged together
+func TestGeoDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog-geoip")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+
+	geo, err := openGeoDatabases(writeTestGeoDatabases(t, dir))
+	require.Nil(t, err, "Opening the GeoIP databases should not have failed: %v", err)
+	defer geo.close()
+
+	loc := geo.locate(net.ParseIP("203.0.113.7"))
+	require.Equal(t, &GeoLocation{Country: "DE", Region: "Land Berlin", City: "Berlin", ASN: 64500, ASOrg: "Example Networks"},
+		loc, "Location found incorrectly")
+	require.Equal(t, loc, geo.locate(net.ParseIP("203.0.113.7")), "The same address should be located the same way twice")
+	require.Nil(t, geo.locate(net.ParseIP("192.0.2.1")), "An unknown address should not have been located")
+
+	// A missing database is an error
+	_, err = openGeoDatabases([]string{filepath.Join(dir, "missing.mmdb")})
+	require.NotNil(t, err, "Opening a missing database should have failed")
+	require.Contains(t, err.Error(), "Unable to open GeoIP database", "Expected a GeoIP database error")
+}
+
+// testGeoLocator locates addresses from a fixed list
+type testGeoLocator map[string]*GeoLocation
+
+func (g testGeoLocator) locate(ip net.IP) *GeoLocation { return g[ip.String()] }
+func (g testGeoLocator) close()                        {}
+
+// TestSelectEntryByCountry confirms that entries are located and filtered by country
+func TestSelectEntryByCountry(t *testing.T) {
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	session := &SlogSession{
+		Countries: []string{"DE"},
+		geo:       testGeoLocator{"203.0.113.7": &GeoLocation{Country: "DE"}, "192.0.2.1": &GeoLocation{Country: "FR"}},
+	}
+
+	entry := selectEntry(session, testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "203.0.113.7", status: 200}))
+	require.NotNil(t, entry, "An entry from Germany should have been selected")
+	require.Equal(t, "DE", entry.Location.Country, "The entry should have been located")
+	require.Nil(t, selectEntry(session, testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "192.0.2.1", status: 200})),
+		"An entry from France should not have been selected")
+	require.Nil(t, selectEntry(session, testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "198.51.100.1", status: 200})),
+		"An entry from nowhere known should not have been selected")
+
+	// Without a country filter, everything is selected, located or not
+	session.Countries = nil
+	require.NotNil(t, selectEntry(session, testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "198.51.100.1", status: 200})),
+		"Entries should not be filtered without a country filter")
+}
+
+// TestReportCountries confirms that the report counts requests by country when visitors are located
+func TestReportCountries(t *testing.T) {
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	report := newTrafficReport(now, now.Add(time.Hour), nil)
+	report.add(&LogEntry{Time: now, HTTPStatus: 200, Location: &GeoLocation{Country: "DE"}})
+	report.add(&LogEntry{Time: now, HTTPStatus: 200, Location: &GeoLocation{Country: "DE"}})
+	report.add(&LogEntry{Time: now, HTTPStatus: 200, Location: &GeoLocation{Country: "FR"}})
+	report.add(&LogEntry{Time: now, HTTPStatus: 200})
+
+	page := report.page(&SlogSession{StartDateTime: now, EndDateTime: now.Add(time.Hour)}, now)
+	require.Len(t, page.Countries, 2, "Only located requests should have been counted by country")
+	require.Equal(t, "DE", page.Countries[0].Name, "The most common country should come first")
+	require.Equal(t, int64(2), page.Countries[0].Count, "Country count incorrect")
+}
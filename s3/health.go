@@ -0,0 +1,121 @@
+package s3
+
+// The functions in this file check the health of web log delivery, looking for
+// periods in which no logs arrived and for logs that arrived late.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// HealthThresholds defines the limits beyond which log delivery is considered unhealthy
+type HealthThresholds struct {
+	MaxGap   time.Duration // The longest tolerable period without any log objects
+	MaxDelay time.Duration // The longest tolerable 99th percentile delivery delay
+}
+
+// healthSample records when a log object's entries were generated and when it was delivered
+type healthSample struct {
+	keyTime      time.Time // The timestamp embedded in the object key
+	lastModified time.Time // The time at which the object was written to S3
+}
+
+// healthReport holds the findings of a log delivery health check
+type healthReport struct {
+	objects              int            // The number of log objects examined
+	p50, p90, p99, max   time.Duration  // Delivery delay percentiles
+	gaps                 []inventoryGap // Periods without logs that exceeded the gap threshold
+	delayBreached        bool           // True if the 99th percentile delay exceeded its threshold
+	anyThresholdBreached bool           // True if any threshold was breached
+}
+
+// CheckHealth lists the log objects between the session start and end times, reporting the
+// delivery delay of each (the difference between its key timestamp and its S3 LastModified time)
+// as percentiles, and any periods longer than the gap threshold in which no logs arrived.
+//
+// Returns true if the delivery is healthy, i.e. no thresholds were breached. An error is
+// returned if there is a problem, otherwise nil.
+func CheckHealth(session *SlogSession, thresholds HealthThresholds) (bool, error) {
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return false, err
+	}
+
+	// Work out which keys bound the time window
+	prefix := session.Folder + "/"
+	startAfter := prefix + session.StartDateTime.UTC().Format(keyTimeFormat)
+	endAfter := prefix + session.EndDateTime.UTC().Format(keyTimeFormat)
+
+	// List the objects, collecting their timestamps
+	samples := make([]healthSample, 0)
+	err = listLogObjects(session, startAfter, endAfter, func(obj *s3.Object) bool {
+		keyTime, err := parseKeyTime(prefix, *obj.Key)
+		if err == nil {
+			samples = append(samples, healthSample{keyTime: keyTime, lastModified: aws.TimeValue(obj.LastModified)})
+		}
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Make sense of it all and tell the world
+	report := assessHealth(session.StartDateTime, session.EndDateTime, time.Now(), samples, thresholds)
+	fmt.Printf("Objects: %d\n", report.objects)
+	fmt.Printf("Delivery delay: p50=%v p90=%v p99=%v max=%v\n", report.p50, report.p90, report.p99, report.max)
+	if report.delayBreached {
+		fmt.Printf("Delivery delay p99 of %v exceeds the %v threshold\n", report.p99, thresholds.MaxDelay)
+	}
+	for _, gap := range report.gaps {
+		fmt.Printf("Gap: no logs from %s to %s (%v)\n",
+			gap.start.Format(time.RFC3339), gap.end.Format(time.RFC3339), gap.end.Sub(gap.start))
+	}
+	if report.anyThresholdBreached {
+		fmt.Println("Status: UNHEALTHY")
+	} else {
+		fmt.Println("Status: HEALTHY")
+	}
+	return !report.anyThresholdBreached, nil
+}
+
+// assessHealth calculates delivery delay percentiles for a set of samples, which must be in key
+// time order, and finds the periods between the start and end times that exceed the gap
+// threshold without any samples. Logs for the most recent traffic may legitimately still be
+// on their way, up to the delay threshold after it, so the window is cut short that long
+// before the current time, now, rather than reporting logs in transit as a gap.
+func assessHealth(start, end, now time.Time, samples []healthSample, thresholds HealthThresholds) *healthReport {
+	report := &healthReport{objects: len(samples), gaps: make([]inventoryGap, 0)}
+
+	// Delivery delays, in seconds for the benefit of the percentile calculation
+	delays := make([]float64, len(samples))
+	for i, sample := range samples {
+		delays[i] = sample.lastModified.Sub(sample.keyTime).Seconds()
+	}
+	p50, p90, p99, max := percentiles(delays)
+	report.p50 = time.Duration(p50 * float64(time.Second))
+	report.p90 = time.Duration(p90 * float64(time.Second))
+	report.p99 = time.Duration(p99 * float64(time.Second))
+	report.max = time.Duration(max * float64(time.Second))
+	report.delayBreached = report.p99 > thresholds.MaxDelay
+
+	// Gaps, including those at either end of the window
+	delivered := now.Add(-thresholds.MaxDelay)
+	if end.After(delivered) {
+		end = delivered
+	}
+	previous := start
+	for _, sample := range append(samples, healthSample{keyTime: end}) {
+		if sample.keyTime.Sub(previous) > thresholds.MaxGap {
+			report.gaps = append(report.gaps, inventoryGap{start: previous, end: sample.keyTime})
+		}
+		previous = sample.keyTime
+	}
+
+	report.anyThresholdBreached = report.delayBreached || len(report.gaps) > 0
+	return report
+}
@@ -0,0 +1,70 @@
+package s3
+
+// Unit tests for the slog S3 log delivery health functions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAssessHealth confirms that delivery delays and gaps are measured correctly
+// and compared against the thresholds
+func TestAssessHealth(t *testing.T) {
+
+	// An hour long window with samples delivered five minutes late, except for one
+	// that was delivered an hour late, and nothing at all in the middle of the window
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	samples := make([]healthSample, 0)
+	for _, offset := range []time.Duration{1, 2, 3, 4, 45, 50, 55, 59} {
+		keyTime := start.Add(offset * time.Minute)
+		samples = append(samples, healthSample{keyTime: keyTime, lastModified: keyTime.Add(5 * time.Minute)})
+	}
+	samples[7].lastModified = samples[7].keyTime.Add(time.Hour)
+
+	// Generous thresholds should be satisfied
+	report := assessHealth(start, end, end, samples, HealthThresholds{MaxGap: time.Hour, MaxDelay: 2 * time.Hour})
+	require.Equal(t, 8, report.objects, "Object count incorrect")
+	require.Equal(t, 5*time.Minute, report.p50, "Median delay incorrect")
+	require.Equal(t, time.Hour, report.max, "Maximum delay incorrect")
+	require.Empty(t, report.gaps, "There should have been no gaps")
+	require.False(t, report.anyThresholdBreached, "No thresholds should have been breached")
+
+	// Tight thresholds should not
+	report = assessHealth(start, end, end, samples, HealthThresholds{MaxGap: 30 * time.Minute, MaxDelay: 10 * time.Minute})
+	require.True(t, report.delayBreached, "The delay threshold should have been breached")
+	require.Equal(t, 1, len(report.gaps), "Expected one gap")
+	require.Equal(t, start.Add(4*time.Minute), report.gaps[0].start, "Gap start incorrect")
+	require.Equal(t, start.Add(45*time.Minute), report.gaps[0].end, "Gap end incorrect")
+	require.True(t, report.anyThresholdBreached, "Thresholds should have been breached")
+
+	// No samples at all is one big gap
+	report = assessHealth(start, end, end, nil, HealthThresholds{MaxGap: 30 * time.Minute, MaxDelay: 10 * time.Minute})
+	require.Equal(t, 1, len(report.gaps), "Expected the whole window to be a gap")
+	require.True(t, report.anyThresholdBreached, "An empty window should breach the gap threshold")
+
+	// A window that runs on past now has no gap for the time yet to come
+	report = assessHealth(start, end.Add(24*time.Hour), end, samples, HealthThresholds{MaxGap: time.Hour, MaxDelay: 2 * time.Hour})
+	require.Empty(t, report.gaps, "The future should not have been reported as a gap")
+	require.False(t, report.anyThresholdBreached, "No thresholds should have been breached")
+
+	// Nor for the most recent traffic, whose logs may not have been delivered yet
+	thresholds := HealthThresholds{MaxGap: 30 * time.Minute, MaxDelay: 2 * time.Hour}
+	report = assessHealth(start, end.Add(2*time.Hour), end.Add(2*time.Hour), samples[:4], thresholds)
+	require.Equal(t, 1, len(report.gaps), "Expected only the gap up to the delivery threshold")
+	require.Equal(t, start.Add(4*time.Minute), report.gaps[0].start, "Gap start incorrect")
+	require.Equal(t, end, report.gaps[0].end, "The gap should have ended at now less the maximum delay")
+}
+
+// TestPercentile checks the nearest rank percentile calculation
+func TestPercentile(t *testing.T) {
+	values := []float64{10, 1, 9, 2, 8, 3, 7, 4, 6, 5}
+	p50, p90, p99, max := percentiles(values)
+	require.Equal(t, 5.0, p50, "50th percentile incorrect")
+	require.Equal(t, 9.0, p90, "90th percentile incorrect")
+	require.Equal(t, 10.0, p99, "99th percentile incorrect")
+	require.Equal(t, 10.0, max, "Maximum incorrect")
+	require.Equal(t, 0.0, percentile(nil, 50), "Percentile of nothing should be zero")
+}
@@ -0,0 +1,233 @@
+package s3
+
+// The functions in this file chart the shape of the traffic recorded in the
+// web logs over time.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxHistogramIntervals is the most intervals into which a histogram time window may be divided,
+// limiting the memory that a short interval over a long window can take
+const MaxHistogramIntervals = 100000
+
+// statusClassBars are the characters used to draw the bar segments of each HTTP status class,
+// indexed by the first digit of the status code
+var statusClassBars = []string{"?", "1", "█", "▓", "▒", "░"}
+
+// timeBucket accumulates the traffic of one interval of a time series
+type timeBucket struct {
+	start    time.Time // The time at which the interval begins
+	requests int64     // The number of requests
+	errors   int64     // The number of requests that failed with a 4xx or 5xx status
+	bytes    int64     // The number of bytes sent
+	byClass  [6]int64  // The number of requests by status class, indexed by the first digit of the status code
+}
+
+// timeSeries accumulates traffic into consecutive intervals of equal length
+type timeSeries struct {
+	interval time.Duration // The length of each interval
+	buckets  []*timeBucket // The intervals covering the time window, in time order
+}
+
+// timeBucketState is the checkpoint of one interval of a time series
+type timeBucketState struct {
+	Start    time.Time `json:"start"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+	Bytes    int64     `json:"bytes"`
+	ByClass  [6]int64  `json:"by_class"`
+}
+
+// timeSeriesState is the checkpoint of a time series
+type timeSeriesState struct {
+	Interval time.Duration      `json:"interval"` // The length of each interval
+	Buckets  []*timeBucketState `json:"buckets"`  // The intervals covering the time window, in time order
+}
+
+// DisplayHistogram reads the web logs defined in the given session structure and charts the
+// number of requests, errors and bytes sent in each interval of the time window. If splitStatus
+// is true, the bars of the chart are divided by HTTP status class. The CSV output format gives
+// the raw series, suitable for a spreadsheet, rather than a chart.
+//
+// An error is returned if there is a problem, otherwise nil.
+func DisplayHistogram(session *SlogSession, interval time.Duration, splitStatus bool, format OutputFormat) error {
+
+	// Accumulate the traffic through the whole of the log window
+	series, err := newTimeSeries(session.StartDateTime, session.EndDateTime, interval)
+	if err != nil {
+		return err
+	}
+	err = readEntries(session, series, func(entry *LogEntry) error {
+		series.add(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Render the results
+	if format == CSV {
+		return writeTimeSeriesCSV(series, splitStatus)
+	}
+	writeTimeSeriesChart(series, splitStatus)
+	return nil
+}
+
+// newTimeSeries returns an empty time series with intervals of the given length covering the
+// time window from start to end. Intervals are aligned to whole multiples of their length in UTC.
+//
+// An error is returned if the interval is not positive or would divide the time window into
+// more than MaxHistogramIntervals intervals.
+func newTimeSeries(start, end time.Time, interval time.Duration) (*timeSeries, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid histogram interval: %v is not greater than zero", interval)
+	}
+	first := start.UTC().Truncate(interval)
+	if end.Sub(first)/interval >= MaxHistogramIntervals {
+		return nil, fmt.Errorf("Invalid histogram interval: %v divides the time window into more than %d intervals",
+			interval, MaxHistogramIntervals)
+	}
+	series := &timeSeries{interval: interval}
+	for t := first; t.Before(end); t = t.Add(interval) {
+		series.buckets = append(series.buckets, &timeBucket{start: t})
+	}
+	return series, nil
+}
+
+// add accounts for an entry in the interval in which it falls. Entries that fall outside of
+// the time window, as a few that are recorded in log objects at its edges will, are ignored.
+func (s *timeSeries) add(entry *LogEntry) {
+
+	// Find the interval
+	if len(s.buckets) == 0 || entry.Time.Before(s.buckets[0].start) {
+		return
+	}
+	i := int(entry.Time.Sub(s.buckets[0].start) / s.interval)
+	if i >= len(s.buckets) {
+		return
+	}
+
+	// Count the entry
+	b := s.buckets[i]
+	b.requests++
+	b.bytes += entry.BytesSent
+	if entry.HTTPStatus >= 400 {
+		b.errors++
+	}
+	class := entry.HTTPStatus / 100
+	if class < 0 || class >= len(b.byClass) {
+		class = 0
+	}
+	b.byClass[class]++
+}
+
+// save returns the checkpoint of the series
+func (s *timeSeries) save() interface{} {
+	return s.state()
+}
+
+// restore replaces the counts of the series with those of a checkpoint
+func (s *timeSeries) restore(data json.RawMessage) error {
+	state := &timeSeriesState{}
+	err := json.Unmarshal(data, state)
+	if err != nil {
+		return err
+	}
+	return s.load(state)
+}
+
+// state returns the checkpoint of the series
+func (s *timeSeries) state() *timeSeriesState {
+	state := &timeSeriesState{Interval: s.interval, Buckets: make([]*timeBucketState, 0, len(s.buckets))}
+	for _, b := range s.buckets {
+		state.Buckets = append(state.Buckets, &timeBucketState{Start: b.start, Requests: b.requests,
+			Errors: b.errors, Bytes: b.bytes, ByClass: b.byClass})
+	}
+	return state
+}
+
+// load replaces the counts of the series with those of a checkpoint, which must have been made
+// for the same intervals of the same time window
+func (s *timeSeries) load(state *timeSeriesState) error {
+	if state.Interval != s.interval || len(state.Buckets) != len(s.buckets) {
+		return errors.New("the checkpoint was made with different intervals")
+	}
+	for i, b := range state.Buckets {
+		if b == nil || !b.Start.Equal(s.buckets[i].start) {
+			return errors.New("the checkpoint was made for a different time window")
+		}
+	}
+	for i, b := range state.Buckets {
+		s.buckets[i] = &timeBucket{start: s.buckets[i].start, requests: b.Requests, errors: b.Errors,
+			bytes: b.Bytes, byClass: b.ByClass}
+	}
+	return nil
+}
+
+// writeTimeSeriesChart displays a time series as a bar chart of requests per interval
+func writeTimeSeriesChart(series *timeSeries, splitStatus bool) {
+
+	// Scale the bars to the busiest interval
+	var max int64
+	for _, b := range series.buckets {
+		if b.requests > max {
+			max = b.requests
+		}
+	}
+
+	// One line per interval
+	fmt.Printf("%-20s %10s %8s %12s\n", "TIME", "REQUESTS", "ERRORS", "BYTES")
+	for _, b := range series.buckets {
+		bar := textBar(b.requests, max, barWidth)
+		if splitStatus {
+			bar = statusClassBar(b, max)
+		}
+		fmt.Printf("%-20s %10d %8d %12d %s\n", b.start.Format(time.RFC3339), b.requests, b.errors, b.bytes, bar)
+	}
+
+	// Explain the bar segments
+	if splitStatus {
+		fmt.Printf("\n%s 2xx  %s 3xx  %s 4xx  %s 5xx\n", statusClassBars[2], statusClassBars[3], statusClassBars[4], statusClassBars[5])
+	}
+}
+
+// statusClassBar draws a bar divided into segments for each HTTP status class
+func statusClassBar(b *timeBucket, max int64) string {
+	var bar strings.Builder
+	for class, count := range b.byClass {
+		if count > 0 {
+			bar.WriteString(strings.Repeat(statusClassBars[class], len([]rune(textBar(count, max, barWidth)))))
+		}
+	}
+	return bar.String()
+}
+
+// writeTimeSeriesCSV writes a time series as comma separated values
+func writeTimeSeriesCSV(series *timeSeries, splitStatus bool) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"time", "requests", "errors", "bytes"}
+	if splitStatus {
+		header = append(header, "1xx", "2xx", "3xx", "4xx", "5xx")
+	}
+	w.Write(header)
+	for _, b := range series.buckets {
+		record := []string{b.start.Format(time.RFC3339), strconv.FormatInt(b.requests, 10),
+			strconv.FormatInt(b.errors, 10), strconv.FormatInt(b.bytes, 10)}
+		if splitStatus {
+			for _, count := range b.byClass[1:] {
+				record = append(record, strconv.FormatInt(count, 10))
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
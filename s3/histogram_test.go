@@ -0,0 +1,69 @@
+package s3
+
+// Unit tests for the slog traffic histogram functions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTimeSeries confirms that entries are accumulated in the right intervals
+func TestTimeSeries(t *testing.T) {
+
+	// A half hour window in five minute intervals
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	series, err := newTimeSeries(start, start.Add(30*time.Minute), 5*time.Minute)
+	require.Nil(t, err, "newTimeSeries should have succeeded: %v", err)
+	require.Equal(t, 6, len(series.buckets), "Expected six intervals")
+
+	// Entries in the first and third intervals, plus some outside the window
+	series.add(&LogEntry{Time: start, HTTPStatus: 200, BytesSent: 100})
+	series.add(&LogEntry{Time: start.Add(4 * time.Minute), HTTPStatus: 304})
+	series.add(&LogEntry{Time: start.Add(12 * time.Minute), HTTPStatus: 404, BytesSent: 10})
+	series.add(&LogEntry{Time: start.Add(14 * time.Minute), HTTPStatus: 503})
+	series.add(&LogEntry{Time: start.Add(-time.Minute), HTTPStatus: 200})
+	series.add(&LogEntry{Time: start.Add(30 * time.Minute), HTTPStatus: 200})
+
+	first := series.buckets[0]
+	require.Equal(t, int64(2), first.requests, "First interval request count incorrect")
+	require.Equal(t, int64(0), first.errors, "First interval error count incorrect")
+	require.Equal(t, int64(100), first.bytes, "First interval byte count incorrect")
+	require.Equal(t, int64(1), first.byClass[2], "First interval 2xx count incorrect")
+	require.Equal(t, int64(1), first.byClass[3], "First interval 3xx count incorrect")
+
+	third := series.buckets[2]
+	require.Equal(t, int64(2), third.requests, "Third interval request count incorrect")
+	require.Equal(t, int64(2), third.errors, "Third interval error count incorrect")
+	require.Equal(t, int64(1), third.byClass[4], "Third interval 4xx count incorrect")
+	require.Equal(t, int64(1), third.byClass[5], "Third interval 5xx count incorrect")
+
+	require.Equal(t, int64(0), series.buckets[1].requests, "Second interval should be empty")
+	require.Equal(t, int64(0), series.buckets[5].requests, "Entries after the window should have been ignored")
+}
+
+// TestTimeSeriesLimits confirms that intervals that would never end, or would take too much
+// memory, are rejected
+func TestTimeSeriesLimits(t *testing.T) {
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	for _, interval := range []time.Duration{0, -5 * time.Minute} {
+		_, err := newTimeSeries(start, start.Add(time.Hour), interval)
+		require.NotNil(t, err, "An interval of %v should have been rejected", interval)
+		require.Contains(t, err.Error(), "is not greater than zero", "Expected non-positive interval error")
+	}
+	_, err := newTimeSeries(start, start.Add(365*24*time.Hour), time.Second)
+	require.NotNil(t, err, "Too many intervals should have been rejected")
+	require.Contains(t, err.Error(), "more than", "Expected too many intervals error")
+}
+
+// TestStatusClassBar confirms that split bars have a segment for each status class
+func TestStatusClassBar(t *testing.T) {
+	b := &timeBucket{requests: 20}
+	b.byClass[2] = 10
+	b.byClass[4] = 10
+	bar := statusClassBar(b, 20)
+	require.Equal(t, 50, len([]rune(bar)), "The busiest interval should fill the chart width")
+	require.Equal(t, statusClassBars[2], string([]rune(bar)[0]), "The bar should start with 2xx")
+	require.Equal(t, statusClassBars[4], string([]rune(bar)[49]), "The bar should end with 4xx")
+}
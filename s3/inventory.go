@@ -0,0 +1,149 @@
+package s3
+
+// The functions in this file take an inventory of the web log objects in a
+// bucket folder without downloading any of them.
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// inventoryPeriod summarizes the log objects whose key timestamps fall within one period of an inventory
+type inventoryPeriod struct {
+	start    time.Time // The time at which the period begins
+	objects  int       // The number of objects in the period
+	bytes    int64     // The total size of those objects
+	firstKey string    // The key of the earliest object in the period
+	lastKey  string    // The key of the latest object in the period
+}
+
+// inventoryGap describes a run of consecutive periods in which no log objects arrived
+type inventoryGap struct {
+	start time.Time // The start of the first empty period
+	end   time.Time // The end of the last empty period
+}
+
+// inventory accumulates log object counts and sizes into fixed length periods
+type inventory struct {
+	period  time.Duration      // The length of each period
+	periods []*inventoryPeriod // The periods covering the inventory time window, in time order
+}
+
+// DisplayInventory lists the log objects between the session start and end times, without
+// downloading them, and prints the number of objects, their total size and the first and last
+// key found in each period of the given length. Runs of periods in which no logs arrived are
+// reported as gaps.
+//
+// An error is returned if there is a problem, otherwise nil.
+func DisplayInventory(session *SlogSession, period time.Duration) error {
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// Work out which keys bound the time window, listing from the start of the first period
+	// rather than the start time so that the first period is counted in full
+	inv := newInventory(session.StartDateTime, session.EndDateTime, period)
+	prefix := session.Folder + "/"
+	startAfter := prefix + inv.start(session.StartDateTime).Format(keyTimeFormat)
+	endAfter := prefix + session.EndDateTime.UTC().Format(keyTimeFormat)
+
+	// List the objects, adding each to the inventory
+	err = listLogObjects(session, startAfter, endAfter, func(obj *s3.Object) bool {
+		keyTime, err := parseKeyTime(prefix, *obj.Key)
+		if err == nil {
+			inv.add(keyTime, *obj.Key, aws.Int64Value(obj.Size))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// Display the periods in columns
+	var totalObjects int
+	var totalBytes int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "PERIOD\tOBJECTS\tBYTES\t FIRST KEY\t LAST KEY\t")
+	for _, p := range inv.periods {
+		fmt.Fprintf(w, "%s\t%d\t%d\t %s\t %s\t\n", p.start.Format(time.RFC3339), p.objects, p.bytes, p.firstKey, p.lastKey)
+		totalObjects += p.objects
+		totalBytes += p.bytes
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %d objects, %d bytes\n", totalObjects, totalBytes)
+
+	// Then the gaps
+	for _, gap := range inv.gaps() {
+		fmt.Printf("Gap: no logs from %s to %s\n", gap.start.Format(time.RFC3339), gap.end.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// newInventory returns an empty inventory with periods of the given length covering the
+// time window from start to end. Periods are aligned to whole multiples of their length
+// in UTC so that, for example, daily periods begin at midnight.
+func newInventory(start, end time.Time, period time.Duration) *inventory {
+	inv := &inventory{period: period}
+	for t := start.UTC().Truncate(period); t.Before(end); t = t.Add(period) {
+		inv.periods = append(inv.periods, &inventoryPeriod{start: t})
+	}
+	return inv
+}
+
+// start returns the start of the first period of the inventory, or the given time if the
+// inventory has no periods
+func (inv *inventory) start(otherwise time.Time) time.Time {
+	if len(inv.periods) == 0 {
+		return otherwise.UTC()
+	}
+	return inv.periods[0].start
+}
+
+// add counts an object in the period in which its key time falls. Objects that fall
+// outside of the inventory time window are ignored.
+func (inv *inventory) add(keyTime time.Time, key string, size int64) {
+
+	// Find the period
+	if len(inv.periods) == 0 || keyTime.Before(inv.periods[0].start) {
+		return
+	}
+	i := int(keyTime.Sub(inv.periods[0].start) / inv.period)
+	if i >= len(inv.periods) {
+		return
+	}
+
+	// Count the object, relying on keys arriving in time order
+	p := inv.periods[i]
+	if p.objects == 0 {
+		p.firstKey = key
+	}
+	p.lastKey = key
+	p.objects++
+	p.bytes += size
+}
+
+// gaps returns the runs of consecutive periods that contain no objects
+func (inv *inventory) gaps() []inventoryGap {
+	gaps := make([]inventoryGap, 0)
+	var gap *inventoryGap
+	for _, p := range inv.periods {
+		switch {
+		case p.objects > 0:
+			gap = nil
+		case gap == nil:
+			gaps = append(gaps, inventoryGap{start: p.start, end: p.start.Add(inv.period)})
+			gap = &gaps[len(gaps)-1]
+		default:
+			gap.end = p.start.Add(inv.period)
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,46 @@
+package s3
+
+// Unit tests for the slog S3 inventory functions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestInventory confirms that objects are counted in the right periods and that
+// runs of empty periods are reported as gaps
+func TestInventory(t *testing.T) {
+
+	// A six hour window that does not start on the hour
+	start := time.Date(2020, time.March, 20, 10, 30, 0, 0, time.UTC)
+	inv := newInventory(start, start.Add(6*time.Hour), time.Hour)
+	require.Equal(t, 7, len(inv.periods), "Expected seven hourly periods")
+	require.Equal(t, time.Date(2020, time.March, 20, 10, 0, 0, 0, time.UTC), inv.periods[0].start, "Periods should be aligned to the hour")
+	require.Equal(t, inv.periods[0].start, inv.start(start), "Listing should start with the first period, not the start time")
+
+	// Add objects to the first, fourth and last periods plus one outside the window
+	inv.add(start, "root/a", 10)
+	inv.add(start.Add(10*time.Minute), "root/b", 20)
+	inv.add(start.Add(3*time.Hour), "root/c", 30)
+	inv.add(start.Add(6*time.Hour), "root/d", 40)
+	inv.add(start.Add(24*time.Hour), "root/e", 50)
+	require.Equal(t, 2, inv.periods[0].objects, "First period object count incorrect")
+	require.Equal(t, int64(30), inv.periods[0].bytes, "First period byte count incorrect")
+	require.Equal(t, "root/a", inv.periods[0].firstKey, "First period first key incorrect")
+	require.Equal(t, "root/b", inv.periods[0].lastKey, "First period last key incorrect")
+	require.Equal(t, 1, inv.periods[6].objects, "Last period object count incorrect")
+
+	// The empty periods should have been gathered into two gaps
+	gaps := inv.gaps()
+	require.Equal(t, 2, len(gaps), "Expected two gaps")
+	require.Equal(t, time.Date(2020, time.March, 20, 11, 0, 0, 0, time.UTC), gaps[0].start, "First gap start incorrect")
+	require.Equal(t, time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC), gaps[0].end, "First gap end incorrect")
+	require.Equal(t, time.Date(2020, time.March, 20, 14, 0, 0, 0, time.UTC), gaps[1].start, "Second gap start incorrect")
+	require.Equal(t, time.Date(2020, time.March, 20, 16, 0, 0, 0, time.UTC), gaps[1].end, "Second gap end incorrect")
+
+	// An empty window starts where it was asked to
+	empty := newInventory(start, start.Truncate(time.Hour), time.Hour)
+	require.Equal(t, start, empty.start(start), "An empty inventory should start at the start time")
+}
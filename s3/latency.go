@@ -0,0 +1,316 @@
+package s3
+
+// The functions in this file analyze the time taken to serve the requests
+// recorded in the web logs.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// LatencyBreakdown is an enumeration of the ways in which latency statistics can be broken down
+type LatencyBreakdown int
+
+// The possible values of LatencyBreakdown
+const (
+	BYKEY       LatencyBreakdown = iota // Statistics for each key requested
+	BYOPERATION                         // Statistics for each S3 operation
+	BYSIZE                              // Statistics for each range of object sizes
+	BYCOUNTRY                           // Statistics for each country from which requests were made
+)
+
+// latencyHistogramBins are the inclusive upper bounds, in milliseconds, of the bins of the total time
+// histogram, as they are of the exporter's Prometheus buckets. Requests taking longer than the last
+// bound are counted in an extra, final, bin.
+var latencyHistogramBins = []int64{10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// sizeBuckets are the object size ranges used to break down latency statistics
+var sizeBuckets = []struct {
+	limit int64  // The upper bound of the range, exclusive
+	name  string // The name of the range
+}{
+	{1 << 10, "< 1KB"},
+	{10 << 10, "1KB - 10KB"},
+	{100 << 10, "10KB - 100KB"},
+	{1 << 20, "100KB - 1MB"},
+	{10 << 20, "1MB - 10MB"},
+}
+
+// latencySeries collects the timings of a group of requests, in milliseconds
+type latencySeries struct {
+	name       string        // The name of the group
+	requests   int           // The number of requests, whether or not their timings were recorded
+	totals     latencySketch // The Total Times of the requests for which they were recorded
+	turnArouds latencySketch // The Turn-Around Times of the requests for which they were recorded
+}
+
+// latencyTracker collects request timings overall and broken down into groups
+type latencyTracker struct {
+	overall    *latencySeries                                 // All of the requests
+	breakdowns map[LatencyBreakdown]map[string]*latencySeries // Requests grouped by each breakdown
+	histogram  []int64                                        // Counts of requests by total time bin
+}
+
+// latencySeriesState is the checkpoint of a latency series
+type latencySeriesState struct {
+	Name        string              `json:"name"`
+	Requests    int                 `json:"requests"`
+	Totals      *latencySketchState `json:"totals"`
+	TurnArounds *latencySketchState `json:"turn_arounds"`
+}
+
+// latencyTrackerState is the checkpoint of a latency tracker
+type latencyTrackerState struct {
+	Overall    *latencySeriesState                                 `json:"overall"`    // All of the requests
+	Breakdowns map[LatencyBreakdown]map[string]*latencySeriesState `json:"breakdowns"` // Requests grouped by each breakdown
+	Histogram  []int64                                             `json:"histogram"`  // Counts of requests by total time bin
+}
+
+// DisplayLatency reads the web logs defined in the given session structure and reports the
+// 50th, 90th and 99th percentiles, estimated to within 2%, and the maximum of the Total Time
+// and Turn-Around Time of the requests, overall and broken down in each of the ways requested.
+// Breakdowns are limited
+// to the top most requested groups; a top of zero or less shows every group. If histogram is
+// true, an ASCII histogram of the Total Time is also displayed.
+//
+// An error is returned if there is a problem, otherwise nil.
+func DisplayLatency(session *SlogSession, breakdowns []LatencyBreakdown, top int, histogram bool) error {
+
+	// Collect the timings through the whole of the log window
+	tracker := newLatencyTracker(breakdowns)
+	err := readEntries(session, tracker, func(entry *LogEntry) error {
+		tracker.add(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Display the statistics in columns
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\tREQUESTS\tTOTAL P50\tP90\tP99\tMAX\tTURN-AROUND P50\tP90\tP99\tMAX\t")
+	writeLatencyRow(w, tracker.overall)
+	for _, breakdown := range breakdowns {
+		fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t")
+		for _, series := range tracker.ranked(breakdown, top) {
+			writeLatencyRow(w, series)
+		}
+	}
+	w.Flush()
+	fmt.Println("\nAll times in milliseconds")
+
+	// Followed by the histogram if asked for
+	if histogram {
+		fmt.Println("\nTotal Time histogram:")
+		var max int64
+		for _, count := range tracker.histogram {
+			if count > max {
+				max = count
+			}
+		}
+		for i, count := range tracker.histogram {
+			fmt.Printf("%12s %8d %s\n", latencyBinName(i), count, textBar(count, max, barWidth))
+		}
+	}
+	return nil
+}
+
+// writeLatencyRow writes the statistics of a latency series as a row of tab separated columns
+func writeLatencyRow(w *tabwriter.Writer, series *latencySeries) {
+	t50, t90, t99, tMax := series.totals.percentiles()
+	a50, a90, a99, aMax := series.turnArouds.percentiles()
+	fmt.Fprintf(w, "%s\t%d\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t\n",
+		series.name, series.requests, t50, t90, t99, tMax, a50, a90, a99, aMax)
+}
+
+// newLatencyTracker returns an empty tracker for the given breakdowns
+func newLatencyTracker(breakdowns []LatencyBreakdown) *latencyTracker {
+	t := &latencyTracker{
+		overall:    &latencySeries{name: "All requests"},
+		breakdowns: make(map[LatencyBreakdown]map[string]*latencySeries),
+		histogram:  make([]int64, len(latencyHistogramBins)+1),
+	}
+	for _, breakdown := range breakdowns {
+		t.breakdowns[breakdown] = make(map[string]*latencySeries)
+	}
+	return t
+}
+
+// add records the timings of a request overall and in each of the breakdowns
+func (t *latencyTracker) add(entry *LogEntry) {
+
+	// Overall
+	t.overall.add(entry)
+
+	// In each breakdown
+	for breakdown, groups := range t.breakdowns {
+		var name string
+		switch breakdown {
+		case BYKEY:
+			name = orDash(entry.Key)
+		case BYOPERATION:
+			name = orDash(entry.Operation)
+		case BYSIZE:
+			name = sizeBucketName(entry.ObjectSize)
+		case BYCOUNTRY:
+			name = "-"
+			if entry.Location != nil {
+				name = orDash(entry.Location.Country)
+			}
+		}
+		series := groups[name]
+		if series == nil {
+			series = &latencySeries{name: name}
+			groups[name] = series
+		}
+		series.add(entry)
+	}
+
+	// In the histogram, if AWS recorded how long the request took
+	if !entry.HasTotalTime {
+		return
+	}
+	bin := sort.Search(len(latencyHistogramBins), func(i int) bool {
+		return entry.TotalTime <= latencyHistogramBins[i]
+	})
+	t.histogram[bin]++
+}
+
+// ranked returns the groups of a breakdown in descending order of request count, limited to
+// the given number of groups if top is greater than zero. Size ranges are always returned in
+// size order, and all of them, since there are few of them and their order means something.
+func (t *latencyTracker) ranked(breakdown LatencyBreakdown, top int) []*latencySeries {
+
+	// Size ranges are a special case
+	groups := t.breakdowns[breakdown]
+	ranked := make([]*latencySeries, 0, len(groups))
+	if breakdown == BYSIZE {
+		for i := 0; i <= len(sizeBuckets); i++ {
+			if series := groups[sizeBucketNameByIndex(i)]; series != nil {
+				ranked = append(ranked, series)
+			}
+		}
+		return ranked
+	}
+
+	// Everything else is ranked by popularity
+	for _, series := range groups {
+		ranked = append(ranked, series)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].requests != ranked[j].requests {
+			return ranked[i].requests > ranked[j].requests
+		}
+		return ranked[i].name < ranked[j].name
+	})
+	if top > 0 && len(ranked) > top {
+		ranked = ranked[:top]
+	}
+	return ranked
+}
+
+// save returns the checkpoint of the tracker
+func (t *latencyTracker) save() interface{} {
+	state := &latencyTrackerState{
+		Overall:    t.overall.save(),
+		Breakdowns: make(map[LatencyBreakdown]map[string]*latencySeriesState, len(t.breakdowns)),
+		Histogram:  t.histogram,
+	}
+	for breakdown, groups := range t.breakdowns {
+		state.Breakdowns[breakdown] = make(map[string]*latencySeriesState, len(groups))
+		for name, series := range groups {
+			state.Breakdowns[breakdown][name] = series.save()
+		}
+	}
+	return state
+}
+
+// restore replaces the timings of the tracker with those of a checkpoint, which must have been
+// made with the same breakdowns
+func (t *latencyTracker) restore(data json.RawMessage) error {
+	state := &latencyTrackerState{}
+	err := json.Unmarshal(data, state)
+	if err != nil {
+		return err
+	}
+	if state.Overall == nil || len(state.Histogram) != len(t.histogram) {
+		return errors.New("the checkpoint was not made by a latency analysis")
+	}
+	if len(state.Breakdowns) != len(t.breakdowns) {
+		return errors.New("the checkpoint was made with different breakdowns")
+	}
+	for breakdown := range t.breakdowns {
+		if _, ok := state.Breakdowns[breakdown]; !ok {
+			return errors.New("the checkpoint was made with different breakdowns")
+		}
+	}
+	t.overall = state.Overall.restore()
+	for breakdown, groups := range state.Breakdowns {
+		t.breakdowns[breakdown] = make(map[string]*latencySeries, len(groups))
+		for name, series := range groups {
+			t.breakdowns[breakdown][name] = series.restore()
+		}
+	}
+	t.histogram = state.Histogram
+	return nil
+}
+
+// save returns the checkpoint of the series
+func (s *latencySeries) save() *latencySeriesState {
+	return &latencySeriesState{Name: s.name, Requests: s.requests, Totals: s.totals.state(), TurnArounds: s.turnArouds.state()}
+}
+
+// restore returns the latency series recorded in a checkpoint
+func (s *latencySeriesState) restore() *latencySeries {
+	series := &latencySeries{name: s.Name, requests: s.Requests}
+	if s.Totals != nil {
+		series.totals.load(s.Totals)
+	}
+	if s.TurnArounds != nil {
+		series.turnArouds.load(s.TurnArounds)
+	}
+	return series
+}
+
+// add records the timings of a request in the series. Timings that AWS recorded as "-" are
+// left out rather than counted as taking no time at all.
+func (s *latencySeries) add(entry *LogEntry) {
+	s.requests++
+	if entry.HasTotalTime {
+		s.totals.add(entry.TotalTime)
+	}
+	if entry.HasTurnAround {
+		s.turnArouds.add(entry.TurnAroundTime)
+	}
+}
+
+// sizeBucketName returns the name of the object size range that a size falls in
+func sizeBucketName(size int64) string {
+	for i, bucket := range sizeBuckets {
+		if size < bucket.limit {
+			return sizeBucketNameByIndex(i)
+		}
+	}
+	return sizeBucketNameByIndex(len(sizeBuckets))
+}
+
+// sizeBucketNameByIndex returns the name of the object size range with the given index,
+// where an index beyond the last range names the range of everything bigger.
+func sizeBucketNameByIndex(i int) string {
+	if i < len(sizeBuckets) {
+		return sizeBuckets[i].name
+	}
+	return ">= 10MB"
+}
+
+// latencyBinName returns the name of the histogram bin with the given index
+func latencyBinName(i int) string {
+	if i < len(latencyHistogramBins) {
+		return fmt.Sprintf("<= %dms", latencyHistogramBins[i])
+	}
+	return fmt.Sprintf("> %dms", latencyHistogramBins[len(latencyHistogramBins)-1])
+}
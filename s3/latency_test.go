@@ -0,0 +1,130 @@
+package s3
+
+// Unit tests for the slog latency analysis functions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLatencyTracker confirms that timings are collected overall, in each breakdown, and
+// in the histogram
+func TestLatencyTracker(t *testing.T) {
+
+	tracker := newLatencyTracker([]LatencyBreakdown{BYKEY, BYOPERATION, BYSIZE})
+	tracker.add(&LogEntry{Key: "index.html", Operation: "WEBSITE.GET.OBJECT", ObjectSize: 2048, TotalTime: 20, HasTotalTime: true, TurnAroundTime: 10, HasTurnAround: true})
+	tracker.add(&LogEntry{Key: "index.html", Operation: "WEBSITE.GET.OBJECT", ObjectSize: 2048, TotalTime: 40, HasTotalTime: true, TurnAroundTime: 20, HasTurnAround: true})
+	tracker.add(&LogEntry{Key: "video.mp4", Operation: "WEBSITE.GET.OBJECT", ObjectSize: 50 << 20, TotalTime: 9000, HasTotalTime: true, TurnAroundTime: 30, HasTurnAround: true})
+	tracker.add(&LogEntry{Key: "missing.html", Operation: "WEBSITE.HEAD.OBJECT", TotalTime: 5, HasTotalTime: true, TurnAroundTime: 4, HasTurnAround: true})
+
+	// Overall
+	require.Equal(t, int64(4), tracker.overall.totals.total, "Expected four requests overall")
+
+	// By key, most requested first and limited to the top
+	byKey := tracker.ranked(BYKEY, 2)
+	require.Equal(t, 2, len(byKey), "Key breakdown should have been limited to the top two")
+	require.Equal(t, "index.html", byKey[0].name, "The most requested key should be first")
+	p50, _, _, max := byKey[0].totals.percentiles()
+	require.InEpsilon(t, 20.0, p50, 0.02, "Median total time for the key incorrect")
+	require.Equal(t, 40.0, max, "Maximum total time for the key incorrect")
+	require.Equal(t, "missing.html", byKey[1].name, "Equally requested keys should be in name order")
+
+	// By operation
+	byOperation := tracker.ranked(BYOPERATION, 0)
+	require.Equal(t, 2, len(byOperation), "Expected two operations")
+	require.Equal(t, "WEBSITE.GET.OBJECT", byOperation[0].name, "The most requested operation should be first")
+
+	// By size, in size order
+	bySize := tracker.ranked(BYSIZE, 1)
+	require.Equal(t, 3, len(bySize), "Size ranges should not be limited to the top")
+	require.Equal(t, "< 1KB", bySize[0].name, "Smallest size range should come first")
+	require.Equal(t, "1KB - 10KB", bySize[1].name, "Size range order incorrect")
+	require.Equal(t, ">= 10MB", bySize[2].name, "Largest size range should come last")
+
+	// Histogram bins, whose bounds are inclusive
+	require.Equal(t, int64(1), tracker.histogram[0], "One request of 10ms or less")
+	require.Equal(t, int64(1), tracker.histogram[1], "One request of 25ms or less")
+	require.Equal(t, int64(1), tracker.histogram[2], "One request of 50ms or less")
+	require.Equal(t, int64(1), tracker.histogram[len(latencyHistogramBins)], "One request of more than 5s")
+	edge := newLatencyTracker(nil)
+	edge.add(&LogEntry{TotalTime: 10, HasTotalTime: true})
+	require.Equal(t, int64(1), edge.histogram[0], "A request on a bound should be counted in the bin below it")
+}
+
+// TestLatencySketch confirms that percentiles are estimated closely from a bounded number of bins
+func TestLatencySketch(t *testing.T) {
+	var sketch latencySketch
+	p50, p90, p99, max := sketch.percentiles()
+	require.Equal(t, []float64{0, 0, 0, 0}, []float64{p50, p90, p99, max}, "An empty sketch should give zeroes")
+
+	// A million timings spread from 1ms to 10s
+	for i := int64(0); i < 1000000; i++ {
+		sketch.add(1 + i%10000)
+	}
+	p50, p90, p99, max = sketch.percentiles()
+	require.InEpsilon(t, 5000.0, p50, 0.02, "50th percentile incorrect")
+	require.InEpsilon(t, 9000.0, p90, 0.02, "90th percentile incorrect")
+	require.InEpsilon(t, 9900.0, p99, 0.02, "99th percentile incorrect")
+	require.Equal(t, 10000.0, max, "The maximum should be exact")
+	require.LessOrEqual(t, len(sketch.counts), sketchBins, "The sketch should not grow beyond its bins")
+
+	// Timings too long for the bins are still counted, and small ones are exact
+	sketch = latencySketch{}
+	sketch.add(0)
+	sketch.add(7)
+	sketch.add(1 << 40)
+	require.Len(t, sketch.counts, sketchBins, "A very long timing should have been counted in the last bin")
+	require.Equal(t, 0.0, sketch.percentile(10), "A timing of zero should be estimated as zero")
+	require.Equal(t, "7", fmt.Sprintf("%.0f", sketch.percentile(50)), "A small timing should be estimated to the millisecond")
+	require.Equal(t, float64(1<<40), sketch.percentile(100), "The maximum should be exact")
+
+	// And survive a checkpoint
+	var restored latencySketch
+	restored.load(sketch.state())
+	require.Equal(t, sketch, restored, "The sketch should have been restored")
+}
+
+// TestLatencyMissingTimes confirms that timings recorded as "-" are left out of the statistics
+// rather than counted as taking no time
+func TestLatencyMissingTimes(t *testing.T) {
+
+	// The sample line has a Total Time but no Turn-Around Time, and this one has neither
+	entry, err := parseLogEntry(sampleLogLine)
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	require.True(t, entry.HasTotalTime, "The Total Time should have been recorded as present")
+	require.False(t, entry.HasTurnAround, "The Turn-Around Time should have been recorded as missing")
+	missing, err := parseLogEntry(strings.Replace(sampleLogLine, " 113 - 7 - ", " 113 - - - ", 1))
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	require.False(t, missing.HasTotalTime, "The Total Time should have been recorded as missing")
+
+	// Every request is counted, but only the timings that were recorded make the statistics
+	tracker := newLatencyTracker(nil)
+	tracker.add(entry)
+	tracker.add(missing)
+	require.Equal(t, 2, tracker.overall.requests, "Both requests should have been counted")
+	require.Equal(t, int64(1), tracker.overall.totals.total, "Only the recorded Total Time should have been collected")
+	require.Equal(t, 7.0, tracker.overall.totals.percentile(100), "The recorded Total Time should have been collected")
+	require.Equal(t, int64(0), tracker.overall.turnArouds.total, "No Turn-Around Times should have been collected")
+	var binned int64
+	for _, count := range tracker.histogram {
+		binned += count
+	}
+	require.Equal(t, int64(1), binned, "Only the recorded Total Time should have been in the histogram")
+
+	// Nor does the exporter count a missing time as a quick one
+	m := newExporterMetrics("root/2019-02-06-00-00-00")
+	m.add(missing)
+	require.Equal(t, int64(0), m.durationBins[0], "A missing Total Time should not have been in the histogram")
+	require.Equal(t, 1, len(m.requests), "The request should still have been counted")
+}
+
+// TestTextBar checks the proportions of chart bars
+func TestTextBar(t *testing.T) {
+	require.Equal(t, 10, len([]rune(textBar(100, 100, 10))), "A maximum value should fill the width")
+	require.Equal(t, 5, len([]rune(textBar(50, 100, 10))), "Half the maximum should fill half the width")
+	require.Equal(t, 1, len([]rune(textBar(1, 1000, 10))), "A tiny value should still be visible")
+	require.Equal(t, "", textBar(0, 100, 10), "Nothing should be drawn for zero")
+}
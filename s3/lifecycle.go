@@ -0,0 +1,255 @@
+package s3
+
+// The functions in this file manage the S3 bucket lifecycle rules that allow
+// S3 itself to expire web log objects, rather than slog deleting them client-side.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ShowLifecycle prints the lifecycle rules of the session log bucket in human readable form,
+// marking those that apply to the session folder.
+//
+// An error is returned if there is a problem, otherwise nil.
+func ShowLifecycle(session *SlogSession) error {
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the rules, such as they are
+	rules, err := getLifecycleRules(session)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fmt.Printf("%s has no lifecycle rules\n", session.LogBucket)
+		return nil
+	}
+
+	// Display them one to a line, flagging the ones that cover our folder
+	prefix := session.Folder + "/"
+	for _, rule := range rules {
+		marker := " "
+		if strings.HasPrefix(prefix, lifecycleRulePrefix(rule)) {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, describeLifecycleRule(rule))
+	}
+	return nil
+}
+
+// ApplyLifecycle adds a lifecycle rule to the session log bucket that expires the objects in the
+// session folder the given number of days after they were created. If slog has already added
+// such a rule for the folder, it is replaced; all other rules are left untouched.
+//
+// An error is returned if there is a problem, otherwise nil.
+func ApplyLifecycle(session *SlogSession, expireDays int64) error {
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the existing rules so that we can merge ours with them
+	rules, err := getLifecycleRules(session)
+	if err != nil {
+		return err
+	}
+	rule := newLifecycleRule(session.Folder, expireDays)
+	rules = mergeLifecycleRule(rules, rule)
+
+	// Write the lot back
+	err = putLifecycleRules(session, rules)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %s\n", describeLifecycleRule(rule))
+	return nil
+}
+
+// RemoveLifecycle removes the lifecycle rule that slog added for the session folder, leaving
+// all other rules untouched.
+//
+// An error is returned if there is a problem, otherwise nil.
+func RemoveLifecycle(session *SlogSession) error {
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the existing rules and take ours out
+	rules, err := getLifecycleRules(session)
+	if err != nil {
+		return err
+	}
+	id := lifecycleRuleID(session.Folder)
+	rules, removed := removeLifecycleRule(rules, id)
+	if !removed {
+		return fmt.Errorf("%s has no lifecycle rule named %s", session.LogBucket, id)
+	}
+
+	// S3 will not accept an empty rule set so, if there is nothing left, delete the whole configuration
+	if len(rules) == 0 {
+		err = retry(session, func() error {
+			_, err := session.s3.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+				Bucket: aws.String(session.LogBucket),
+			})
+			return err
+		})
+	} else {
+		err = putLifecycleRules(session, rules)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed lifecycle rule %s\n", id)
+	return nil
+}
+
+// getLifecycleRules fetches the lifecycle rules of the session log bucket. A bucket that has
+// never had a lifecycle configuration yields an empty slice rather than an error.
+func getLifecycleRules(session *SlogSession) ([]*s3.LifecycleRule, error) {
+
+	var output *s3.GetBucketLifecycleConfigurationOutput
+	err := retry(session, func() error {
+		var err error
+		output, err = session.s3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+			Bucket: aws.String(session.LogBucket),
+		})
+		return err
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchLifecycleConfiguration" {
+			return make([]*s3.LifecycleRule, 0), nil
+		}
+		return nil, err
+	}
+	return output.Rules, nil
+}
+
+// putLifecycleRules replaces the lifecycle configuration of the session log bucket with the given rules
+func putLifecycleRules(session *SlogSession, rules []*s3.LifecycleRule) error {
+	return retry(session, func() error {
+		_, err := session.s3.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(session.LogBucket),
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+		})
+		return err
+	})
+}
+
+// lifecycleRuleID returns the ID given to the lifecycle rule that slog manages for a folder
+func lifecycleRuleID(folder string) string {
+	return "slog-" + folder
+}
+
+// newLifecycleRule builds a rule that expires the objects in a folder after the given number of days
+func newLifecycleRule(folder string, expireDays int64) *s3.LifecycleRule {
+	return &s3.LifecycleRule{
+		ID:     aws.String(lifecycleRuleID(folder)),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{
+			Prefix: aws.String(folder + "/"),
+		},
+		Expiration: &s3.LifecycleExpiration{
+			Days: aws.Int64(expireDays),
+		},
+	}
+}
+
+// mergeLifecycleRule returns the given rules with the new rule either replacing the
+// existing rule of the same ID or, if there is no such rule, appended to the end.
+//
+// S3 refuses a configuration that mixes rules scoped by the legacy top level Prefix with
+// rules scoped by a Filter, so if any of the other rules is a legacy one, the new rule is
+// rewritten in the legacy form to match them.
+func mergeLifecycleRule(rules []*s3.LifecycleRule, rule *s3.LifecycleRule) []*s3.LifecycleRule {
+	for _, existing := range rules {
+		if existing.Filter == nil && existing.Prefix != nil && aws.StringValue(existing.ID) != aws.StringValue(rule.ID) {
+			rule.Prefix = aws.String(lifecycleRulePrefix(rule))
+			rule.Filter = nil
+			break
+		}
+	}
+	for i, existing := range rules {
+		if aws.StringValue(existing.ID) == aws.StringValue(rule.ID) {
+			rules[i] = rule
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// removeLifecycleRule returns the given rules less the one with the given ID, together
+// with a flag indicating whether there was such a rule to remove.
+func removeLifecycleRule(rules []*s3.LifecycleRule, id string) ([]*s3.LifecycleRule, bool) {
+	kept := make([]*s3.LifecycleRule, 0, len(rules))
+	for _, rule := range rules {
+		if aws.StringValue(rule.ID) != id {
+			kept = append(kept, rule)
+		}
+	}
+	return kept, len(kept) < len(rules)
+}
+
+// lifecycleRulePrefix returns the key prefix that a lifecycle rule is scoped to, wherever
+// in the rule that happens to have been defined. An empty string means the whole bucket.
+func lifecycleRulePrefix(rule *s3.LifecycleRule) string {
+	if rule.Filter != nil {
+		if rule.Filter.Prefix != nil {
+			return *rule.Filter.Prefix
+		}
+		if rule.Filter.And != nil {
+			return aws.StringValue(rule.Filter.And.Prefix)
+		}
+	}
+	return aws.StringValue(rule.Prefix)
+}
+
+// describeLifecycleRule renders a lifecycle rule as a single line of human readable text
+func describeLifecycleRule(rule *s3.LifecycleRule) string {
+
+	// Start with what and where
+	prefix := lifecycleRulePrefix(rule)
+	if len(prefix) == 0 {
+		prefix = "the whole bucket"
+	}
+	parts := []string{fmt.Sprintf("%s (%s) applies to %s", aws.StringValue(rule.ID), aws.StringValue(rule.Status), prefix)}
+
+	// Then describe each of the actions
+	for _, transition := range rule.Transitions {
+		parts = append(parts, fmt.Sprintf("moves objects to %s after %d days",
+			aws.StringValue(transition.StorageClass), aws.Int64Value(transition.Days)))
+	}
+	if rule.Expiration != nil {
+		switch {
+		case rule.Expiration.Days != nil:
+			parts = append(parts, fmt.Sprintf("expires objects after %d days", *rule.Expiration.Days))
+		case rule.Expiration.Date != nil:
+			parts = append(parts, fmt.Sprintf("expires objects on %s", rule.Expiration.Date.Format("2006-01-02")))
+		case aws.BoolValue(rule.Expiration.ExpiredObjectDeleteMarker):
+			parts = append(parts, "removes expired object delete markers")
+		}
+	}
+	if rule.NoncurrentVersionExpiration != nil {
+		parts = append(parts, fmt.Sprintf("expires noncurrent versions after %d days",
+			aws.Int64Value(rule.NoncurrentVersionExpiration.NoncurrentDays)))
+	}
+	if rule.AbortIncompleteMultipartUpload != nil {
+		parts = append(parts, fmt.Sprintf("aborts incomplete uploads after %d days",
+			aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)))
+	}
+
+	return strings.Join(parts, "; ")
+}
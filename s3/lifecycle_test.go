@@ -0,0 +1,98 @@
+package s3
+
+// Unit tests for the slog S3 lifecycle rule functions
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMergeLifecycleRule confirms that slog rules replace their earlier selves and
+// leave unrelated rules alone
+func TestMergeLifecycleRule(t *testing.T) {
+
+	// Start with somebody else's rule
+	other := &s3.LifecycleRule{ID: aws.String("not-ours"), Status: aws.String(s3.ExpirationStatusEnabled)}
+	rules := []*s3.LifecycleRule{other}
+
+	// Adding ours should append it
+	rules = mergeLifecycleRule(rules, newLifecycleRule("root", 30))
+	require.Equal(t, 2, len(rules), "Our rule should have been appended")
+	require.Equal(t, other, rules[0], "The unrelated rule should have been left alone")
+
+	// Applying again should replace ours
+	rules = mergeLifecycleRule(rules, newLifecycleRule("root", 90))
+	require.Equal(t, 2, len(rules), "Our rule should have been replaced, not appended")
+	require.Equal(t, int64(90), *rules[1].Expiration.Days, "Our rule should have the new expiration")
+
+	// A rule for another folder is a different rule
+	rules = mergeLifecycleRule(rules, newLifecycleRule("archive", 365))
+	require.Equal(t, 3, len(rules), "A rule for another folder should have been appended")
+	require.Equal(t, "archive/", *rules[2].Filter.Prefix, "Our rule should have been scoped by a filter")
+	require.Nil(t, rules[2].Prefix, "Our rule should not have had a legacy prefix")
+}
+
+// TestMergeLegacyLifecycleRule confirms that slog rules added alongside rules scoped by the
+// legacy top level Prefix take the same form, as S3 will not accept a mixture
+func TestMergeLegacyLifecycleRule(t *testing.T) {
+
+	// Somebody else's rule, scoped the old fashioned way
+	legacy := &s3.LifecycleRule{ID: aws.String("not-ours"), Status: aws.String(s3.ExpirationStatusEnabled), Prefix: aws.String("tmp/")}
+	rules := mergeLifecycleRule([]*s3.LifecycleRule{legacy}, newLifecycleRule("root", 30))
+	require.Equal(t, 2, len(rules), "Our rule should have been appended")
+	require.Equal(t, legacy, rules[0], "The legacy rule should have been left alone")
+	require.Nil(t, rules[1].Filter, "Our rule should not have had a filter")
+	require.Equal(t, "root/", aws.StringValue(rules[1].Prefix), "Our rule should have had a legacy prefix")
+	require.Equal(t, "root/", lifecycleRulePrefix(rules[1]), "Our rule should still apply to the folder")
+	require.Contains(t, describeLifecycleRule(rules[1]), "applies to root/", "Our rule should still describe the folder")
+
+	// Once the legacy rule has gone, ours is scoped by a filter again
+	rules, _ = removeLifecycleRule(rules, "not-ours")
+	rules = mergeLifecycleRule(rules, newLifecycleRule("root", 90))
+	require.Equal(t, 1, len(rules), "Our rule should have been replaced, not appended")
+	require.Equal(t, "root/", aws.StringValue(rules[0].Filter.Prefix), "Our rule should have been scoped by a filter")
+	require.Nil(t, rules[0].Prefix, "Our rule should not have had a legacy prefix")
+}
+
+// TestRemoveLifecycleRule confirms that only the named rule is removed
+func TestRemoveLifecycleRule(t *testing.T) {
+
+	rules := []*s3.LifecycleRule{
+		{ID: aws.String("not-ours")},
+		newLifecycleRule("root", 30),
+	}
+
+	// Remove ours
+	rules, removed := removeLifecycleRule(rules, lifecycleRuleID("root"))
+	require.True(t, removed, "Our rule should have been found")
+	require.Equal(t, 1, len(rules), "Only our rule should have been removed")
+	require.Equal(t, "not-ours", *rules[0].ID, "The unrelated rule should remain")
+
+	// Removing it again should report that there was nothing to remove
+	_, removed = removeLifecycleRule(rules, lifecycleRuleID("root"))
+	require.False(t, removed, "There should have been nothing to remove")
+}
+
+// TestDescribeLifecycleRule checks the human readable rendering of lifecycle rules
+func TestDescribeLifecycleRule(t *testing.T) {
+
+	// One of our own
+	require.Equal(t, "slog-root (Enabled) applies to root/; expires objects after 30 days",
+		describeLifecycleRule(newLifecycleRule("root", 30)), "Our rule was described incorrectly")
+
+	// A bucket wide rule with several actions defined with the legacy prefix field
+	rule := &s3.LifecycleRule{
+		ID:     aws.String("tidy"),
+		Status: aws.String(s3.ExpirationStatusDisabled),
+		Prefix: aws.String(""),
+		Transitions: []*s3.Transition{
+			{Days: aws.Int64(60), StorageClass: aws.String(s3.TransitionStorageClassGlacier)},
+		},
+		AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(7)},
+	}
+	require.Equal(t, "tidy (Disabled) applies to the whole bucket; moves objects to GLACIER after 60 days; aborts incomplete uploads after 7 days",
+		describeLifecycleRule(rule), "The bucket wide rule was described incorrectly")
+}
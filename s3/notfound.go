@@ -0,0 +1,219 @@
+package s3
+
+// The functions in this file report on requests for web content that does
+// not exist, i.e. broken links.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// notFoundCount records the number of times a missing key was requested from a given referrer
+type notFoundCount struct {
+	key      string // The key that was requested but not found
+	referrer string // The page that linked to the key, empty if there was no referrer
+	internal bool   // True if the referrer is one of our own pages
+	hits     int    // The number of requests
+}
+
+// notFoundTracker aggregates the requests for missing keys
+type notFoundTracker struct {
+	siteHosts map[string]bool           // Our own host names, in lower case
+	counts    map[string]*notFoundCount // Request counts keyed by key and referrer
+}
+
+// notFoundCountState is the checkpoint of the count of requests for a missing key from a referrer
+type notFoundCountState struct {
+	Key      string `json:"key"`
+	Referrer string `json:"referrer"`
+	Internal bool   `json:"internal"`
+	Hits     int    `json:"hits"`
+}
+
+// notFoundTrackerState is the checkpoint of a tracker of requests for missing keys
+type notFoundTrackerState struct {
+	SiteHosts []string              `json:"site_hosts"` // Our own host names, in lower case and sorted
+	Counts    []*notFoundCountState `json:"counts"`     // The request counts
+}
+
+// DisplayNotFound reads the web logs defined in the given session structure and reports the
+// requests that failed because the key did not exist, aggregated by key and referrer, ranked by
+// the number of requests, and separated into those referred by our own pages and those referred
+// from elsewhere. Referrers are recognized as our own if their host name is one of the siteHosts
+// or, if no siteHosts are given, the name of the bucket from which the request was served.
+//
+// An error is returned if there is a problem, otherwise nil.
+func DisplayNotFound(session *SlogSession, siteHosts []string, format OutputFormat) error {
+
+	// Count the missing keys through the whole of the log window
+	tracker := newNotFoundTracker(siteHosts)
+	err := readEntries(session, tracker, func(entry *LogEntry) error {
+		tracker.add(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Render the results
+	counts := tracker.ranked()
+	if format == CSV {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"hits", "key", "referrer", "source"})
+		for _, c := range counts {
+			w.Write([]string{strconv.Itoa(c.hits), c.key, c.referrer, referrerSource(c.internal)})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	for _, internal := range []bool{true, false} {
+		fmt.Printf("Broken links from %s referrers:\n\n", referrerSource(internal))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HITS\tKEY\tREFERRER")
+		for _, c := range counts {
+			if c.internal == internal {
+				fmt.Fprintf(w, "%d\t%s\t%s\n", c.hits, c.key, orDash(c.referrer))
+			}
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	return nil
+}
+
+// newNotFoundTracker returns an empty tracker that recognizes the given host names as our own
+func newNotFoundTracker(siteHosts []string) *notFoundTracker {
+	t := &notFoundTracker{
+		siteHosts: make(map[string]bool),
+		counts:    make(map[string]*notFoundCount),
+	}
+	for _, host := range siteHosts {
+		t.siteHosts[strings.ToLower(host)] = true
+	}
+	return t
+}
+
+// add counts the entry if it records a request for a missing key
+func (t *notFoundTracker) add(entry *LogEntry) {
+
+	// Only interested in missing keys
+	if entry.HTTPStatus != 404 && entry.ErrorCode != "NoSuchKey" {
+		return
+	}
+
+	// Find or start the count for the key and referrer
+	id := entry.Key + " " + entry.Referrer
+	c := t.counts[id]
+	if c == nil {
+		c = &notFoundCount{key: entry.Key, referrer: entry.Referrer, internal: t.isInternal(entry)}
+		t.counts[id] = c
+	}
+	c.hits++
+}
+
+// isInternal returns true if the referrer of an entry is one of our own pages
+func (t *notFoundTracker) isInternal(entry *LogEntry) bool {
+	referrer, err := url.Parse(entry.Referrer)
+	if err != nil || len(referrer.Hostname()) == 0 {
+		return false
+	}
+	host := strings.ToLower(referrer.Hostname())
+	if len(t.siteHosts) == 0 {
+		return host == strings.ToLower(entry.Bucket)
+	}
+	return t.siteHosts[host]
+}
+
+// save returns the checkpoint of the tracker
+func (t *notFoundTracker) save() interface{} {
+	return t.state()
+}
+
+// restore replaces the counts of the tracker with those of a checkpoint
+func (t *notFoundTracker) restore(data json.RawMessage) error {
+	state := &notFoundTrackerState{}
+	err := json.Unmarshal(data, state)
+	if err != nil {
+		return err
+	}
+	return t.load(state)
+}
+
+// state returns the checkpoint of the tracker
+func (t *notFoundTracker) state() *notFoundTrackerState {
+	state := &notFoundTrackerState{
+		SiteHosts: make([]string, 0, len(t.siteHosts)),
+		Counts:    make([]*notFoundCountState, 0, len(t.counts)),
+	}
+	for host := range t.siteHosts {
+		state.SiteHosts = append(state.SiteHosts, host)
+	}
+	sort.Strings(state.SiteHosts)
+	for _, c := range t.counts {
+		state.Counts = append(state.Counts, &notFoundCountState{Key: c.key, Referrer: c.referrer,
+			Internal: c.internal, Hits: c.hits})
+	}
+	sort.Slice(state.Counts, func(i, j int) bool {
+		if state.Counts[i].Key != state.Counts[j].Key {
+			return state.Counts[i].Key < state.Counts[j].Key
+		}
+		return state.Counts[i].Referrer < state.Counts[j].Referrer
+	})
+	return state
+}
+
+// load replaces the counts of the tracker with those of a checkpoint, which must have been made
+// with the same site host names, since they decide which referrers are internal
+func (t *notFoundTracker) load(state *notFoundTrackerState) error {
+	hosts := make(map[string]bool, len(state.SiteHosts))
+	for _, host := range state.SiteHosts {
+		hosts[host] = true
+	}
+	if len(hosts) != len(t.siteHosts) {
+		return errors.New("the checkpoint was made with different site host names")
+	}
+	for host := range t.siteHosts {
+		if !hosts[host] {
+			return errors.New("the checkpoint was made with different site host names")
+		}
+	}
+	t.counts = make(map[string]*notFoundCount, len(state.Counts))
+	for _, c := range state.Counts {
+		t.counts[c.Key+" "+c.Referrer] = &notFoundCount{key: c.Key, referrer: c.Referrer, internal: c.Internal, hits: c.Hits}
+	}
+	return nil
+}
+
+// ranked returns the counts with the most requested first, then in key and referrer order
+func (t *notFoundTracker) ranked() []*notFoundCount {
+	counts := make([]*notFoundCount, 0, len(t.counts))
+	for _, c := range t.counts {
+		counts = append(counts, c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].hits != counts[j].hits {
+			return counts[i].hits > counts[j].hits
+		}
+		if counts[i].key != counts[j].key {
+			return counts[i].key < counts[j].key
+		}
+		return counts[i].referrer < counts[j].referrer
+	})
+	return counts
+}
+
+// referrerSource describes a referrer as internal or external
+func referrerSource(internal bool) string {
+	if internal {
+		return "internal"
+	}
+	return "external"
+}
@@ -0,0 +1,49 @@
+package s3
+
+// Unit tests for the slog broken link report functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestNotFoundTracker confirms that missing key requests are counted, ranked and
+// separated by referrer source
+func TestNotFoundTracker(t *testing.T) {
+
+	tracker := newNotFoundTracker([]string{"Example.com", "www.example.com"})
+
+	// Successful requests are ignored
+	tracker.add(&LogEntry{Key: "index.html", HTTPStatus: 200, Referrer: "https://www.example.com/"})
+
+	// Three hits on one broken internal link, one on another, and two from elsewhere
+	for i := 0; i < 3; i++ {
+		tracker.add(&LogEntry{Key: "old.html", HTTPStatus: 404, ErrorCode: "NoSuchKey", Referrer: "https://www.example.com/blog/"})
+	}
+	tracker.add(&LogEntry{Key: "gone.png", HTTPStatus: 404, Referrer: "http://example.com/about.html"})
+	tracker.add(&LogEntry{Key: "old.html", HTTPStatus: 404, Referrer: "https://forum.example.org/thread/1"})
+	tracker.add(&LogEntry{Key: "old.html", HTTPStatus: 404})
+
+	counts := tracker.ranked()
+	require.Equal(t, 4, len(counts), "Expected four key and referrer combinations")
+	require.Equal(t, 3, counts[0].hits, "The most requested should come first")
+	require.Equal(t, "old.html", counts[0].key, "The most requested key is incorrect")
+	require.True(t, counts[0].internal, "Our own blog should be an internal referrer")
+	require.Equal(t, "gone.png", counts[1].key, "Equally requested keys should be in key order")
+	require.True(t, counts[1].internal, "Host names should be matched without regard to case")
+	require.Equal(t, "", counts[2].referrer, "An empty referrer should sort first")
+	require.False(t, counts[2].internal, "No referrer should be treated as external")
+	require.False(t, counts[3].internal, "Another site should be an external referrer")
+}
+
+// TestNotFoundBucketHosts confirms that, without explicit host names, the bucket name is
+// taken to be our host name
+func TestNotFoundBucketHosts(t *testing.T) {
+	tracker := newNotFoundTracker(nil)
+	tracker.add(&LogEntry{Bucket: "www.example.com", Key: "a.html", HTTPStatus: 404, Referrer: "https://www.example.com/"})
+	tracker.add(&LogEntry{Bucket: "www.example.com", Key: "b.html", HTTPStatus: 404, Referrer: "https://example.com/"})
+	counts := tracker.ranked()
+	require.True(t, counts[0].internal, "The bucket host name should be internal")
+	require.False(t, counts[1].internal, "Other host names should be external")
+}
@@ -0,0 +1,311 @@
+package s3
+
+// The functions in this file erase the entries made by particular visitors from
+// the web logs, rewriting the log objects that hold them.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PurgeTarget identifies the visitors whose entries are to be purged from the web logs
+type PurgeTarget struct {
+	RemoteIPs  []string // Entries made from these IP addresses are purged
+	Requesters []string // Entries made by these canonical user IDs or ARNs are purged
+}
+
+// PurgeLogs removes every entry made by the target visitors from the web log objects between the
+// session start and end times. Each object holding such entries is downloaded, stripped of them,
+// and uploaded again in place with the same content type and metadata; objects without them are
+// left alone. The number of lines removed from each object is printed as we go. If dryRun is true,
+// nothing is rewritten but the lines that would have been removed are counted.
+//
+// Rewriting an object in a bucket that has versioning enabled, or suspended, leaves the original
+// content behind as a noncurrent version. If deleteVersions is true, the noncurrent versions of
+// each object in the window are deleted too, including those of objects that an interrupted run
+// rewrote but had yet to clean up after; otherwise a warning is printed. Since purging would be
+// incomplete without knowing which, failing to find out whether the bucket is versioned is an
+// error. If the session has a
+// checkpoint file, the progress made is recorded in it after each object, and an interrupted purge
+// resumes after the last object recorded.
+//
+// An error is returned if there is a problem, otherwise nil.
+func PurgeLogs(session *SlogSession, target PurgeTarget, dryRun, deleteVersions bool) error {
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// Find out whether rewriting an object will leave the purged entries in an old version of it
+	versioned, err := isVersioned(session)
+	if err != nil {
+		return fmt.Errorf("Cannot check whether %s is versioned: %w", session.LogBucket, err)
+	}
+	if versioned && !deleteVersions {
+		fmt.Fprintf(os.Stderr, "Warning: %s is versioned, so the purged entries will survive in noncurrent versions "+
+			"of the objects rewritten; use --delete-versions to delete those too\n", session.LogBucket)
+	}
+	deleteVersions = deleteVersions && versioned
+
+	// Collect the objects in the time window
+	prefix := session.Folder + "/"
+	startAfter := prefix + session.StartDateTime.UTC().Format(keyTimeFormat)
+	endAfter := prefix + session.EndDateTime.UTC().Format(keyTimeFormat)
+
+	// Skip the objects that an interrupted run has already purged
+	err = loadProgress(session)
+	if err != nil {
+		return err
+	}
+	if session.resumeKey > startAfter {
+		startAfter = session.resumeKey
+	}
+	objects := make([]*s3.Object, 0)
+	err = listLogObjects(session, startAfter, endAfter, func(obj *s3.Object) bool {
+		objects = append(objects, obj)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// Purge each in turn, reporting as we go
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	var rewritten, lines int
+	for _, obj := range objects {
+		removed, err := purgeLogObject(session, obj, target, dryRun, deleteVersions)
+		if err != nil {
+			return err
+		}
+		if removed > 0 {
+			fmt.Printf("%s: %s %d lines\n", *obj.Key, verb, removed)
+			rewritten++
+			lines += removed
+		}
+		if !dryRun {
+			err = recordProgress(session, *obj.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("\nTotal: %s %d lines from %d of %d objects\n", verb, lines, rewritten, len(objects))
+
+	// Happy days
+	session.stats.report(os.Stderr)
+	return nil
+}
+
+// purgeLogObject removes the entries made by the target visitors from a single log object,
+// rewriting it only if there were some, and only if this is not a dry run. If deleteVersions is
+// true, the noncurrent versions of the object are deleted, whether left behind by this rewrite
+// or by an earlier one.
+//
+// Returns the number of lines removed, or that would have been, if all goes well, otherwise an error.
+func purgeLogObject(session *SlogSession, obj *s3.Object, target PurgeTarget, dryRun, deleteVersions bool) (int, error) {
+
+	// Fetch the whole object, since we need all of it to put it back
+	var output *s3.GetObjectOutput
+	var data []byte
+	err := retry(session, func() error {
+		var err error
+		output, err = session.s3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(session.LogBucket),
+			Key:    obj.Key,
+		})
+		if err != nil {
+			return err
+		}
+		defer output.Body.Close()
+		data, err = ioutil.ReadAll(output.Body)
+		return err
+	})
+	if err != nil && session.Retry.SkipMissing && isMissing(err) {
+		session.stats.skip(*obj.Key)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Strip out the target's entries, leaving nothing to rewrite if there were none
+	purged, removed := purgeLines(data, target)
+	if dryRun {
+		return removed, nil
+	}
+	if removed == 0 {
+
+		// Though an interrupted run may have rewritten the object before deleting the old versions
+		if deleteVersions {
+			err = deleteNoncurrentVersions(session, aws.StringValue(obj.Key), aws.StringValue(output.VersionId))
+		}
+		return 0, err
+	}
+
+	// Put the object back where we found it, as we found it, less the target's entries
+	var put *s3.PutObjectOutput
+	err = retry(session, func() error {
+		var err error
+		put, err = session.s3.PutObject(&s3.PutObjectInput{
+			Bucket:               aws.String(session.LogBucket),
+			Key:                  obj.Key,
+			Body:                 bytes.NewReader(purged),
+			ContentType:          output.ContentType,
+			ContentEncoding:      output.ContentEncoding,
+			ContentDisposition:   output.ContentDisposition,
+			ContentLanguage:      output.ContentLanguage,
+			CacheControl:         output.CacheControl,
+			Metadata:             output.Metadata,
+			StorageClass:         output.StorageClass,
+			ServerSideEncryption: output.ServerSideEncryption,
+			SSEKMSKeyId:          output.SSEKMSKeyId,
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Nor in the versions that the rewrite has left behind, if asked
+	if deleteVersions {
+		err = deleteNoncurrentVersions(session, aws.StringValue(obj.Key), aws.StringValue(put.VersionId))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Do not leave the purged entries lying around in the cache either
+	if session.Cache != nil {
+		session.Cache.remove(session.LogBucket, obj)
+	}
+	return removed, nil
+}
+
+// isVersioned returns true if the session bucket has versioning enabled, or has had it enabled
+// and since suspended, so that it may hold noncurrent versions of its objects.
+func isVersioned(session *SlogSession) (bool, error) {
+	var output *s3.GetBucketVersioningOutput
+	err := retry(session, func() error {
+		var err error
+		output, err = session.s3.GetBucketVersioning(&s3.GetBucketVersioningInput{
+			Bucket: aws.String(session.LogBucket),
+		})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	status := aws.StringValue(output.Status)
+	return status == s3.BucketVersioningStatusEnabled || status == s3.BucketVersioningStatusSuspended, nil
+}
+
+// deleteNoncurrentVersions deletes every version of the object with the given key in the session
+// bucket other than the current one, which has the given version ID.
+//
+// An error is returned if there is a problem, otherwise nil.
+func deleteNoncurrentVersions(session *SlogSession, key, current string) error {
+
+	// Without the current version ID, the current version could be deleted along with the rest
+	if len(current) == 0 {
+		return fmt.Errorf("Cannot tell the current version of %s from the others", key)
+	}
+
+	// Find the versions, some of which may belong to other keys with the same prefix
+	versions := make([]*s3.ObjectIdentifier, 0)
+	err := retry(session, func() error {
+		versions = versions[:0]
+		return session.s3.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+			Bucket: aws.String(session.LogBucket),
+			Prefix: aws.String(key),
+		}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			versions = append(versions, noncurrentVersions(page, key, current)...)
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// Delete them in batches, as S3 allows
+	for len(versions) > 0 {
+		batch := versions
+		if len(batch) > maxDeleteKeys {
+			batch = batch[:maxDeleteKeys]
+		}
+		versions = versions[len(batch):]
+		var output *s3.DeleteObjectsOutput
+		err = retry(session, func() error {
+			var err error
+			output, err = session.s3.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: aws.String(session.LogBucket),
+				Delete: &s3.Delete{Objects: batch, Quiet: aws.Bool(true)},
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			failure := output.Errors[0]
+			return fmt.Errorf("Failed to delete version %s of %s: %s",
+				aws.StringValue(failure.VersionId), key, aws.StringValue(failure.Message))
+		}
+	}
+	return nil
+}
+
+// noncurrentVersions returns the versions of the object with the given key listed in a page of
+// object versions, other than the current one, which has the given version ID. Delete markers
+// hold no content so are left alone.
+func noncurrentVersions(page *s3.ListObjectVersionsOutput, key, current string) []*s3.ObjectIdentifier {
+	versions := make([]*s3.ObjectIdentifier, 0)
+	for _, v := range page.Versions {
+		if aws.StringValue(v.Key) == key && aws.StringValue(v.VersionId) != current {
+			versions = append(versions, &s3.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+	}
+	return versions
+}
+
+// purgeLines returns the content of a log object without the lines recording entries made by
+// the target visitors, together with the number of lines removed. All other lines, including
+// any that cannot be parsed, are kept exactly as they were.
+func purgeLines(data []byte, target PurgeTarget) ([]byte, int) {
+	purged := make([]byte, 0, len(data))
+	removed := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		entry, err := parseLogEntry(string(bytes.TrimRight(line, "\r\n")))
+		if err == nil && target.matches(entry) {
+			removed++
+			continue
+		}
+		purged = append(purged, line...)
+	}
+	return purged, removed
+}
+
+// matches returns true if the entry was made by one of the target visitors. IP addresses are
+// compared as addresses rather than strings so that IPv6 addresses match however they are written.
+func (t PurgeTarget) matches(entry *LogEntry) bool {
+	if len(entry.Requester) > 0 && stringSliceContains(t.Requesters, entry.Requester) {
+		return true
+	}
+	ip := net.ParseIP(entry.RemoteIP)
+	for _, address := range t.RemoteIPs {
+		if address == entry.RemoteIP || (ip != nil && ip.Equal(net.ParseIP(address))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package s3
+
+// Unit tests for the purging of visitors from the web logs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPurgeLines confirms that only the lines made by the target are removed and that everything
+// else is kept byte for byte
+func TestPurgeLines(t *testing.T) {
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	keep1 := testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "192.0.2.1", key: "index.html", status: 200})
+	drop := testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "203.0.113.7", key: "index.html", status: 200})
+	keep2 := testLogLine(testEntry{bucket: "example.com", time: now, remoteIP: "192.0.2.2", key: "about.html", status: 200})
+	data := []byte(keep1 + "\n" + drop + "\n" + "not a log line\n" + keep2 + "\n")
+
+	purged, removed := purgeLines(data, PurgeTarget{RemoteIPs: []string{"203.0.113.7"}})
+	require.Equal(t, 1, removed, "One line should have been removed")
+	require.Equal(t, keep1+"\nnot a log line\n"+keep2+"\n", string(purged), "The other lines should have been kept as they were")
+
+	// Nothing to remove
+	purged, removed = purgeLines(data, PurgeTarget{RemoteIPs: []string{"198.51.100.1"}})
+	require.Equal(t, 0, removed, "No lines should have been removed")
+	require.Equal(t, string(data), string(purged), "The content should be unchanged")
+
+	// A final line without a newline can be removed too
+	purged, removed = purgeLines([]byte(keep1+"\n"+drop), PurgeTarget{RemoteIPs: []string{"203.0.113.7"}})
+	require.Equal(t, 1, removed, "The final line should have been removed")
+	require.Equal(t, keep1+"\n", string(purged), "The first line should have been kept")
+}
+
+// TestPurgeTargetMatches examines how entries are matched to the visitors being purged
+func TestPurgeTargetMatches(t *testing.T) {
+	target := PurgeTarget{
+		RemoteIPs:  []string{"203.0.113.7", "2001:db8::1"},
+		Requesters: []string{"arn:aws:iam::123456789012:user/alice"},
+	}
+	require.True(t, target.matches(&LogEntry{RemoteIP: "203.0.113.7"}), "IPv4 address should have matched")
+	require.True(t, target.matches(&LogEntry{RemoteIP: "2001:0db8:0000::0001"}), "IPv6 address should have matched however it is written")
+	require.True(t, target.matches(&LogEntry{RemoteIP: "192.0.2.1", Requester: "arn:aws:iam::123456789012:user/alice"}), "Requester should have matched")
+	require.False(t, target.matches(&LogEntry{RemoteIP: "203.0.113.70"}), "A different address should not have matched")
+	require.False(t, target.matches(&LogEntry{RemoteIP: "192.0.2.1"}), "An anonymous request from elsewhere should not have matched")
+
+	// Entries are never matched on an empty requester
+	require.False(t, PurgeTarget{Requesters: []string{""}}.matches(&LogEntry{}), "An empty requester should not have matched")
+}
+
+// TestNoncurrentVersions confirms that only the old versions of the rewritten object itself are
+// picked out for deletion
+func TestNoncurrentVersions(t *testing.T) {
+	key := "root/2020-03-20-13-00-00-AAAA"
+	page := &s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			{Key: aws.String(key), VersionId: aws.String("v3"), IsLatest: aws.Bool(true)},
+			{Key: aws.String(key), VersionId: aws.String("v2")},
+			{Key: aws.String(key), VersionId: aws.String("v1")},
+			{Key: aws.String(key + "-longer"), VersionId: aws.String("v9")},
+		},
+		DeleteMarkers: []*s3.DeleteMarkerEntry{
+			{Key: aws.String(key), VersionId: aws.String("d1")},
+		},
+	}
+	versions := noncurrentVersions(page, key, "v3")
+	require.Equal(t, []*s3.ObjectIdentifier{
+		{Key: aws.String(key), VersionId: aws.String("v2")},
+		{Key: aws.String(key), VersionId: aws.String("v1")},
+	}, versions, "Only the old versions of the object should have been picked out")
+	require.Empty(t, noncurrentVersions(&s3.ListObjectVersionsOutput{}, key, "v3"), "An empty page should pick out nothing")
+}
+
+// purgeTestSession returns a session whose S3 client talks to the given S3 lookalike
+func purgeTestSession(t *testing.T, server *httptest.Server) *SlogSession {
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	})
+	require.Nil(t, err, "failed to create an AWS session")
+	return &SlogSession{LogBucket: "my-logs", Folder: "root", stats: &transferStats{}, s3: s3.New(awsSession)}
+}
+
+// TestPurgeDeletesLeftoverVersions confirms that, when asked to, the noncurrent versions of an
+// object are deleted even if the object has nothing left to purge, as happens when an interrupted
+// run rewrote it but did not get as far as deleting the version that still holds the entries
+func TestPurgeDeletesLeftoverVersions(t *testing.T) {
+	key := "root/2020-03-20-13-00-00-AAAA"
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	content := testLogLine(testEntry{bucket: "example.com", time: now, key: "index.html", status: 200}) + "\n"
+
+	// An S3 lookalike holding a rewritten object, v2, and the original, v1
+	var deleted, rewrites int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("versions") == "" && strings.HasSuffix(r.URL.Path, key):
+			w.Header().Set("x-amz-version-id", "v2")
+			io.WriteString(w, content)
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `<ListVersionsResult><IsTruncated>false</IsTruncated>`+
+				`<Version><Key>%[1]s</Key><VersionId>v2</VersionId><IsLatest>true</IsLatest></Version>`+
+				`<Version><Key>%[1]s</Key><VersionId>v1</VersionId><IsLatest>false</IsLatest></Version>`+
+				`</ListVersionsResult>`, key)
+		case r.Method == http.MethodPost:
+			body, _ := ioutil.ReadAll(r.Body)
+			deleted += strings.Count(string(body), "<VersionId>v1</VersionId>")
+			require.NotContains(t, string(body), "v2", "The current version should not have been deleted")
+			io.WriteString(w, `<DeleteResult></DeleteResult>`)
+		default:
+			rewrites++
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	slogSession := purgeTestSession(t, server)
+	target := PurgeTarget{RemoteIPs: []string{"203.0.113.7"}}
+
+	// A dry run deletes nothing
+	removed, err := purgeLogObject(slogSession, &s3.Object{Key: aws.String(key)}, target, true, true)
+	require.Nil(t, err, "The dry run should not have failed: %v", err)
+	require.Equal(t, 0, removed, "There was nothing to remove")
+	require.Equal(t, 0, deleted, "A dry run should not have deleted anything")
+
+	// A real one deletes the old version, without rewriting the object
+	removed, err = purgeLogObject(slogSession, &s3.Object{Key: aws.String(key)}, target, false, true)
+	require.Nil(t, err, "The purge should not have failed: %v", err)
+	require.Equal(t, 0, removed, "There was nothing to remove")
+	require.Equal(t, 1, deleted, "The noncurrent version should have been deleted")
+	require.Equal(t, 0, rewrites, "The object should not have been rewritten")
+
+	// Unless it cannot tell which version is current
+	err = deleteNoncurrentVersions(slogSession, key, "")
+	require.NotNil(t, err, "Deleting versions without knowing the current one should have been refused")
+	require.Equal(t, 1, deleted, "Nothing more should have been deleted")
+}
+
+// TestPurgeVersioningCheck confirms that a purge does not go ahead if it cannot find out whether
+// the bucket keeps old versions of the objects it would rewrite
+func TestPurgeVersioningCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	}))
+	defer server.Close()
+	err := PurgeLogs(purgeTestSession(t, server), PurgeTarget{RemoteIPs: []string{"203.0.113.7"}}, false, true)
+	require.NotNil(t, err, "The purge should have failed")
+	require.Contains(t, err.Error(), "Cannot check whether my-logs is versioned", "Expected a versioning check error")
+}
@@ -3,117 +3,464 @@ package s3
 // The functions in this file deal with establishing an AWS session
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 var (
 	maxListKeys int64 = 100 // Max number of keys to fetch per page; can be overridden for unit testing
 )
 
+const (
+	maxLineLength = 1 << 20 // The longest log line that we can read; real ones are a few hundred bytes
+)
+
+// logObject is a web log object being read from S3
+type logObject struct {
+	session *SlogSession  // The session for the log bucket folder from which the object came
+	key     string        // The key of the object
+	body    io.ReadCloser // The content of the object, streamed from S3, which the consumer must close
+}
+
+// finalStage is the signature of the function that consumes the log object data at the end
+// of the read pipeline. It must close doneChan once it has processed everything that dataChan
+// delivers or, if a problem occurs, post an error to errChan and return without closing doneChan.
+type finalStage func(session *SlogSession, dataChan <-chan *logObject, doneChan chan<- struct{}, errChan chan<- error)
+
+// pendingLine is a web log line that has been selected for display, waiting to be rendered
+type pendingLine struct {
+	line     string    // The line, anonymized if need be
+	parts    []string  // The line split into words
+	entry    *LogEntry // The parsed line, nil if it did not need parsing
+	botClass string    // The class of bot that made the request, empty for humans or if not classified
+}
+
 // DisplayLog prints the Web logs from the bucket and root path / folder, between
 // the start and end times, defined in the given session structure.
 //
 // An error is returned if there is a proble, otherwise nil.
 func DisplayLog(session *SlogSession) error {
 
+	// Prepare to look up host names if we have been asked to, and if the content will show them
+	if session.Resolve != nil && session.Content != RAW {
+		var err error
+		session.hosts, err = newHostResolver(session.Resolve)
+		if err != nil {
+			return err
+		}
+		defer session.hosts.close()
+	}
+	return runPipeline(session, displayLogData)
+}
+
+// readEntries runs the read pipeline for the web logs defined in the given session structure,
+// parsing each log line and passing those that satisfy the session source bucket filtering to
+// the handle function, which adds them to the given accumulator. Lines that cannot be parsed
+// are skipped. If the session has a checkpoint file, the state of the accumulator is saved in
+// it every so often, and restored from it before reading resumes.
+//
+// An error is returned if there is a problem, including any error returned by handle,
+// otherwise nil.
+func readEntries(session *SlogSession, acc accumulator, handle func(entry *LogEntry) error) error {
+	session.accumulator = acc
+	return runPipeline(session,
+		func(session *SlogSession, dataChan <-chan *logObject, doneChan chan<- struct{}, errChan chan<- error) {
+
+			// Process each object delivered through dataChan, line by line
+			var last *logObject
+			saved := time.Now()
+			for obj := range dataChan {
+				err := forEachLine(obj.body, func(line string) error {
+					if entry := selectEntry(obj.session, line); entry != nil {
+						return handle(entry)
+					}
+					return nil
+				})
+				obj.body.Close()
+
+				// Save what has been accumulated every so often, rather than after every object
+				last = obj
+				if err == nil && time.Since(saved) >= checkpointInterval {
+					err = recordProgress(obj.session, obj.key)
+					saved = time.Now()
+				}
+				if err != nil {
+					postError(session, errChan, err)
+					return
+				}
+			}
+
+			// And once more at the end, so that a finished run need not be repeated
+			if last != nil {
+				err := recordProgress(last.session, last.key)
+				if err != nil {
+					postError(session, errChan, err)
+					return
+				}
+			}
+			close(doneChan)
+		})
+}
+
+// selectEntry parses a log line, returning the entry if it satisfies the session source
+// bucket and bot filtering, or nil if it does not or if the line cannot be parsed.
+func selectEntry(session *SlogSession, line string) *LogEntry {
+
+	// Skip lines that we cannot make sense of
+	entry, err := parseLogEntry(line)
+	if err != nil {
+		return nil
+	}
+
+	// If we are filtering for specified Web site source buckets, skip entries that do not match
+	if !session.buckets.accepts(entry.Bucket) {
+		return nil
+	}
+
+	// Likewise if we are filtering bots in or out
+	if session.bots != nil && !session.BotFilter.accepts(session.bots.classify(entry)) {
+		return nil
+	}
+
+	// Or by country
+	locateEntry(session, entry)
+	if !acceptsCountry(session, entry) {
+		return nil
+	}
+	return entry
+}
+
+// runPipeline lists the log objects between the start and end times defined in the given
+// session structure, downloads them, and hands their content to the final stage function.
+// If the session has several sources, they are listed and downloaded concurrently and their
+// objects handed on in the order of the timestamps in their keys.
+//
+// An error is returned if there is a problem, otherwise nil.
+func runPipeline(session *SlogSession, final finalStage) error {
+
 	// Populate the session with AWS session and client handles
 	err := activateSession(session)
 	if err != nil {
 		return err
 	}
 
-	// Establish the various communicatiomn channels that we will need
-	errChan := make(chan error)                  // Used to signal errors that require the app DisplayLog to terminate
-	keyChan := make(chan string, 5)              // Distributes S3 object keys listed from the log bucket
-	dataChan := make(chan *aws.WriteAtBuffer, 5) // Distributes AWS wrapped byte buffers downloaded from S3 objects
-	doneChan := make(chan struct{})              // Used by the final display function to signal when it is finished
+	// Pick up where a previous, interrupted, run left off
+	err = loadProgress(session)
+	if err != nil {
+		return err
+	}
 
-	// Spin up the function that lists keys from the bucket
-	go fetchLogObjectKeys(session, keyChan, errChan)
+	// Prepare to filter for the source buckets
+	session.buckets, err = newBucketFilter(session.SourceBuckets)
+	if err != nil {
+		return err
+	}
 
-	// Spin up the data fetching function that consumes those keys and pulls down the object content
-	go fetchLogObjectData(session, keyChan, dataChan, errChan)
+	// Prepare to classify bots if we are filtering for them or need to report them
+	if session.BotFilter != ALLTRAFFIC || session.Content == JSON {
+		session.bots, err = newBotDetector(session.BotSignatures)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Prepare to locate visitors if we have the databases to do so
+	if len(session.GeoIPDatabases) > 0 {
+		session.geo, err = openGeoDatabases(session.GeoIPDatabases)
+		if err != nil {
+			return err
+		}
+		defer session.geo.close()
+	}
+
+	// Establish the various communicatiomn channels that we will need
+	errChan := make(chan error)     // Used to signal errors that require the app DisplayLog to terminate
+	doneChan := make(chan struct{}) // Used by the final display function to signal when it is finished
+
+	// Spin up the functions that list and download the objects of each source, merging
+	// them if there are several
+	var dataChan <-chan *logObject
+	if len(session.Sources) == 0 {
+		dataChan = fetchLogObjects(session, errChan)
+	} else {
+		sources := make([]*SlogSession, 0, len(session.Sources))
+		for _, source := range session.Sources {
+			s, err := sourceSession(session, source)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, s)
+		}
+		dataChans := make([]<-chan *logObject, 0, len(sources))
+		for _, source := range sources {
+			dataChans = append(dataChans, fetchLogObjects(source, errChan))
+		}
+		mergedChan := make(chan *logObject, 5)
+		go mergeLogObjects(dataChans, mergedChan)
+		dataChan = mergedChan
+	}
+
+	// Spin up the final stage function that consumes the data
+	go final(session, dataChan, doneChan, errChan)
 
-	// Spin up the data display function
-	go displayLogData(session, dataChan, doneChan, errChan)
+	// Summarize the retries and skipped objects, if there were any, once we are finished
+	defer session.stats.report(os.Stderr)
 
-	// Wait until we are done or see an error
+	// Wait until we are done, see an error, or are cancelled
 	select {
 	case <-doneChan:
 		return nil
-	case err := <-errChan:
-		return err
+	case err = <-errChan:
+	case <-session.Cancel:
+		err = errors.New("Cancelled")
+	}
+
+	// The final stage has given up, leaving any objects queued for it open
+	go closeLogObjects(dataChan)
+	return err
+}
+
+// fetchLogObjects spins up the functions that list the log objects of the session bucket folder
+// and download them, returning the channel through which the downloaded objects are delivered
+func fetchLogObjects(session *SlogSession, errChan chan<- error) <-chan *logObject {
+	keyChan := make(chan *s3.Object, 5)  // Distributes S3 object keys listed from the log bucket
+	dataChan := make(chan *logObject, 5) // Distributes the content of objects downloaded from S3
+	go fetchLogObjectKeys(session, keyChan, errChan)
+	go fetchLogObjectData(session, keyChan, dataChan, errChan)
+	return dataChan
+}
+
+// closeLogObjects closes the body of every object delivered through dataChan until it is closed
+func closeLogObjects(dataChan <-chan *logObject) {
+	for obj := range dataChan {
+		obj.body.Close()
 	}
 }
 
-// fetchLogObjectData listens to keyChan for kyes, downloaads the content of the corresponding
-// S3 objects to in memory buffers, then writes those buffers to dataChan. When keyChan is closed,
+// postError reports an error from one of the pipeline stages to errChan, giving up if the
+// session is cancelled first so that the stage is not left blocked with nobody listening.
+func postError(session *SlogSession, errChan chan<- error, err error) {
+	select {
+	case errChan <- err:
+	case <-session.Cancel:
+	}
+}
+
+// fetchLogObjectData listens to keyChan for keys, opens the content of the corresponding S3
+// objects for streaming, then writes those objects to dataChan. When keyChan is closed,
 // fetchLogObjectData closes dataChan and returns.
 //
 // If a problem occurs, fetchLogObjectData posts an error to errChan and terminates // returns after closing
 // dataChan.
-func fetchLogObjectData(session *SlogSession, keyChan <-chan string, dataChan chan<- *aws.WriteAtBuffer, errChan chan<- error) {
-
-	// Establish a download manager
-	downloader := s3manager.NewDownloaderWithClient(session.s3)
+func fetchLogObjectData(session *SlogSession, keyChan <-chan *s3.Object, dataChan chan<- *logObject, errChan chan<- error) {
 
 	// For all the keys we get through the channel ...
-	for key := range keyChan {
+	for obj := range keyChan {
 
-		// We download to a buffer, not a file, using a buffer writer
-		awsBuff := &aws.WriteAtBuffer{}
+		// Open the object
+		key := *obj.Key
+		body, err := openLogObject(session, obj)
 
-		// Download the object
-		_, err := downloader.Download(awsBuff,
-			&s3.GetObjectInput{
-				Bucket: aws.String(session.LogBucket),
-				Key:    aws.String(key),
-			})
+		// Objects that have gone missing since they were listed can be skipped, if that is what the user wants
+		if err != nil && session.Retry.SkipMissing && isMissing(err) {
+			session.stats.skip(key)
+			continue
+		}
 
 		// If that did not work -- post an error back to our caller
 		// and exit the key reading loop to close the data channel
 		if err != nil {
-			errChan <- err
+			postError(session, errChan, err)
 			break
 		}
 
-		// Send the buffer we just got on down the pipeline
-		dataChan <- awsBuff
+		// Send the object we just opened on down the pipeline, unless we have been cancelled
+		select {
+		case dataChan <- &logObject{session: session, key: key, body: body}:
+		case <-session.Cancel:
+			body.Close()
+			close(dataChan)
+			return
+		}
 	}
 	close(dataChan)
 }
 
-// displayLogData listens to dataChan, rendering the buffers that it receives to the display as lines
+// openLogObject requests the content of a web log object, retrying as the session retry policy
+// allows, and returns its body so that it can be read as it arrives rather than being held in
+// memory all at once. Should the connection fail part way through the body, the rest of the
+// object is requested from where it left off, again as the retry policy allows. The caller must
+// close the body.
+//
+// If the session has a cache, the object is read from there when it can be, and otherwise added
+// to it as it is read from S3.
+//
+// Returns the body if all goes well, otherwise the error reported by S3.
+func openLogObject(session *SlogSession, obj *s3.Object) (io.ReadCloser, error) {
+
+	// We need not bother S3 if we already have the object
+	if session.Cache != nil {
+		if body := session.Cache.open(session.LogBucket, obj); body != nil {
+			return body, nil
+		}
+	}
+
+	// Ask S3 for it then
+	var output *s3.GetObjectOutput
+	err := retry(session, func() error {
+		var err error
+		output, err = session.s3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(session.LogBucket),
+			Key:    obj.Key,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := &resumingBody{session: session, key: obj.Key, size: aws.Int64Value(output.ContentLength),
+		etag: output.ETag, body: output.Body}
+	if session.Cache != nil {
+		return session.Cache.fill(session.LogBucket, obj, body), nil
+	}
+	return body, nil
+}
+
+// resumingBody is the body of a log object being read from S3 which, if reading it fails part way
+// through, requests the rest of the object from the offset reached and carries on from there
+type resumingBody struct {
+	session *SlogSession  // The session reading the object
+	key     *string       // The key of the object
+	size    int64         // The size of the object, zero if S3 did not say
+	etag    *string       // The entity tag of the object, so that we do not resume into a rewritten version
+	body    io.ReadCloser // The body of the latest request for the object
+	offset  int64         // The number of bytes of the object read so far
+	resumes int           // The number of times reading has been resumed
+	failure error         // The error that interrupted the latest body, nil if it has not failed
+}
+
+// Read reads the object, resuming it as the session retry policy allows if the body fails
+func (b *resumingBody) Read(p []byte) (int, error) {
+	for {
+
+		// Pick up where the failed body left off
+		if b.failure != nil {
+			err := b.resume()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		// Read on, remembering any failure so that what came before it can be passed on first
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		// There is nothing to resume if we already have the whole object
+		if b.size > 0 && b.offset >= b.size {
+			return n, io.EOF
+		}
+		b.failure = err
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close closes the body of the latest request for the object
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
+
+// resume requests the object again from the offset reached, returning the failure that
+// interrupted reading if the session retry policy does not allow another try, or the error
+// from S3 if the request fails.
+func (b *resumingBody) resume() error {
+
+	// Give up if we have already tried as often as we may
+	if b.resumes >= b.session.Retry.Retries {
+		return b.failure
+	}
+
+	// Otherwise wait a while, as we would for a failed request, unless we are cancelled
+	b.session.stats.retried()
+	select {
+	case <-time.After(retryWait(b.resumes, b.session.Retry.MaxWait)):
+	case <-b.session.Cancel:
+		return b.failure
+	}
+	b.resumes++
+
+	// Ask for the rest of the object, as long as it has not changed since we started reading it
+	var output *s3.GetObjectOutput
+	err := retry(b.session, func() error {
+		var err error
+		output, err = b.session.s3.GetObject(&s3.GetObjectInput{
+			Bucket:  aws.String(b.session.LogBucket),
+			Key:     b.key,
+			Range:   aws.String(fmt.Sprintf("bytes=%d-", b.offset)),
+			IfMatch: b.etag,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.body.Close()
+	b.body = output.Body
+	b.failure = nil
+	return nil
+}
+
+// displayLogData listens to dataChan, rendering the objects that it receives to the display as lines
 // unitl the channel is closed.
 //
 // Once the end of the data is encountered and displayed, displayLogData closes doneChan to signal
 // that the job is complete.
 //
 // If a problem occurs, displayLogData posts an error to errChan and returns without closing doneChan.
-func displayLogData(session *SlogSession, dataChan <-chan *aws.WriteAtBuffer, doneChan chan<- struct{}, errChan chan<- error) {
+func displayLogData(session *SlogSession, dataChan <-chan *logObject, doneChan chan<- struct{}, errChan chan<- error) {
 
-	// Process each buffer delivered through dataChan
-	for awsBuff := range dataChan {
+	// Process each object delivered through dataChan, as the source it came from dictates
+	shade := false
+	for obj := range dataChan {
+		source := obj.session
+		source.shaded, shade = shade, !shade
 
 		// Displaying raw data requires much less processing than selective log output
-		// so we handle that separately and here, in a tighter loop
-		if session.Content == RAW {
+		// so we handle that separately and here, in a tighter loop, unless we have
+		// been asked to filter source buckets, bots or countries, to anonymize entries, or to label them
+		var err error
+		if source.Content == RAW && source.buckets == nil && source.BotFilter == ALLTRAFFIC &&
+			source.Anonymize == NOANONYMIZE && len(source.Countries) == 0 && len(source.sourceName) == 0 {
 
 			// AWS Web log objects end with a newline character so no need to "Println()"
-			fmt.Print(string(awsBuff.Bytes()))
-			continue
+			_, err = io.Copy(source.output(), obj.body)
+		} else {
+
+			// Not displaying raw log content ...
+			// We have to break up the content and manipulate the lines that it contains
+			err = displaySelectLogData(source, obj.body)
 		}
+		obj.body.Close()
 
-		// Not displaying raw log content ...
-		// We have to break up the buffer and manipulate the lines that it contains
-		err := displaySelectLogData(session, awsBuff)
+		// Now that the whole object has been displayed, we can note that we are past it
+		if err == nil {
+			err = recordProgress(source, obj.key)
+		}
 		if err != nil {
-			errChan <- err
+			postError(session, errChan, err)
 			return
 		}
 	}
@@ -122,46 +469,179 @@ func displayLogData(session *SlogSession, dataChan <-chan *aws.WriteAtBuffer, do
 
 // displaySelectLogData eliminates cruft from the raw AWS web log data and displays a subset of the
 // fields contained in each line, as dictated by the SlogSession.Content value.
-func displaySelectLogData(session *SlogSession, awsBuff *aws.WriteAtBuffer) error {
+//
+// If host names are being looked up, lines are held back a little while the lookups for those
+// that follow them get under way, so that several run at once.
+func displaySelectLogData(session *SlogSession, r io.Reader) error {
+
+	// Loop over the lines of the content, selecting those to display
+	pending := make([]pendingLine, 0, resolveLookahead)
+	err := forEachLine(r, func(line string) error {
+		p, ok := selectLine(session, line)
+		if !ok {
+			return nil
+		}
 
-	// Break the buffer into lines that we can evaluate
-	lines := strings.Split(string(awsBuff.Bytes()), "\n")
+		// Without host names to wait for, there is no reason to hold the line back
+		if session.hosts == nil {
+			return displayLine(session, p)
+		}
+		session.hosts.prefetch(remoteIP(p.parts))
+		pending = append(pending, p)
+		if len(pending) < resolveLookahead {
+			return nil
+		}
+		p, pending = pending[0], pending[1:]
+		return displayLine(session, p)
+	})
+	if err != nil {
+		return err
+	}
 
-	// Loop over the lines, applying the requested treatment
-	for _, line := range lines {
+	// Display whatever is still held back
+	for _, p := range pending {
+		err = displayLine(session, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		// Skip blank lines
-		if len(line) == 0 {
-			continue
+// selectLine applies the session source bucket, bot and country filtering to a web log line,
+// and anonymizes it if need be, returning false if the line is not to be displayed.
+func selectLine(session *SlogSession, line string) (pendingLine, bool) {
+
+	// Split the line into words / fields. This could be problematic since some fields actually contain spaces :-(
+	parts := strings.Split(line, " ")
+
+	// If we are filtering for specified Web site source buckets, skip this line if it does not match
+	if !session.buckets.accepts(parts[1]) {
+		return pendingLine{}, false
+	}
+
+	// Classify the line if we are filtering bots in or out, or need to report them, and
+	// locate it if we are able to
+	botClass := ""
+	var entry *LogEntry
+	if session.bots != nil || session.geo != nil {
+		var err error
+		entry, err = parseLogEntry(line)
+		if err != nil {
+			// Skip lines that we cannot make sense of
+			return pendingLine{}, false
+		}
+	}
+	if session.bots != nil {
+		botClass = session.bots.classify(entry)
+		if !session.BotFilter.accepts(botClass) {
+			return pendingLine{}, false
+		}
+	}
+	if session.geo != nil {
+		locateEntry(session, entry)
+		if !acceptsCountry(session, entry) {
+			return pendingLine{}, false
 		}
+	}
 
-		// Split the line into words / fields. This could be problematic since some fields actually contain spaces :-(
-		parts := strings.Split(line, " ")
+	// Obscure personal details if we have been asked to, before anything is made of the line
+	if session.Anonymize != NOANONYMIZE {
+		line = anonymizeLine(session, line)
+		parts = strings.Split(line, " ")
+		if entry != nil {
+			location := entry.Location
+			entry, _ = parseLogEntry(line)
+			entry.Location = location
+		}
+	}
+	return pendingLine{line: line, parts: parts, entry: entry, botClass: botClass}, true
+}
 
-		// If we are filtering for specified Web site source buckets, skip this line if it does not match
-		if len(session.SourceBuckets) > 0 && !stringSliceContains(session.SourceBuckets, parts[1]) {
-			continue
+// displayLine renders a selected web log line with the fields dictated by the SlogSession.Content
+// value, adding the host name of the remote IP address if host names are being looked up.
+func displayLine(session *SlogSession, p pendingLine) error {
+
+	// Find the host name if we are looking for them
+	line, parts, host := p.line, p.parts, ""
+	if session.hosts != nil {
+		host = session.hosts.host(remoteIP(parts))
+		if session.Content != JSON {
+			parts = append([]string(nil), parts...)
+			parts[4] += " " + orDash(host)
 		}
+	}
 
-		// Process the line based on the content type requested
-		switch session.Content {
-		case BASIC:
-			line = basicContent(parts)
-		case REQUESTID:
-			line = requestIDContent(parts)
-		case BUCKET:
-			line = bucketContent(parts)
-		case RICH:
-			line = richContent(parts)
-		default:
-			return fmt.Errorf("No implementation for content type: %d", session.Content)
+	// Highlight the fields that matter most when reading the display by eye, leaving raw
+	// and JSON content as they are
+	style := ""
+	if session.Color && session.Content != RAW && session.Content != JSON {
+		if session.shaded {
+			style = ansiShade
 		}
+		parts = colorParts(session, parts, style)
+	}
 
-		// Display the treated (or untreated) line
-		fmt.Println(line)
+	// Process the line based on the content type requested
+	switch session.Content {
+	case RAW:
+		// The line is displayed exactly as recorded
+	case JSON:
+		line = jsonContent(jsonEntry{LogEntry: p.entry, BotClass: p.botClass, RemoteHost: host, Source: session.sourceName})
+	case BASIC:
+		line = basicContent(parts)
+	case REQUESTID:
+		line = requestIDContent(parts)
+	case BUCKET:
+		line = bucketContent(parts)
+	case RICH:
+		line = richContent(parts)
+	default:
+		return fmt.Errorf("No implementation for content type: %d", session.Content)
 	}
 
-	return nil
+	// Label the line with its source if there are several, JSON having done so already
+	if len(session.sourceName) > 0 && session.Content != JSON {
+		line = session.sourceName + " " + line
+	}
+	if len(style) > 0 {
+		line = style + line + ansiReset
+	}
+
+	// Display the treated (or untreated) line
+	_, err := fmt.Fprintln(session.output(), line)
+	return err
+}
+
+// remoteIP returns the remote IP address from the words of a web log line, which follows the
+// two words of the timestamp
+func remoteIP(parts []string) string {
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
+// forEachLine reads log data line by line, as it arrives, passing each non-blank line to the
+// handle function. Processing stops at the first error returned by handle or by the reader.
+func forEachLine(r io.Reader, handle func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	for scanner.Scan() {
+
+		// Skip blank lines
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		// Let the handler have its way with the line
+		err := handle(line)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
 // stringSliceContains tests whether a string slice contains a given value
@@ -231,3 +711,20 @@ func richContent(parts []string) string {
 	// Add the parts together and return
 	return part1 + " " + part2
 }
+
+// jsonEntry adds the fields that slog derives from a log entry to those that AWS recorded
+type jsonEntry struct {
+	*LogEntry
+	BotClass   string `json:"bot_class,omitempty"`   // The class of bot that made the request, omitted for humans
+	RemoteHost string `json:"remote_host,omitempty"` // The host name of the remote IP address, omitted if not known
+	Source     string `json:"source,omitempty"`      // The log bucket folder that the entry came from, omitted unless there are several
+}
+
+// jsonContent returns every field of a log entry, plus those that slog derives from it,
+// as a single line JSON object.
+func jsonContent(entry jsonEntry) string {
+
+	// A struct of strings, numbers and times cannot fail to marshal
+	content, _ := json.Marshal(entry)
+	return string(content)
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -117,9 +118,9 @@ func TestMissingLogObject(t *testing.T) {
 
 	// Establish the channels needed to communicate with TestMissingLogObject(..) as
 	// a Go routine (though we will not run it as a Go routine)
-	errChan := make(chan error, 5)               // Used to signal errors that require the app DisplayLog to terminate
-	keyChan := make(chan string, 5)              // Distributes S3 object keys listed from the log bucket
-	dataChan := make(chan *aws.WriteAtBuffer, 5) // Distributes AWS wrapped byte buffers downloaded from S3 objects
+	errChan := make(chan error, 5)       // Used to signal errors that require the app DisplayLog to terminate
+	keyChan := make(chan *s3.Object, 5)  // Distributes S3 object keys listed from the log bucket
+	dataChan := make(chan *logObject, 5) // Distributes the content of objects downloaded from S3
 
 	// Whatever happens with this test, we should not leave any channels open
 	defer func() {
@@ -129,7 +130,7 @@ func TestMissingLogObject(t *testing.T) {
 
 	// Load a key value intto the keyChan that we know will not exist in the bucket.
 	// keyChan is buffered so will not halt waiting for somebody to read from it
-	keyChan <- "I-do-not-exist-2300-12-31"
+	keyChan <- &s3.Object{Key: aws.String("I-do-not-exist-2300-12-31")}
 
 	// The function we are testing should fail quickly so there is no need to spin it
 	// up as a Go routine in its own thread. We log what we are doing to help a little
@@ -249,3 +250,38 @@ func TestReadFilter(t *testing.T) {
 	require.Nil(t, err, "Error capturing log content filtered for invalid bucket name %s: %v", slogSess.SourceBuckets[0], err)
 	require.Equal(t, len(output), 0, "Should have had no content filtering for invalid bucket name %s: %v", knownSourceBucket, err)
 }
+
+// TestForEachLine confirms that log data is split into non-blank lines as it is read, that
+// unusually long lines are handled, and that processing stops at the first error.
+func TestForEachLine(t *testing.T) {
+
+	// Blank lines are skipped and a missing final newline does not lose the last line
+	lines := make([]string, 0)
+	collect := func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}
+	err := forEachLine(strings.NewReader("one\n\ntwo\nthree"), collect)
+	require.Nil(t, err, "Reading lines should not have failed")
+	require.Equal(t, []string{"one", "two", "three"}, lines, "Lines split incorrectly")
+
+	// Lines longer than the default scanner buffer are still read whole
+	lines = lines[:0]
+	long := strings.Repeat("x", 100*1024)
+	err = forEachLine(strings.NewReader(long+"\nshort\n"), collect)
+	require.Nil(t, err, "Reading a long line should not have failed")
+	require.Equal(t, []string{long, "short"}, lines, "Long line split incorrectly")
+
+	// Lines beyond the maximum length are an error
+	err = forEachLine(strings.NewReader(strings.Repeat("x", maxLineLength+1)), collect)
+	require.NotNil(t, err, "A line beyond the maximum length should have been an error")
+
+	// An error from the handler stops the reading
+	count := 0
+	err = forEachLine(strings.NewReader("one\ntwo\nthree\n"), func(line string) error {
+		count++
+		return fmt.Errorf("Stop at %s", line)
+	})
+	require.NotNil(t, err, "The handler error should have been returned")
+	require.Equal(t, 1, count, "Reading should have stopped at the first error")
+}
@@ -0,0 +1,394 @@
+package s3
+
+// The functions in this file produce a traffic report as a single, self
+// contained, HTML file suitable for sharing with people who will never run
+// slog themselves.
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	reportTopCount     = 20  // The number of rows in each of the report's "top" tables
+	reportMaxIntervals = 100 // The most intervals that the report's time charts should have
+	reportChartWidth   = 800 // The width of the report's SVG time charts, in pixels
+	reportChartHeight  = 160 // The height of the report's SVG time charts, in pixels
+)
+
+// reportIntervals are the candidate lengths of the intervals of the report's time charts,
+// shortest first
+var reportIntervals = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// reportCount is a row of one of the report's "top" tables
+type reportCount struct {
+	Name    string  // The thing being counted
+	Count   int64   // The number of requests
+	Percent float64 // The count as a percentage of the largest count in the table
+}
+
+// reportTally counts requests by some attribute of the request
+type reportTally map[string]int64
+
+// reportBar is one bar of an SVG time chart
+type reportBar struct {
+	X, Y, Width, Height int    // The position and size of the bar, in pixels
+	Title               string // The tool tip shown when the bar is hovered over
+}
+
+// reportChart is an SVG bar chart of a time series
+type reportChart struct {
+	Width, Height int         // The size of the chart, in pixels
+	Bars          []reportBar // The bars of the chart
+	First, Last   string      // Labels for the start and end of the time axis
+	Max           int64       // The value of the tallest bar
+}
+
+// trafficReport accumulates everything that goes in to the HTML traffic report
+type trafficReport struct {
+	series     *timeSeries      // Requests, errors and bytes over time
+	pages      reportTally      // Page views by key
+	referrers  reportTally      // Requests by referrer
+	userAgents reportTally      // Requests by user agent
+	statuses   reportTally      // Requests by HTTP status code
+	countries  reportTally      // Requests by country, when visitors can be located
+	notFound   *notFoundTracker // Requests for missing keys
+	requests   int64            // The total number of requests
+	bytes      int64            // The total number of bytes sent
+}
+
+// trafficReportState is the checkpoint of a traffic report
+type trafficReportState struct {
+	Series     *timeSeriesState      `json:"series"`
+	Pages      reportTally           `json:"pages"`
+	Referrers  reportTally           `json:"referrers"`
+	UserAgents reportTally           `json:"user_agents"`
+	Statuses   reportTally           `json:"statuses"`
+	Countries  reportTally           `json:"countries"`
+	NotFound   *notFoundTrackerState `json:"not_found"`
+	Requests   int64                 `json:"requests"`
+	Bytes      int64                 `json:"bytes"`
+}
+
+// reportPage is the data given to the HTML template to render the report
+type reportPage struct {
+	LogBucket  string
+	Start, End string
+	Generated  string
+	Requests   int64
+	Errors     int64
+	Bytes      int64
+	Interval   time.Duration
+	Traffic    reportChart
+	Volume     reportChart
+	Pages      []reportCount
+	Referrers  []reportCount
+	UserAgents []reportCount
+	Statuses   []reportCount
+	Countries  []reportCount
+	NotFound   []reportNotFound
+}
+
+// reportNotFound is a row of the report's table of requests for missing keys
+type reportNotFound struct {
+	Hits     int
+	Key      string
+	Referrer string
+}
+
+// WriteReport reads the web logs defined in the given session structure and writes a traffic
+// report for the time window to the named file as self contained HTML, i.e. with inline styles
+// and SVG charts and no references to external assets. Referrers of requests for missing keys
+// are recognized as internal as they are by DisplayNotFound.
+//
+// An error is returned if there is a problem, otherwise nil.
+func WriteReport(session *SlogSession, outFile string, siteHosts []string) error {
+
+	// Accumulate the report through the whole of the log window
+	report := newTrafficReport(session.StartDateTime, session.EndDateTime, siteHosts)
+	err := readEntries(session, report, func(entry *LogEntry) error {
+		report.add(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Render the report to the file
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(f, report.page(session, time.Now()))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newTrafficReport returns an empty report for the time window from start to end
+func newTrafficReport(start, end time.Time, siteHosts []string) *trafficReport {
+
+	// The report interval always divides the window into few enough intervals
+	series, _ := newTimeSeries(start, end, reportInterval(end.Sub(start)))
+	return &trafficReport{
+		series:     series,
+		pages:      make(reportTally),
+		referrers:  make(reportTally),
+		userAgents: make(reportTally),
+		statuses:   make(reportTally),
+		countries:  make(reportTally),
+		notFound:   newNotFoundTracker(siteHosts),
+	}
+}
+
+// reportInterval returns the shortest of the candidate interval lengths that divides a time
+// window of the given length into no more than reportMaxIntervals intervals
+func reportInterval(window time.Duration) time.Duration {
+	for _, interval := range reportIntervals {
+		if window/interval <= reportMaxIntervals {
+			return interval
+		}
+	}
+	return reportIntervals[len(reportIntervals)-1]
+}
+
+// add accounts for an entry in every part of the report
+func (r *trafficReport) add(entry *LogEntry) {
+	r.requests++
+	r.bytes += entry.BytesSent
+	r.series.add(entry)
+	if isPageView(entry) {
+		r.pages[entry.Key]++
+	}
+	if len(entry.Referrer) > 0 {
+		r.referrers[entry.Referrer]++
+	}
+	r.userAgents[orDash(entry.UserAgent)]++
+	r.statuses[strconv.Itoa(entry.HTTPStatus)]++
+	if entry.Location != nil && len(entry.Location.Country) > 0 {
+		r.countries[entry.Location.Country]++
+	}
+	r.notFound.add(entry)
+}
+
+// save returns the checkpoint of the report
+func (r *trafficReport) save() interface{} {
+	return &trafficReportState{
+		Series:     r.series.state(),
+		Pages:      r.pages,
+		Referrers:  r.referrers,
+		UserAgents: r.userAgents,
+		Statuses:   r.statuses,
+		Countries:  r.countries,
+		NotFound:   r.notFound.state(),
+		Requests:   r.requests,
+		Bytes:      r.bytes,
+	}
+}
+
+// restore replaces everything accumulated in the report with what is recorded in a checkpoint
+func (r *trafficReport) restore(data json.RawMessage) error {
+	state := &trafficReportState{}
+	err := json.Unmarshal(data, state)
+	if err != nil {
+		return err
+	}
+	if state.Series == nil || state.NotFound == nil {
+		return errors.New("the checkpoint was not made by a traffic report")
+	}
+	err = r.series.load(state.Series)
+	if err == nil {
+		err = r.notFound.load(state.NotFound)
+	}
+	if err != nil {
+		return err
+	}
+	for _, tally := range []*reportTally{&state.Pages, &state.Referrers, &state.UserAgents, &state.Statuses, &state.Countries} {
+		if *tally == nil {
+			*tally = make(reportTally)
+		}
+	}
+	r.pages, r.referrers, r.userAgents = state.Pages, state.Referrers, state.UserAgents
+	r.statuses, r.countries = state.Statuses, state.Countries
+	r.requests, r.bytes = state.Requests, state.Bytes
+	return nil
+}
+
+// page assembles the data for the report template
+func (r *trafficReport) page(session *SlogSession, generated time.Time) *reportPage {
+	p := &reportPage{
+		LogBucket:  session.LogBucket,
+		Start:      session.StartDateTime.UTC().Format(time.RFC3339),
+		End:        session.EndDateTime.UTC().Format(time.RFC3339),
+		Generated:  generated.UTC().Format(time.RFC3339),
+		Requests:   r.requests,
+		Bytes:      r.bytes,
+		Interval:   r.series.interval,
+		Pages:      r.pages.top(reportTopCount),
+		Referrers:  r.referrers.top(reportTopCount),
+		UserAgents: r.userAgents.top(reportTopCount),
+		Countries:  r.countries.top(reportTopCount),
+	}
+	for _, b := range r.series.buckets {
+		p.Errors += b.errors
+	}
+
+	// Status codes are few enough to show them all, and make more sense in code order
+	p.Statuses = r.statuses.top(0)
+	sort.Slice(p.Statuses, func(i, j int) bool {
+		return p.Statuses[i].Name < p.Statuses[j].Name
+	})
+	for _, c := range r.notFound.ranked() {
+		if len(p.NotFound) == reportTopCount {
+			break
+		}
+		p.NotFound = append(p.NotFound, reportNotFound{Hits: c.hits, Key: c.key, Referrer: orDash(c.referrer)})
+	}
+
+	// The charts
+	p.Traffic = r.series.chart(func(b *timeBucket) int64 { return b.requests }, "requests")
+	p.Volume = r.series.chart(func(b *timeBucket) int64 { return b.bytes }, "bytes")
+	return p
+}
+
+// top returns the tally's counts in descending order, then in name order, limited to the
+// given number of counts if n is greater than zero
+func (t reportTally) top(n int) []reportCount {
+	counts := make([]reportCount, 0, len(t))
+	var max int64
+	for name, count := range t {
+		counts = append(counts, reportCount{Name: name, Count: count})
+		if count > max {
+			max = count
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	for i := range counts {
+		counts[i].Percent = float64(counts[i].Count) * 100 / float64(max)
+	}
+	return counts
+}
+
+// chart draws one value of each interval of a time series as the bars of an SVG chart
+func (s *timeSeries) chart(value func(*timeBucket) int64, unit string) reportChart {
+	c := reportChart{Width: reportChartWidth, Height: reportChartHeight}
+	if len(s.buckets) == 0 {
+		return c
+	}
+	c.First = s.buckets[0].start.Format(time.RFC3339)
+	c.Last = s.buckets[len(s.buckets)-1].start.Add(s.interval).Format(time.RFC3339)
+	for _, b := range s.buckets {
+		if v := value(b); v > c.Max {
+			c.Max = v
+		}
+	}
+	step := c.Width / len(s.buckets)
+	if step < 1 {
+		step = 1
+	}
+	for i, b := range s.buckets {
+		v := value(b)
+		height := 0
+		if c.Max > 0 {
+			height = int(v * int64(c.Height) / c.Max)
+		}
+		c.Bars = append(c.Bars, reportBar{
+			X:      i * step,
+			Y:      c.Height - height,
+			Width:  step - 1,
+			Height: height,
+			Title:  b.start.Format(time.RFC3339) + ": " + strconv.FormatInt(v, 10) + " " + unit,
+		})
+	}
+	return c
+}
+
+// reportTemplate renders a reportPage as a complete HTML document
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Traffic report for {{.LogBucket}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; margin: 2em auto; max-width: 60em; padding: 0 1em; }
+h1 { font-size: 1.6em; margin-bottom: 0.2em; }
+h2 { font-size: 1.2em; border-bottom: 1px solid #ddd; padding-bottom: 0.2em; margin-top: 2em; }
+.subtitle { color: #666; }
+.summary { display: flex; gap: 1em; flex-wrap: wrap; }
+.summary div { background: #f4f6f8; border-radius: 4px; padding: 0.8em 1.2em; }
+.summary .value { font-size: 1.4em; font-weight: bold; display: block; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { text-align: left; padding: 0.25em 0.5em; vertical-align: top; }
+th { border-bottom: 1px solid #ccc; }
+td.count { text-align: right; width: 6em; }
+td.name { word-break: break-all; }
+td.bar { width: 30%; }
+.bar div { background: #4a7fb5; height: 0.8em; }
+svg rect { fill: #4a7fb5; }
+.empty { color: #999; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>Traffic report for {{.LogBucket}}</h1>
+<p class="subtitle">{{.Start}} to {{.End}}, generated {{.Generated}}</p>
+
+<div class="summary">
+<div><span class="value">{{.Requests}}</span>requests</div>
+<div><span class="value">{{.Errors}}</span>errors</div>
+<div><span class="value">{{.Bytes}}</span>bytes sent</div>
+</div>
+
+<h2>Requests over time</h2>
+{{template "chart" .Traffic}}
+<p class="subtitle">Requests per {{.Interval}}, peak {{.Traffic.Max}}</p>
+
+<h2>Bytes sent over time</h2>
+{{template "chart" .Volume}}
+<p class="subtitle">Bytes per {{.Interval}}, peak {{.Volume.Max}}</p>
+
+<h2>Top pages</h2>
+{{template "counts" .Pages}}
+
+<h2>Top referrers</h2>
+{{template "counts" .Referrers}}
+
+<h2>Top user agents</h2>
+{{template "counts" .UserAgents}}
+
+<h2>Status codes</h2>
+{{template "counts" .Statuses}}
+{{if .Countries}}
+<h2>Top countries</h2>
+{{template "counts" .Countries}}
+{{end}}
+<h2>Missing keys (404s)</h2>
+{{if .NotFound}}<table>
+<tr><th>Hits</th><th>Key</th><th>Referrer</th></tr>
+{{range .NotFound}}<tr><td class="count">{{.Hits}}</td><td class="name">{{.Key}}</td><td class="name">{{.Referrer}}</td></tr>
+{{end}}</table>{{else}}<p class="empty">None</p>{{end}}
+</body>
+</html>
+{{define "chart"}}<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" style="height: auto; max-width: 100%">
+{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Title}}</title></rect>
+{{end}}</svg>
+<table><tr><td class="subtitle">{{.First}}</td><td class="subtitle" style="text-align: right">{{.Last}}</td></tr></table>{{end}}
+{{define "counts"}}{{if .}}<table>
+<tr><th>Requests</th><th></th><th>Name</th></tr>
+{{range .}}<tr><td class="count">{{.Count}}</td><td class="bar"><div style="width: {{printf "%.1f" .Percent}}%"></div></td><td class="name">{{.Name}}</td></tr>
+{{end}}</table>{{else}}<p class="empty">None</p>{{end}}{{end}}
+`))
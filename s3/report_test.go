@@ -0,0 +1,57 @@
+package s3
+
+// Unit tests for the slog HTML traffic report functions
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReportInterval confirms that report charts are given a sensible number of intervals
+func TestReportInterval(t *testing.T) {
+	require.Equal(t, time.Minute, reportInterval(time.Hour), "An hour should be charted by the minute")
+	require.Equal(t, 5*time.Minute, reportInterval(6*time.Hour), "Six hours should be charted in five minute intervals")
+	require.Equal(t, 6*time.Hour, reportInterval(7*24*time.Hour), "A week should be charted in six hour intervals")
+	require.Equal(t, 24*time.Hour, reportInterval(365*24*time.Hour), "A year should be charted by the day")
+}
+
+// TestTrafficReport confirms that the report is assembled and rendered as self contained HTML
+func TestTrafficReport(t *testing.T) {
+
+	// An hour of traffic
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	session := &SlogSession{LogBucket: "my-logs", StartDateTime: start, EndDateTime: start.Add(time.Hour)}
+	report := newTrafficReport(session.StartDateTime, session.EndDateTime, nil)
+	report.add(&LogEntry{Time: start, Operation: "REST.GET.OBJECT", Key: "index.html", HTTPStatus: 200,
+		BytesSent: 1000, Referrer: "https://www.google.com/", UserAgent: "Mozilla/5.0 <script>"})
+	report.add(&LogEntry{Time: start.Add(time.Minute), Operation: "REST.GET.OBJECT", Key: "index.html", HTTPStatus: 304})
+	report.add(&LogEntry{Time: start.Add(2 * time.Minute), Operation: "REST.GET.OBJECT", Key: "missing.html",
+		HTTPStatus: 404, Bucket: "example.com", Referrer: "https://example.com/index.html"})
+
+	// The assembled data
+	page := report.page(session, start.Add(2*time.Hour))
+	require.Equal(t, int64(3), page.Requests, "Request count incorrect")
+	require.Equal(t, int64(1), page.Errors, "Error count incorrect")
+	require.Equal(t, int64(1000), page.Bytes, "Byte count incorrect")
+	require.Equal(t, []reportCount{{Name: "index.html", Count: 2, Percent: 100}}, page.Pages, "Top pages incorrect")
+	require.Equal(t, 3, len(page.Statuses), "Expected three status codes")
+	require.Equal(t, "200", page.Statuses[0].Name, "Status codes should be in code order")
+	require.Equal(t, []reportNotFound{{Hits: 1, Key: "missing.html", Referrer: "https://example.com/index.html"}},
+		page.NotFound, "Missing keys incorrect")
+	require.Equal(t, 60, len(page.Traffic.Bars), "Expected a bar per minute")
+	require.Equal(t, reportChartHeight, page.Traffic.Bars[0].Height, "The busiest interval should fill the chart")
+
+	// The rendered HTML
+	var html bytes.Buffer
+	err := reportTemplate.Execute(&html, page)
+	require.Nil(t, err, "error rendering the report")
+	require.True(t, strings.HasPrefix(html.String(), "<!DOCTYPE html>"), "The report should be an HTML document")
+	require.Contains(t, html.String(), "<svg", "The report should contain SVG charts")
+	require.Contains(t, html.String(), "missing.html", "The report should list the missing keys")
+	require.NotContains(t, html.String(), "<script>", "Log content should be escaped")
+	require.NotContains(t, html.String(), "src=", "The report should not reference external assets")
+}
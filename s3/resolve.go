@@ -0,0 +1,296 @@
+package s3
+
+// The functions in this file look up the host names of the remote IP addresses
+// of web log entries, without letting slow DNS servers hold up the display.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	lookupAddr = net.DefaultResolver.LookupAddr // Looks up the host names of an IP address; can be overridden for unit testing
+)
+
+const (
+	resolveQueueLength = 1000 // The most lookups that may wait for a worker; addresses beyond this are not looked up
+	resolveLookahead   = 64   // The number of lines that the display reads ahead, so that their lookups run concurrently
+)
+
+// ResolvePolicy controls the reverse DNS lookups of the remote IP addresses of web log entries
+type ResolvePolicy struct {
+	Workers   int           // The number of lookups to run at once
+	Rate      int           // The most lookups to start each second; zero for no limit
+	Timeout   time.Duration // The longest that any one lookup may take
+	Budget    time.Duration // The longest that the display may spend waiting for lookups over a whole run
+	TTL       time.Duration // How long the host names found are remembered in the cache file
+	CacheFile string        // Optionally, the name of a file in which to remember the host names found between runs
+}
+
+// hostRecord is what is known of the host name of an IP address
+type hostRecord struct {
+	Host     string    `json:"host"`     // The host name, empty if the address has none
+	Resolved time.Time `json:"resolved"` // When the host name was looked up
+}
+
+// hostResolver looks up the host names of IP addresses with a pool of workers, remembering what it
+// finds so that each address is only looked up once
+type hostResolver struct {
+	policy  *ResolvePolicy           // Controls the lookups
+	mutex   sync.Mutex               // Guards the fields that follow
+	known   map[string]hostRecord    // What is known of each address, by address
+	pending map[string]chan struct{} // Lookups queued or in progress, each closed once done
+	spent   time.Duration            // The time spent waiting for lookups so far
+	queue   chan string              // The addresses waiting to be looked up
+	ctx     context.Context          // Cancelled to abandon the lookups still queued or in progress
+	cancel  context.CancelFunc       // Cancels ctx
+	ticker  *time.Ticker             // Spaces out the start of lookups, nil if they are not rate limited
+	workers sync.WaitGroup           // Tracks the workers, so that we can wait for them to finish
+}
+
+// newHostResolver loads the host names remembered in the policy cache file, if there is one, and
+// starts the workers that look up the rest.
+//
+// Returns the resolver if all goes well, otherwise an error.
+func newHostResolver(policy *ResolvePolicy) (*hostResolver, error) {
+	r := &hostResolver{
+		policy:  policy,
+		known:   make(map[string]hostRecord),
+		pending: make(map[string]chan struct{}),
+		queue:   make(chan string, resolveQueueLength),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.start()
+	return r, nil
+}
+
+// start spins up the workers
+func (r *hostResolver) start() {
+	if r.policy.Rate > 0 {
+		r.ticker = time.NewTicker(time.Second / time.Duration(r.policy.Rate))
+	}
+	workers := r.policy.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	r.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+}
+
+// work looks up the addresses delivered through the queue until the resolver is stopped
+func (r *hostResolver) work() {
+	defer r.workers.Done()
+	for {
+		// Take the next address, unless we have been told to stop
+		var addr string
+		select {
+		case addr = <-r.queue:
+		case <-r.ctx.Done():
+			return
+		}
+
+		// Wait our turn if the lookups are rate limited
+		if r.ticker != nil {
+			select {
+			case <-r.ticker.C:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+
+		// Give the DNS server only so long to answer
+		ctx, cancel := context.WithTimeout(r.ctx, r.policy.Timeout)
+		names, err := lookupAddr(ctx, addr)
+		cancel()
+
+		// Remember the answer, including that there is none, but not a failure to get one
+		r.mutex.Lock()
+		if err == nil || isNotFound(err) {
+			record := hostRecord{Resolved: time.Now()}
+			if err == nil && len(names) > 0 {
+				record.Host = strings.TrimSuffix(names[0], ".")
+			}
+			r.known[addr] = record
+		}
+		close(r.pending[addr])
+		delete(r.pending, addr)
+		r.mutex.Unlock()
+	}
+}
+
+// prefetch queues a lookup of an address, unless its host name is already known or being looked up.
+// It never waits; if the queue is full the address is not looked up.
+func (r *hostResolver) prefetch(addr string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queueLocked(addr)
+}
+
+// queueLocked queues a lookup of an address, if one is needed and there is room, returning the
+// channel that will be closed once the lookup is done or nil if there will be no lookup. The
+// caller must hold the mutex.
+func (r *hostResolver) queueLocked(addr string) chan struct{} {
+	if _, ok := r.known[addr]; ok || net.ParseIP(addr) == nil {
+		return nil
+	}
+	if done, ok := r.pending[addr]; ok {
+		return done
+	}
+	select {
+	case r.queue <- addr:
+		done := make(chan struct{})
+		r.pending[addr] = done
+		return done
+	default:
+		return nil
+	}
+}
+
+// host returns the host name of an address, waiting for it to be looked up if need be, but only
+// for as long as remains of the policy budget. Once the budget is spent, only host names that have
+// already been found are returned.
+//
+// Returns the host name, or an empty string if it is not known.
+func (r *hostResolver) host(addr string) string {
+
+	// Perhaps we already know, or have spent all the time we have to find out
+	r.mutex.Lock()
+	record, ok := r.known[addr]
+	remaining := r.policy.Budget - r.spent
+	var done chan struct{}
+	if !ok && remaining > 0 {
+		done = r.queueLocked(addr)
+	}
+	r.mutex.Unlock()
+	if ok || done == nil {
+		return record.Host
+	}
+
+	// Wait for the lookup, charging the wait to the budget
+	start := time.Now()
+	timer := time.NewTimer(remaining)
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+	timer.Stop()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spent += time.Since(start)
+	return r.known[addr].Host
+}
+
+// close abandons any lookups still queued or in progress, and saves what has been found to the
+// policy cache file. Failing to save the cache is not worth failing a run over, so
+// it is only reported to stderr.
+func (r *hostResolver) close() {
+	r.cancel()
+	r.workers.Wait()
+	if r.ticker != nil {
+		r.ticker.Stop()
+	}
+	err := r.save()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to save host name cache %s: %v\n", r.policy.CacheFile, err)
+	}
+}
+
+// load reads the host names remembered in the policy cache file, ignoring those that have
+// expired. A cache file that does not exist yet is not an error.
+//
+// Returns nil if all goes well, otherwise an error.
+func (r *hostResolver) load() error {
+	if len(r.policy.CacheFile) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(r.policy.CacheFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read host name cache %s: %w", r.policy.CacheFile, err)
+	}
+	records := make(map[string]hostRecord)
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return fmt.Errorf("Unable to read host name cache %s: %w", r.policy.CacheFile, err)
+	}
+	for addr, record := range records {
+		if !r.expired(record) {
+			r.known[addr] = record
+		}
+	}
+	return nil
+}
+
+// save writes the host names that have not expired to the policy cache file, replacing it whole
+// so that a reader never sees it half written.
+//
+// Returns nil if all goes well, otherwise an error.
+func (r *hostResolver) save() error {
+	if len(r.policy.CacheFile) == 0 {
+		return nil
+	}
+
+	// Collect what is still worth remembering
+	r.mutex.Lock()
+	records := make(map[string]hostRecord, len(r.known))
+	for addr, record := range r.known {
+		if !r.expired(record) {
+			records[addr] = record
+		}
+	}
+	r.mutex.Unlock()
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	// Write it alongside the cache file and move it into place
+	dir := filepath.Dir(r.policy.CacheFile)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, cacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), r.policy.CacheFile)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// expired returns true if a host record is older than the policy TTL allows
+func (r *hostResolver) expired(record hostRecord) bool {
+	return time.Since(record.Resolved) > r.policy.TTL
+}
+
+// isNotFound returns true if a lookup failed because the address has no host name, rather than
+// because the DNS server could not be reached or took too long to answer
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
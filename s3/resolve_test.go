@@ -0,0 +1,138 @@
+package s3
+
+// Unit tests for the reverse DNS lookups of remote IP addresses
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testLookupAddr replaces the DNS lookups of the resolver for the duration of a test with
+// lookups in a fixed list, returning a function that counts the lookups made of an address
+// and restores the real lookups
+func testLookupAddr(hosts map[string]string) (func(addr string) int, func()) {
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		mutex.Lock()
+		counts[addr]++
+		mutex.Unlock()
+		if host, ok := hosts[addr]; ok {
+			return []string{host + "."}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	count := func(addr string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return counts[addr]
+	}
+	return count, func() { lookupAddr = net.DefaultResolver.LookupAddr }
+}
+
+// TestHostResolver confirms that host names are looked up once, remembered between runs,
+// and forgotten once they expire
+func TestHostResolver(t *testing.T) {
+	count, restore := testLookupAddr(map[string]string{"192.0.2.3": "www.example.com"})
+	defer restore()
+	dir, err := ioutil.TempDir("", "slog-resolve")
+	require.Nil(t, err, "failed to create a temporary directory")
+	defer os.RemoveAll(dir)
+	policy := &ResolvePolicy{Workers: 2, Timeout: time.Second, Budget: time.Minute, TTL: time.Hour, CacheFile: filepath.Join(dir, "hosts.json")}
+
+	// Look up an address with a host name, twice, and one without
+	r, err := newHostResolver(policy)
+	require.Nil(t, err, "Creating the resolver should not have failed: %v", err)
+	require.Equal(t, "www.example.com", r.host("192.0.2.3"), "Host name found incorrectly")
+	require.Equal(t, "www.example.com", r.host("192.0.2.3"), "Host name remembered incorrectly")
+	require.Equal(t, "", r.host("192.0.2.4"), "An address without a host name should have none")
+	require.Equal(t, "", r.host("192.0.2.4"), "An address without a host name should still have none")
+	require.Equal(t, "", r.host("-"), "Something that is not an address should have no host name")
+	require.Equal(t, 1, count("192.0.2.3"), "An address should only have been looked up once")
+	require.Equal(t, 1, count("192.0.2.4"), "An address without a host name should only have been looked up once")
+	r.close()
+
+	// The next run should not need to look anything up again
+	r, err = newHostResolver(policy)
+	require.Nil(t, err, "Creating the resolver should not have failed: %v", err)
+	require.Equal(t, "www.example.com", r.host("192.0.2.3"), "Host name not loaded from the cache file")
+	require.Equal(t, "", r.host("192.0.2.4"), "Missing host name not loaded from the cache file")
+	require.Equal(t, 1, count("192.0.2.3"), "A cached address should not have been looked up again")
+	r.close()
+
+	// Unless what we know has expired
+	policy.TTL = 0
+	r, err = newHostResolver(policy)
+	require.Nil(t, err, "Creating the resolver should not have failed: %v", err)
+	require.Equal(t, "www.example.com", r.host("192.0.2.3"), "Host name found incorrectly")
+	require.Equal(t, 2, count("192.0.2.3"), "An expired address should have been looked up again")
+	r.close()
+
+	// A cache file that we cannot make sense of is an error
+	require.Nil(t, ioutil.WriteFile(policy.CacheFile, []byte("not json"), 0644), "failed to write the test cache file")
+	_, err = newHostResolver(policy)
+	require.NotNil(t, err, "A corrupt cache file should have been rejected")
+	require.Contains(t, err.Error(), "Unable to read host name cache", "Expected a host name cache error")
+}
+
+// TestHostResolverBudget confirms that slow lookups hold the display up no longer than the budget allows
+func TestHostResolverBudget(t *testing.T) {
+
+	// Lookups that never answer, until they time out
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	defer func() { lookupAddr = net.DefaultResolver.LookupAddr }()
+
+	r, err := newHostResolver(&ResolvePolicy{Workers: 2, Timeout: time.Hour, Budget: 50 * time.Millisecond})
+	require.Nil(t, err, "Creating the resolver should not have failed: %v", err)
+	defer r.close()
+
+	// The first wait uses up the budget, after which we do not wait at all
+	start := time.Now()
+	require.Equal(t, "", r.host("192.0.2.3"), "A lookup that timed out should have no host name")
+	require.True(t, time.Since(start) >= 50*time.Millisecond, "The budget should have been spent waiting")
+	start = time.Now()
+	require.Equal(t, "", r.host("192.0.2.4"), "A lookup beyond the budget should have no host name")
+	require.True(t, time.Since(start) < 50*time.Millisecond, "There should have been no wait beyond the budget")
+}
+
+// TestResolveDisplay confirms that host names are added to the Web log display
+func TestResolveDisplay(t *testing.T) {
+	_, restore := testLookupAddr(map[string]string{"192.0.2.3": "www.example.com"})
+	defer restore()
+	r, err := newHostResolver(&ResolvePolicy{Workers: 2, Timeout: time.Second, Budget: time.Minute})
+	require.Nil(t, err, "Creating the resolver should not have failed: %v", err)
+	defer r.close()
+
+	// The host name follows the address in text content
+	var out bytes.Buffer
+	other := strings.Replace(sampleLogLine, "192.0.2.3", "192.0.2.4", 1)
+	session := &SlogSession{Content: BASIC, Output: &out, hosts: r}
+	err = displaySelectLogData(session, strings.NewReader(sampleLogLine+"\n"+other+"\n"))
+	require.Nil(t, err, "Displaying the log data should not have failed: %v", err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2, "Both lines should have been displayed, in order")
+	require.Contains(t, lines[0], "192.0.2.3 www.example.com ", "Host name should follow the remote IP")
+	require.Contains(t, lines[1], "192.0.2.4 - ", "A missing host name should be shown as a dash")
+
+	// And is a field of its own in JSON content
+	out.Reset()
+	entry, err := parseLogEntry(sampleLogLine)
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	session.Content = JSON
+	err = displayLine(session, pendingLine{line: sampleLogLine, parts: strings.Split(sampleLogLine, " "), entry: entry})
+	require.Nil(t, err, "Displaying the log line should not have failed: %v", err)
+	require.Contains(t, out.String(), `"remote_host":"www.example.com"`, "JSON content should include the host name")
+}
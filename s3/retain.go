@@ -0,0 +1,168 @@
+package s3
+
+// The functions in this file apply retention policies, deleting web log objects
+// that have outlived the age allowed for the bucket folder that holds them.
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	maxDeleteKeys = 1000 // The most keys that S3 will accept in a single DeleteObjects request
+)
+
+// RetentionRule describes how long the web log objects held in a given bucket folder are to be kept.
+type RetentionRule struct {
+	LogBucket string        // The name of the bucket holding the logs
+	Folder    string        // The folder within the bucket to which the rule applies
+	MaxAge    time.Duration // Objects whose key timestamps are older than this are deleted
+}
+
+// RetentionSummary records the effect of applying a RetentionRule.
+type RetentionSummary struct {
+	Rule    RetentionRule // The rule that was applied
+	Objects int           // The number of objects removed, or that would be removed in a dry run
+	Bytes   int64         // The total size of those objects
+}
+
+// ApplyRetention deletes the web log objects that are older than allowed by each of the given
+// rules, printing a summary of the objects and bytes removed from each bucket folder. The age
+// of an object is determined from the timestamp embedded in its key, not from its S3 LastModified
+// value. If dryRun is true, nothing is deleted but the summary reports what would have been.
+//
+// An error is returned if there is a problem, otherwise nil.
+func ApplyRetention(session *SlogSession, rules []RetentionRule, dryRun bool) error {
+
+	// A rule without a positive age would delete everything in its folder
+	for _, rule := range rules {
+		if rule.MaxAge <= 0 {
+			return fmt.Errorf("Invalid retention rule for %s/%s: The age must be greater than zero", rule.LogBucket, rule.Folder)
+		}
+	}
+
+	// Populate the session with AWS session and client handles
+	err := activateSession(session)
+	if err != nil {
+		return err
+	}
+
+	// All of the rules are measured against the same moment
+	now := time.Now()
+
+	// Apply each of the rules in turn, reporting as we go
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, rule := range rules {
+		summary, err := applyRetentionRule(session, rule, now.Add(-rule.MaxAge), dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s/%s: %s %d objects, %d bytes older than %v\n",
+			rule.LogBucket, rule.Folder, verb, summary.Objects, summary.Bytes,
+			now.Add(-rule.MaxAge).UTC().Format(time.RFC3339))
+	}
+
+	// Happy days
+	session.stats.report(os.Stderr)
+	return nil
+}
+
+// applyRetentionRule deletes, or in a dry run only counts, the objects of a single rule's bucket
+// folder with key timestamps earlier than the cutoff time.
+func applyRetentionRule(session *SlogSession, rule RetentionRule, cutoff time.Time, dryRun bool) (*RetentionSummary, error) {
+
+	// Target the rule's bucket and folder with a copy of the session that shares its S3 client
+	ruleSession := *session
+	ruleSession.LogBucket = rule.LogBucket
+	ruleSession.Folder = rule.Folder
+
+	// The timestamps embedded in the keys tell us when to stop listing
+	prefix := rule.Folder + "/"
+	endAfter := prefix + cutoff.UTC().Format(keyTimeFormat)
+
+	// Skip the objects that an interrupted run has already deleted
+	err := loadProgress(&ruleSession)
+	if err != nil {
+		return nil, err
+	}
+
+	// List all of the objects older than the cutoff, deleting them in batches
+	summary := &RetentionSummary{Rule: rule}
+	batch := make([]*s3.ObjectIdentifier, 0, maxDeleteKeys)
+	var deleteErr error
+	err = listLogObjects(&ruleSession, ruleSession.resumeKey, endAfter, func(obj *s3.Object) bool {
+
+		// Ignore anything that is not named like a web log object or that is too young to die
+		keyTime, err := parseKeyTime(prefix, *obj.Key)
+		if err != nil || !keyTime.Before(cutoff) {
+			return true
+		}
+
+		// Count the object and add it to the batch awaiting deletion
+		summary.Objects++
+		summary.Bytes += aws.Int64Value(obj.Size)
+		batch = append(batch, &s3.ObjectIdentifier{Key: obj.Key})
+		if len(batch) == maxDeleteKeys {
+			deleteErr = deleteLogObjects(&ruleSession, batch, dryRun)
+			batch = batch[:0]
+		}
+
+		// Keep going unless the delete request failed
+		return deleteErr == nil
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	if err == nil && len(batch) > 0 {
+		err = deleteLogObjects(&ruleSession, batch, dryRun)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Report what was done
+	return summary, nil
+}
+
+// deleteLogObjects removes a batch of up to maxDeleteKeys objects, in key order, from the session
+// bucket and records the progress made in the session checkpoint, unless this is a dry run, in
+// which case it does nothing at all.
+func deleteLogObjects(session *SlogSession, batch []*s3.ObjectIdentifier, dryRun bool) error {
+
+	// Nothing to do on a dry run
+	if dryRun {
+		return nil
+	}
+
+	// Ask S3 to delete the lot in one go, quietly so that only failures are reported back
+	var output *s3.DeleteObjectsOutput
+	err := retry(session, func() error {
+		var err error
+		output, err = session.s3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(session.LogBucket),
+			Delete: &s3.Delete{
+				Objects: batch,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The request as a whole can succeed while individual deletions fail
+	if len(output.Errors) > 0 {
+		failure := output.Errors[0]
+		return fmt.Errorf("Failed to delete %s and %d other objects: %s",
+			aws.StringValue(failure.Key), len(output.Errors)-1, aws.StringValue(failure.Message))
+	}
+	return recordProgress(session, aws.StringValue(batch[len(batch)-1].Key))
+}
@@ -0,0 +1,128 @@
+package s3
+
+// The functions in this file retry S3 requests that fail for reasons that
+// might clear up by themselves, such as throttling and network hiccups.
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	retryBaseWait = 100 * time.Millisecond // The longest wait before the first retry; doubled for each retry after that
+)
+
+// RetryPolicy controls how S3 requests that fail are retried
+type RetryPolicy struct {
+	Retries     int           // The number of times to retry a request that was throttled or failed transiently
+	MaxWait     time.Duration // The longest to wait before any one retry
+	SkipMissing bool          // When true, log objects that have gone missing are skipped rather than fatal
+}
+
+// transferStats counts the retries and skipped objects of a run, for a summary at its end
+type transferStats struct {
+	mutex   sync.Mutex // Guards the counts, which are updated by several pipeline stages
+	retries int        // The number of requests that were retried
+	skipped []string   // The keys of the log objects that were skipped because they were missing
+}
+
+// retry calls op, which makes an S3 request, until it succeeds, fails for a reason that
+// retrying will not fix, or has been retried as many times as the session retry policy allows.
+// Retries are spaced by exponentially increasing, randomly jittered, waits.
+//
+// Returns the error from the last call of op, nil if it succeeded.
+func retry(session *SlogSession, op func() error) error {
+	for attempt := 0; ; attempt++ {
+
+		// Give up if all is well, or if all is lost
+		err := op()
+		if err == nil || attempt >= session.Retry.Retries || !isRetryable(err) {
+			return err
+		}
+
+		// Wait a while and try again, unless we have been cancelled in the meantime
+		session.stats.retried()
+		select {
+		case <-time.After(retryWait(attempt, session.Retry.MaxWait)):
+		case <-session.Cancel:
+			return err
+		}
+	}
+}
+
+// retryWait returns how long to wait before the given retry attempt, counting from zero. The
+// wait is chosen at random up to a limit that doubles with each attempt, to a maximum of maxWait,
+// so that many clients that were throttled together do not all come back together.
+func retryWait(attempt int, maxWait time.Duration) time.Duration {
+	limit := maxWait
+	if attempt < 32 && retryBaseWait<<uint(attempt) < maxWait {
+		limit = retryBaseWait << uint(attempt)
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)) + 1)
+}
+
+// isRetryable returns true if an S3 request failed for a reason that might clear up by itself,
+// such as throttling, a server error, or a network problem.
+func isRetryable(err error) bool {
+
+	// Some failures will never get better on their own
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NoSuchBucket", "NoSuchKey", "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", request.CanceledErrorCode:
+			return false
+		}
+	}
+
+	// Server errors and throttling are worth another go, as are network errors
+	if rerr, ok := err.(awserr.RequestFailure); ok && (rerr.StatusCode() >= 500 || rerr.StatusCode() == 429) {
+		return true
+	}
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
+
+// isMissing returns true if an S3 request failed because the object that it asked for does not exist
+func isMissing(err error) bool {
+	if rerr, ok := err.(awserr.RequestFailure); ok && rerr.StatusCode() == 404 {
+		return rerr.Code() != "NoSuchBucket"
+	}
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "NoSuchKey"
+}
+
+// retried counts a retry
+func (s *transferStats) retried() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retries++
+}
+
+// skip counts a log object that was skipped because it was missing
+func (s *transferStats) skip(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.skipped = append(s.skipped, key)
+}
+
+// report writes a summary of the retries and skipped objects, if there were any
+func (s *transferStats) report(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.retries > 0 {
+		fmt.Fprintf(w, "Retried %d S3 requests\n", s.retries)
+	}
+	if len(s.skipped) > 0 {
+		fmt.Fprintf(w, "Skipped %d missing log objects:\n", len(s.skipped))
+		for _, key := range s.skipped {
+			fmt.Fprintf(w, "  %s\n", key)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package s3
+
+// Unit tests for the slog S3 request retry functions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIsRetryable confirms that transient failures are retried and permanent ones are not
+func TestIsRetryable(t *testing.T) {
+	require.True(t, isRetryable(awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate", nil), 503, "id")), "SlowDown should be retried")
+	require.True(t, isRetryable(awserr.NewRequestFailure(awserr.New("InternalError", "Oops", nil), 500, "id")), "Server errors should be retried")
+	require.True(t, isRetryable(awserr.New("RequestError", "send request failed", errors.New("connection reset by peer"))), "Network errors should be retried")
+	require.False(t, isRetryable(awserr.NewRequestFailure(awserr.New("NoSuchBucket", "Gone", nil), 404, "id")), "NoSuchBucket should not be retried")
+	require.False(t, isRetryable(awserr.NewRequestFailure(awserr.New("AccessDenied", "No", nil), 403, "id")), "AccessDenied should not be retried")
+	require.False(t, isRetryable(awserr.NewRequestFailure(awserr.New("NoSuchKey", "Gone", nil), 404, "id")), "NoSuchKey should not be retried")
+}
+
+// TestIsMissing confirms that missing objects are told apart from missing buckets
+func TestIsMissing(t *testing.T) {
+	require.True(t, isMissing(awserr.NewRequestFailure(awserr.New("NoSuchKey", "Gone", nil), 404, "id")), "NoSuchKey is missing")
+	require.True(t, isMissing(awserr.New("NoSuchKey", "Gone", nil)), "NoSuchKey without a status is missing")
+	require.False(t, isMissing(awserr.NewRequestFailure(awserr.New("NoSuchBucket", "Gone", nil), 404, "id")), "A missing bucket is not a missing object")
+	require.False(t, isMissing(errors.New("boom")), "Other errors are not missing objects")
+}
+
+// TestRetryWait confirms that waits grow with each attempt but never exceed the maximum
+func TestRetryWait(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		require.True(t, retryWait(0, time.Minute) <= retryBaseWait, "First wait too long")
+		require.True(t, retryWait(3, time.Minute) <= 8*retryBaseWait, "Fourth wait too long")
+		require.True(t, retryWait(40, time.Second) <= time.Second, "Wait should be capped")
+		require.True(t, retryWait(40, time.Second) > 0, "Wait should be positive")
+	}
+	require.Equal(t, time.Duration(0), retryWait(3, 0), "No maximum wait means no wait")
+}
+
+// TestRetry confirms that requests are retried as the policy allows and the retries counted
+func TestRetry(t *testing.T) {
+	throttled := awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate", nil), 503, "id")
+	session := &SlogSession{Retry: RetryPolicy{Retries: 3, MaxWait: time.Millisecond}, stats: &transferStats{}}
+
+	// Succeeds on the third attempt
+	attempts := 0
+	err := retry(session, func() error {
+		attempts++
+		if attempts < 3 {
+			return throttled
+		}
+		return nil
+	})
+	require.Nil(t, err, "retry should have succeeded")
+	require.Equal(t, 3, attempts, "Expected three attempts")
+
+	// Never succeeds
+	attempts = 0
+	err = retry(session, func() error {
+		attempts++
+		return throttled
+	})
+	require.Equal(t, throttled, err, "The last error should have been returned")
+	require.Equal(t, 4, attempts, "Expected the first attempt and three retries")
+
+	// Fails fast
+	attempts = 0
+	denied := awserr.NewRequestFailure(awserr.New("AccessDenied", "No", nil), 403, "id")
+	err = retry(session, func() error {
+		attempts++
+		return denied
+	})
+	require.Equal(t, denied, err, "The error should have been returned")
+	require.Equal(t, 1, attempts, "Access denied should not have been retried")
+
+	// The summary
+	session.stats.skip("root/2020-03-20-13-00-00-AAAA")
+	var summary bytes.Buffer
+	session.stats.report(&summary)
+	require.Equal(t, "Retried 5 S3 requests\nSkipped 1 missing log objects:\n  root/2020-03-20-13-00-00-AAAA\n",
+		summary.String(), "Summary incorrect")
+}
+
+// TestResumingBody confirms that a log object whose connection fails part way through is read
+// on from where it left off, as the retry policy allows, unless the object has changed
+func TestResumingBody(t *testing.T) {
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	content := strings.Repeat(testLogLine(testEntry{bucket: "example.com", time: now, key: "index.html", status: 200})+"\n", 50)
+
+	// An S3 lookalike that hangs up half way through any request for the whole object
+	etag := `"v1"`
+	rewritten := false
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if rewritten && len(r.Header.Get("Range")) > 0 {
+			etag = `"v2"`
+		}
+		if match := r.Header.Get("If-Match"); len(match) > 0 && match != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>No</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if rng := r.Header.Get("Range"); len(rng) > 0 {
+			offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, content[offset:])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		io.WriteString(w, content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	})
+	require.Nil(t, err, "failed to create an AWS session")
+	read := func(retries int) (string, error) {
+		ranges = nil
+		slogSession := &SlogSession{LogBucket: "my-logs", Retry: RetryPolicy{Retries: retries, MaxWait: time.Millisecond},
+			stats: &transferStats{}, s3: s3.New(awsSession)}
+		body, err := openLogObject(slogSession, &s3.Object{Key: aws.String("root/2020-03-20-13-00-00-AAAA")})
+		require.Nil(t, err, "failed to open the log object")
+		defer body.Close()
+		data, err := ioutil.ReadAll(body)
+		return string(data), err
+	}
+
+	// The rest of the object is asked for after the failure
+	data, err := read(2)
+	require.Nil(t, err, "The object should have been read in full: %v", err)
+	require.Equal(t, content, data, "The object content should have been read without gaps or repeats")
+	require.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)}, ranges, "Expected the rest of the object to be requested")
+
+	// Unless the retry policy does not allow it
+	_, err = read(0)
+	require.NotNil(t, err, "The failure should have been passed on without retries")
+	require.Equal(t, []string{""}, ranges, "Nothing more should have been requested")
+
+	// Or the object has been rewritten since
+	rewritten = true
+	_, err = read(2)
+	require.NotNil(t, err, "A rewritten object should not have been resumed")
+	require.Contains(t, err.Error(), "PreconditionFailed", "Expected a failed precondition")
+}
+
+// TestRetriedBucketRequests confirms that the batch deletion of log objects and the lifecycle
+// configuration requests are retried when S3 has a hiccup
+func TestRetriedBucketRequests(t *testing.T) {
+
+	// An S3 lookalike that fails every other request
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `<Error><Code>SlowDown</Code><Message>Slow down</Message></Error>`)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost:
+			io.WriteString(w, `<DeleteResult></DeleteResult>`)
+		case r.Method == http.MethodGet:
+			io.WriteString(w, `<LifecycleConfiguration><Rule><ID>not-ours</ID><Status>Enabled</Status>`+
+				`<Filter><Prefix>tmp/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`)
+		}
+	}))
+	defer server.Close()
+	slogSession := purgeTestSession(t, server)
+	slogSession.Retry = RetryPolicy{Retries: 1, MaxWait: time.Millisecond}
+
+	// Each request gets through at the second attempt
+	err := deleteLogObjects(slogSession, []*s3.ObjectIdentifier{{Key: aws.String("root/2020-03-20-13-00-00-AAAA")}}, false)
+	require.Nil(t, err, "The deletion should have been retried: %v", err)
+	rules, err := getLifecycleRules(slogSession)
+	require.Nil(t, err, "Fetching the lifecycle rules should have been retried: %v", err)
+	require.Equal(t, 1, len(rules), "Expected the one lifecycle rule")
+	err = putLifecycleRules(slogSession, rules)
+	require.Nil(t, err, "Putting the lifecycle rules should have been retried: %v", err)
+	require.Equal(t, 6, requests, "Each request should have been made twice")
+	require.Equal(t, 3, slogSession.stats.retries, "Each request should have been retried once")
+}
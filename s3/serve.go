@@ -0,0 +1,379 @@
+package s3
+
+// The functions in this file serve the web logs to a browser, through a small
+// local UI, and to scripts, through a JSON API.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	serveDefaultWindow = time.Hour // The length of the time window when a log request does not give both ends
+)
+
+// contentTypeNames maps the names by which content types are requested to their values
+var contentTypeNames = map[string]ContentType{
+	"basic":     BASIC,
+	"requestid": REQUESTID,
+	"bucket":    BUCKET,
+	"rich":      RICH,
+	"raw":       RAW,
+	"json":      JSON,
+}
+
+// botFilterNames maps the names by which bot filters are requested to their values
+var botFilterNames = map[string]BotFilter{
+	"all":     ALLTRAFFIC,
+	"exclude": EXCLUDEBOTS,
+	"only":    ONLYBOTS,
+}
+
+// logServer handles the HTTP requests made of slog serve
+type logServer struct {
+	defaults *SlogSession    // The configuration on which that of each request is based
+	hosts    map[string]bool // The host names by which requests may address the server
+}
+
+// flushWriter is a writer that flushes an HTTP response after every write so that log lines
+// reach the client as soon as they are fetched, rather than when the response is complete
+type flushWriter struct {
+	w io.Writer    // The response
+	f http.Flusher // The response, as a flusher
+}
+
+// Serve starts an HTTP server listening on the given address that offers a browser UI for
+// reading the web logs and a JSON API for scripts:
+//
+//	GET /               the browser UI
+//	GET /api/defaults   the region, bucket and path that requests default to, as JSON
+//	GET /api/buckets    the names of the buckets visible with our credentials, as JSON
+//	GET /api/logs       the log entries selected by the query parameters, streamed as they are
+//	                    fetched; as newline delimited JSON for content=json, otherwise as text
+//
+// The log query parameters are bucket, path, start and end (RFC 3339 timestamps), content
+// (one of basic, requestid, bucket, rich, raw and json), source (a source bucket to filter
+// for, may be repeated) and bots (one of all, exclude and only). An omitted bucket or path is
+// taken from the defaults session; omitted times give the hour up to now, or the hour
+// following the start or preceding the end if only one is given.
+//
+// Requests are only answered if their Host header names the listen address, localhost or
+// 127.0.0.1, so that a web page served from elsewhere cannot use DNS rebinding to read the
+// logs through the browser with our credentials.
+//
+// Serve only returns if the server fails, in which case the error is returned.
+func Serve(defaults *SlogSession, listen string) error {
+
+	// The AWS session is shared by all requests
+	err := activateSession(defaults)
+	if err != nil {
+		return err
+	}
+
+	// Off we go
+	fmt.Printf("Serving slog on http://%s/\n", listen)
+	return http.ListenAndServe(listen, newLogServer(defaults, listen))
+}
+
+// newLogServer returns the request router of a server listening on the given address
+func newLogServer(defaults *SlogSession, listen string) http.Handler {
+	s := &logServer{
+		defaults: defaults,
+		hosts:    map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true},
+	}
+	if host, _, err := net.SplitHostPort(listen); err == nil && len(host) > 0 {
+		s.hosts[host] = true
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveUI)
+	mux.HandleFunc("/api/defaults", s.serveDefaults)
+	mux.HandleFunc("/api/buckets", s.serveBuckets)
+	mux.HandleFunc("/api/logs", s.serveLogs)
+	return s.checkHost(mux)
+}
+
+// checkHost wraps a handler so that requests addressed to host names other than those of
+// the server are refused
+func (s *logServer) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !s.hosts[strings.Trim(host, "[]")] {
+			http.Error(w, "Unrecognized host: "+r.Host, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveUI returns the browser UI page
+func (s *logServer) serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, serveUIPage)
+}
+
+// serveDefaults returns the configuration that requests default to
+func (s *logServer) serveDefaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"region": s.defaults.Region,
+		"bucket": s.defaults.LogBucket,
+		"path":   s.defaults.Folder,
+	})
+}
+
+// serveBuckets returns the names of the buckets that can be listed with our credentials
+func (s *logServer) serveBuckets(w http.ResponseWriter, r *http.Request) {
+	output, err := s.defaults.s3.ListBucketsWithContext(r.Context(), &s3.ListBucketsInput{})
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	names := make([]string, 0, len(output.Buckets))
+	for _, bucket := range output.Buckets {
+		names = append(names, *bucket.Name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+// serveLogs streams the log entries selected by the request's query parameters
+func (s *logServer) serveLogs(w http.ResponseWriter, r *http.Request) {
+
+	// Work out what is wanted
+	session, err := s.logSession(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Stream the results, abandoning the run if the client goes away
+	if session.Content == JSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	session.Output = w
+	if f, ok := w.(http.Flusher); ok {
+		session.Output = &flushWriter{w: w, f: f}
+	}
+	session.Cancel = r.Context().Done()
+	err = DisplayLog(session)
+
+	// It is too late for an error status once streaming has begun so report any error in the stream
+	if err != nil && r.Context().Err() == nil {
+		if session.Content == JSON {
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		} else {
+			fmt.Fprintf(w, "Error: %v\n", err)
+		}
+	}
+}
+
+// logSession builds the session for a log request from the defaults and the request's query
+// parameters, returning an error if any of the parameters are invalid
+func (s *logServer) logSession(r *http.Request) (*SlogSession, error) {
+
+	// Start with the defaults, sharing their AWS session
+	query := r.URL.Query()
+	session := &SlogSession{
+		awsSession:    s.defaults.awsSession,
+		s3:            s.defaults.s3,
+		Region:        s.defaults.Region,
+		Endpoint:      s.defaults.Endpoint,
+		LogBucket:     queryValue(query.Get("bucket"), s.defaults.LogBucket),
+		Folder:        queryValue(query.Get("path"), s.defaults.Folder),
+		SourceBuckets: query["source"],
+		BotSignatures: s.defaults.BotSignatures,
+		Retry:         s.defaults.Retry,
+		Cache:         s.defaults.Cache,
+	}
+	if session.SourceBuckets == nil {
+		session.SourceBuckets = make([]string, 0)
+	}
+
+	// There must be a bucket
+	if len(session.LogBucket) == 0 {
+		return nil, errors.New("An S3 bucket name must be provided")
+	}
+
+	// Parse the time window, filling in whichever ends are missing
+	var err error
+	start, end := query.Get("start"), query.Get("end")
+	if len(start) > 0 {
+		session.StartDateTime, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid start date time: %w", err)
+		}
+	}
+	if len(end) > 0 {
+		session.EndDateTime, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid end date time: %w", err)
+		}
+	}
+	switch {
+	case len(start) == 0 && len(end) == 0:
+		session.EndDateTime = time.Now()
+		session.StartDateTime = session.EndDateTime.Add(-serveDefaultWindow)
+	case len(start) == 0:
+		session.StartDateTime = session.EndDateTime.Add(-serveDefaultWindow)
+	case len(end) == 0:
+		session.EndDateTime = session.StartDateTime.Add(serveDefaultWindow)
+	}
+	if !session.EndDateTime.After(session.StartDateTime) {
+		return nil, errors.New("The end date time must be after the start date time")
+	}
+
+	// And the content and filter selections
+	var ok bool
+	if session.Content, ok = contentTypeNames[queryValue(query.Get("content"), "basic")]; !ok {
+		return nil, fmt.Errorf("Unrecognized content type: %s", query.Get("content"))
+	}
+	if session.BotFilter, ok = botFilterNames[queryValue(query.Get("bots"), "all")]; !ok {
+		return nil, fmt.Errorf("Unrecognized bot filter: %s", query.Get("bots"))
+	}
+	return session, nil
+}
+
+// queryValue returns a query parameter value, or the given default if the value is empty
+func queryValue(value, defaultValue string) string {
+	if len(strings.TrimSpace(value)) == 0 {
+		return defaultValue
+	}
+	return strings.TrimSpace(value)
+}
+
+// writeJSON writes a value as the JSON body of a response with the given status code
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Write writes to the response and flushes it
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+// serveUIPage is the browser UI. It is self contained so that slog remains a single binary.
+const serveUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>slog</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; margin: 0; display: flex; flex-direction: column; height: 100vh; }
+form { display: flex; flex-wrap: wrap; gap: 0.6em; align-items: end; padding: 0.8em; background: #f4f6f8; border-bottom: 1px solid #ddd; }
+label { display: flex; flex-direction: column; font-size: 0.8em; color: #555; }
+input, select, button { font-size: 1em; padding: 0.2em 0.4em; }
+#status { padding: 0.3em 0.8em; font-size: 0.8em; color: #666; }
+pre { flex: 1; overflow: auto; margin: 0; padding: 0.8em; font-size: 0.8em; }
+</style>
+</head>
+<body>
+<form id="query">
+<label>Log bucket <input name="bucket" list="buckets" required></label>
+<datalist id="buckets"></datalist>
+<label>Path <input name="path" size="10"></label>
+<label>Start <input name="start" size="22"></label>
+<label>Window
+<select name="window">
+<option value="900">15 minutes</option>
+<option value="3600" selected>1 hour</option>
+<option value="21600">6 hours</option>
+<option value="86400">1 day</option>
+<option value="604800">7 days</option>
+</select></label>
+<label>Content
+<select name="content">
+<option>basic</option><option>requestid</option><option>bucket</option>
+<option>rich</option><option>raw</option><option>json</option>
+</select></label>
+<label>Source buckets <input name="sources" placeholder="comma separated"></label>
+<label>Bots
+<select name="bots">
+<option value="all">include</option><option value="exclude">exclude</option><option value="only">only</option>
+</select></label>
+<button type="submit">Fetch</button>
+<button type="button" id="stop" disabled>Stop</button>
+</form>
+<div id="status"></div>
+<pre id="output"></pre>
+<script>
+const form = document.getElementById("query");
+const output = document.getElementById("output");
+const status = document.getElementById("status");
+const stop = document.getElementById("stop");
+let controller = null;
+
+fetch("api/defaults").then(r => r.json()).then(d => {
+	form.bucket.value = d.bucket || "";
+	form.path.value = d.path || "";
+});
+fetch("api/buckets").then(r => r.json()).then(names => {
+	if (Array.isArray(names)) {
+		document.getElementById("buckets").innerHTML = "";
+		names.forEach(n => { const o = document.createElement("option"); o.value = n; document.getElementById("buckets").appendChild(o); });
+	}
+});
+form.start.value = new Date(Date.now() - 3600000).toISOString().replace(/\.\d+Z$/, "Z");
+
+form.addEventListener("submit", async e => {
+	e.preventDefault();
+	if (controller) controller.abort();
+	controller = new AbortController();
+	const start = new Date(form.start.value);
+	if (isNaN(start)) { status.textContent = "Invalid start date time"; return; }
+	const end = new Date(start.getTime() + form.window.value * 1000);
+	const params = new URLSearchParams({
+		bucket: form.bucket.value, path: form.path.value,
+		start: start.toISOString().replace(/\.\d+Z$/, "Z"), end: end.toISOString().replace(/\.\d+Z$/, "Z"),
+		content: form.content.value, bots: form.bots.value });
+	form.sources.value.split(",").map(s => s.trim()).filter(s => s).forEach(s => params.append("source", s));
+	output.textContent = "";
+	status.textContent = "Fetching...";
+	stop.disabled = false;
+	let lines = 0;
+	try {
+		const response = await fetch("api/logs?" + params, { signal: controller.signal });
+		if (!response.ok) { status.textContent = (await response.json()).error; return; }
+		const reader = response.body.getReader();
+		const decoder = new TextDecoder();
+		for (;;) {
+			const { done, value } = await reader.read();
+			if (done) break;
+			const text = decoder.decode(value, { stream: true });
+			lines += text.split("\n").length - 1;
+			output.appendChild(document.createTextNode(text));
+			status.textContent = "Fetching... " + lines + " lines";
+		}
+		status.textContent = lines + " lines";
+	} catch (err) {
+		status.textContent = err.name === "AbortError" ? "Stopped after " + lines + " lines" : err.message;
+	} finally {
+		stop.disabled = true;
+	}
+});
+stop.addEventListener("click", () => { if (controller) controller.abort(); });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,113 @@
+package s3
+
+// Unit tests for the slog web UI and API server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveTestRequest makes a GET request of a server built on the given defaults, listening on
+// the default address
+func serveTestRequest(defaults *SlogSession, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Host = "127.0.0.1:8080"
+	newLogServer(defaults, "127.0.0.1:8080").ServeHTTP(w, r)
+	return w
+}
+
+// TestServeUI confirms that the UI page, and the defaults that it starts from, are served
+func TestServeUI(t *testing.T) {
+	defaults := &SlogSession{Region: "us-east-1", LogBucket: "my-logs", Folder: "root"}
+
+	// The page
+	w := serveTestRequest(defaults, "/")
+	require.Equal(t, http.StatusOK, w.Code, "The UI should have been served")
+	require.True(t, strings.HasPrefix(w.Body.String(), "<!DOCTYPE html>"), "The UI should be an HTML document")
+	require.Equal(t, http.StatusNotFound, serveTestRequest(defaults, "/nothing").Code, "Unknown paths should not be found")
+
+	// The defaults
+	w = serveTestRequest(defaults, "/api/defaults")
+	require.Equal(t, http.StatusOK, w.Code, "The defaults should have been served")
+	var values map[string]string
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &values), "The defaults should be JSON")
+	require.Equal(t, "my-logs", values["bucket"], "Default bucket incorrect")
+	require.Equal(t, "root", values["path"], "Default path incorrect")
+}
+
+// TestServeLogSession confirms that log request parameters are applied over the defaults
+func TestServeLogSession(t *testing.T) {
+	s := &logServer{defaults: &SlogSession{Region: "us-east-1", LogBucket: "my-logs", Folder: "root"}}
+
+	// Everything given
+	r := httptest.NewRequest(http.MethodGet, "/api/logs?bucket=other-logs&path=web&start=2020-03-20T13:00:00Z"+
+		"&end=2020-03-20T14:00:00Z&content=json&bots=exclude&source=a.com&source=b.com", nil)
+	session, err := s.logSession(r)
+	require.Nil(t, err, "error building the session")
+	require.Equal(t, "other-logs", session.LogBucket, "Bucket incorrect")
+	require.Equal(t, "web", session.Folder, "Path incorrect")
+	require.Equal(t, time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC), session.StartDateTime.UTC(), "Start incorrect")
+	require.Equal(t, time.Hour, session.EndDateTime.Sub(session.StartDateTime), "End incorrect")
+	require.Equal(t, JSON, session.Content, "Content incorrect")
+	require.Equal(t, EXCLUDEBOTS, session.BotFilter, "Bot filter incorrect")
+	require.Equal(t, []string{"a.com", "b.com"}, session.SourceBuckets, "Source buckets incorrect")
+
+	// Nothing given
+	session, err = s.logSession(httptest.NewRequest(http.MethodGet, "/api/logs", nil))
+	require.Nil(t, err, "error building the default session")
+	require.Equal(t, "my-logs", session.LogBucket, "Default bucket incorrect")
+	require.Equal(t, BASIC, session.Content, "Default content incorrect")
+	require.Equal(t, ALLTRAFFIC, session.BotFilter, "Default bot filter incorrect")
+	require.Equal(t, serveDefaultWindow, session.EndDateTime.Sub(session.StartDateTime), "Default window incorrect")
+
+	// Only the start given
+	session, err = s.logSession(httptest.NewRequest(http.MethodGet, "/api/logs?start=2020-03-20T13:00:00Z", nil))
+	require.Nil(t, err, "error building the session from a start time")
+	require.Equal(t, serveDefaultWindow, session.EndDateTime.Sub(session.StartDateTime), "Window from start incorrect")
+}
+
+// TestServeLogErrors confirms that invalid log requests are refused with a JSON error
+func TestServeLogErrors(t *testing.T) {
+	defaults := &SlogSession{Region: "us-east-1", Folder: "root"}
+	for target, message := range map[string]string{
+		"/api/logs":                          "An S3 bucket name must be provided",
+		"/api/logs?bucket=b&start=yesterday": "Invalid start date time",
+		"/api/logs?bucket=b&start=2020-03-20T13:00:00Z&end=2020-03-20T12:00:00Z": "The end date time must be after the start date time",
+		"/api/logs?bucket=b&content=fancy":                                       "Unrecognized content type: fancy",
+		"/api/logs?bucket=b&bots=some":                                           "Unrecognized bot filter: some",
+	} {
+		w := serveTestRequest(defaults, target)
+		require.Equal(t, http.StatusBadRequest, w.Code, "Expected a bad request for %s", target)
+		var body map[string]string
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &body), "The error should be JSON for %s", target)
+		require.Contains(t, body["error"], message, "Unexpected error for %s", target)
+	}
+}
+
+// TestServeHosts confirms that requests addressed to foreign host names are refused, as DNS
+// rebinding would have them be
+func TestServeHosts(t *testing.T) {
+	defaults := &SlogSession{Region: "us-east-1", LogBucket: "my-logs", Folder: "root"}
+	server := newLogServer(defaults, "192.168.1.10:8080")
+	for host, code := range map[string]int{
+		"evil.example.com:8080": http.StatusForbidden,
+		"evil.example.com":      http.StatusForbidden,
+		"192.168.1.10:8080":     http.StatusOK,
+		"localhost:8080":        http.StatusOK,
+		"127.0.0.1:8080":        http.StatusOK,
+		"[::1]:8080":            http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/defaults", nil)
+		r.Host = host
+		server.ServeHTTP(w, r)
+		require.Equal(t, code, w.Code, "Unexpected response to a request for host %s", host)
+	}
+}
@@ -0,0 +1,227 @@
+package s3
+
+// The functions in this file reconstruct the sessions of individual visitors
+// from the entries of the web logs.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// visitorSession describes a run of requests from one visitor without any long pauses
+type visitorSession struct {
+	remoteIP    string    // The visitor's internet address
+	userAgent   string    // The visitor's User-Agent header
+	start       time.Time // The time of the first request of the session
+	end         time.Time // The time of the last request of the session
+	pages       int       // The number of pages, as opposed to images etc, that were requested
+	landingPage string    // The key of the first page requested
+	exitPage    string    // The key of the last page requested
+	referrer    string    // The referrer of the landing page
+}
+
+// sessionTracker groups web log entries into visitor sessions
+type sessionTracker struct {
+	timeout   time.Duration              // The length of inactivity that ends a session
+	open      map[string]*visitorSession // The sessions that might yet see more requests, keyed by visitor
+	closed    []*visitorSession          // The sessions that are known to have ended
+	lastSweep time.Time                  // When we last looked for open sessions that have timed out
+}
+
+// sessionState is the checkpoint of a visitor session
+type sessionState struct {
+	RemoteIP    string    `json:"remote_ip"`
+	UserAgent   string    `json:"user_agent"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Pages       int       `json:"pages"`
+	LandingPage string    `json:"landing_page"`
+	ExitPage    string    `json:"exit_page"`
+	Referrer    string    `json:"referrer"`
+}
+
+// sessionTrackerState is the checkpoint of a session tracker
+type sessionTrackerState struct {
+	Timeout   time.Duration            `json:"timeout"`    // The length of inactivity that ends a session
+	Open      map[string]*sessionState `json:"open"`       // The sessions that might yet see more requests
+	Closed    []*sessionState          `json:"closed"`     // The sessions that are known to have ended
+	LastSweep time.Time                `json:"last_sweep"` // When we last looked for open sessions that have timed out
+}
+
+// DisplaySessions reads the web logs defined in the given session structure and prints a
+// summary of each visitor's sessions. Visitors are identified by their remote IP address and
+// user agent; a visitor's session ends when they make no requests for the timeout period.
+//
+// An error is returned if there is a problem, otherwise nil.
+func DisplaySessions(session *SlogSession, timeout time.Duration) error {
+
+	// Track the sessions through the whole of the log window
+	tracker := newSessionTracker(timeout)
+	err := readEntries(session, tracker, func(entry *LogEntry) error {
+		tracker.add(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Display the sessions in columns
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tDURATION\tPAGES\tLANDING\tEXIT\tREFERRER\tREMOTE IP\tUSER AGENT")
+	for _, s := range tracker.finish() {
+		fmt.Fprintf(w, "%s\t%v\t%d\t%s\t%s\t%s\t%s\t%s\n", s.start.Format(time.RFC3339), s.end.Sub(s.start),
+			s.pages, orDash(s.landingPage), orDash(s.exitPage), orDash(s.referrer), s.remoteIP, orDash(s.userAgent))
+	}
+	return w.Flush()
+}
+
+// newSessionTracker returns a tracker that ends sessions after the given period of inactivity
+func newSessionTracker(timeout time.Duration) *sessionTracker {
+	return &sessionTracker{
+		timeout: timeout,
+		open:    make(map[string]*visitorSession),
+		closed:  make([]*visitorSession, 0),
+	}
+}
+
+// add accounts for a web log entry in the session of the visitor that made the request,
+// starting a new session if the visitor has been inactive for longer than the timeout.
+func (t *sessionTracker) add(entry *LogEntry) {
+
+	// Find the visitor's session, closing it if it has timed out
+	visitor := entry.RemoteIP + " " + entry.UserAgent
+	s := t.open[visitor]
+	if s != nil && entry.Time.Sub(s.end) > t.timeout {
+		t.closed = append(t.closed, s)
+		s = nil
+	}
+	if s == nil {
+		s = &visitorSession{remoteIP: entry.RemoteIP, userAgent: entry.UserAgent, start: entry.Time, end: entry.Time}
+		t.open[visitor] = s
+	}
+
+	// Log entries are not always in strict time order
+	if entry.Time.Before(s.start) {
+		s.start = entry.Time
+	}
+	if entry.Time.After(s.end) {
+		s.end = entry.Time
+	}
+
+	// Count page views
+	if isPageView(entry) {
+		if s.pages == 0 {
+			s.landingPage = entry.Key
+			s.referrer = entry.Referrer
+		}
+		s.exitPage = entry.Key
+		s.pages++
+	}
+
+	// Every so often, close the sessions that have gone quiet so that the open map does
+	// not grow without limit over long log windows
+	if entry.Time.Sub(t.lastSweep) > t.timeout {
+		for visitor, s := range t.open {
+			if entry.Time.Sub(s.end) > t.timeout {
+				t.closed = append(t.closed, s)
+				delete(t.open, visitor)
+			}
+		}
+		t.lastSweep = entry.Time
+	}
+}
+
+// finish closes all of the open sessions and returns the full set in start time order
+func (t *sessionTracker) finish() []*visitorSession {
+	for visitor, s := range t.open {
+		t.closed = append(t.closed, s)
+		delete(t.open, visitor)
+	}
+	sort.SliceStable(t.closed, func(i, j int) bool {
+		return t.closed[i].start.Before(t.closed[j].start)
+	})
+	return t.closed
+}
+
+// save returns the checkpoint of the tracker
+func (t *sessionTracker) save() interface{} {
+	state := &sessionTrackerState{
+		Timeout:   t.timeout,
+		Open:      make(map[string]*sessionState, len(t.open)),
+		Closed:    make([]*sessionState, 0, len(t.closed)),
+		LastSweep: t.lastSweep,
+	}
+	for visitor, s := range t.open {
+		state.Open[visitor] = s.save()
+	}
+	for _, s := range t.closed {
+		state.Closed = append(state.Closed, s.save())
+	}
+	return state
+}
+
+// restore replaces the sessions of the tracker with those of a checkpoint, which must have been
+// made with the same timeout
+func (t *sessionTracker) restore(data json.RawMessage) error {
+	state := &sessionTrackerState{}
+	err := json.Unmarshal(data, state)
+	if err != nil {
+		return err
+	}
+	if state.Timeout != t.timeout {
+		return fmt.Errorf("the checkpoint was made with a session timeout of %v", state.Timeout)
+	}
+	t.open = make(map[string]*visitorSession, len(state.Open))
+	for visitor, s := range state.Open {
+		t.open[visitor] = s.restore()
+	}
+	t.closed = make([]*visitorSession, 0, len(state.Closed))
+	for _, s := range state.Closed {
+		t.closed = append(t.closed, s.restore())
+	}
+	t.lastSweep = state.LastSweep
+	return nil
+}
+
+// save returns the checkpoint of a visitor session
+func (s *visitorSession) save() *sessionState {
+	return &sessionState{RemoteIP: s.remoteIP, UserAgent: s.userAgent, Start: s.start, End: s.end,
+		Pages: s.pages, LandingPage: s.landingPage, ExitPage: s.exitPage, Referrer: s.referrer}
+}
+
+// restore returns the visitor session recorded in a checkpoint
+func (s *sessionState) restore() *visitorSession {
+	return &visitorSession{remoteIP: s.RemoteIP, userAgent: s.UserAgent, start: s.Start, end: s.End,
+		pages: s.Pages, landingPage: s.LandingPage, exitPage: s.ExitPage, referrer: s.Referrer}
+}
+
+// isPageView returns true if a log entry records the successful fetch of a web page, as
+// opposed to an image, script or style sheet etc.
+func isPageView(entry *LogEntry) bool {
+
+	// Only successful object reads count
+	if !strings.HasSuffix(entry.Operation, ".GET.OBJECT") || entry.HTTPStatus >= 400 || len(entry.Key) == 0 {
+		return false
+	}
+
+	// Pages have HTML extensions, or no extension at all when served as index documents
+	switch path.Ext(entry.Key) {
+	case "", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// orDash returns the given string or, if it is empty, a dash as AWS would have recorded it
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
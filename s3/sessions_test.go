@@ -0,0 +1,57 @@
+package s3
+
+// Unit tests for the slog visitor session functions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSessionTracker confirms that entries are grouped into sessions by visitor and inactivity
+func TestSessionTracker(t *testing.T) {
+
+	start := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	tracker := newSessionTracker(30 * time.Minute)
+
+	// One visitor lands on the home page from Google, fetches an image and reads another page
+	tracker.add(&LogEntry{Time: start, RemoteIP: "192.0.2.1", UserAgent: "Firefox", Operation: "WEBSITE.GET.OBJECT",
+		Key: "index.html", HTTPStatus: 200, Referrer: "https://www.google.com/"})
+	tracker.add(&LogEntry{Time: start.Add(time.Second), RemoteIP: "192.0.2.1", UserAgent: "Firefox", Operation: "WEBSITE.GET.OBJECT",
+		Key: "logo.png", HTTPStatus: 200, Referrer: "https://www.example.com/"})
+	tracker.add(&LogEntry{Time: start.Add(5 * time.Minute), RemoteIP: "192.0.2.1", UserAgent: "Firefox", Operation: "WEBSITE.GET.OBJECT",
+		Key: "about/", HTTPStatus: 200, Referrer: "https://www.example.com/"})
+
+	// A second visitor at the same address but with a different browser
+	tracker.add(&LogEntry{Time: start.Add(10 * time.Minute), RemoteIP: "192.0.2.1", UserAgent: "Safari", Operation: "WEBSITE.GET.OBJECT",
+		Key: "blog.html", HTTPStatus: 200})
+
+	// The first visitor returns after a long break
+	tracker.add(&LogEntry{Time: start.Add(2 * time.Hour), RemoteIP: "192.0.2.1", UserAgent: "Firefox", Operation: "WEBSITE.GET.OBJECT",
+		Key: "contact.html", HTTPStatus: 200})
+
+	sessions := tracker.finish()
+	require.Equal(t, 3, len(sessions), "Expected three sessions")
+
+	first := sessions[0]
+	require.Equal(t, "Firefox", first.userAgent, "First session should belong to the Firefox visitor")
+	require.Equal(t, 5*time.Minute, first.end.Sub(first.start), "First session duration incorrect")
+	require.Equal(t, 2, first.pages, "The image should not have been counted as a page")
+	require.Equal(t, "index.html", first.landingPage, "Landing page incorrect")
+	require.Equal(t, "about/", first.exitPage, "Exit page incorrect")
+	require.Equal(t, "https://www.google.com/", first.referrer, "Referrer should be that of the landing page")
+
+	require.Equal(t, "Safari", sessions[1].userAgent, "Second session should belong to the Safari visitor")
+	require.Equal(t, "Firefox", sessions[2].userAgent, "Third session should be the Firefox visitor's return")
+	require.Equal(t, "contact.html", sessions[2].landingPage, "Return visit landing page incorrect")
+}
+
+// TestIsPageView checks which requests count as page views
+func TestIsPageView(t *testing.T) {
+	require.True(t, isPageView(&LogEntry{Operation: "WEBSITE.GET.OBJECT", Key: "index.html", HTTPStatus: 200}), "HTML should be a page")
+	require.True(t, isPageView(&LogEntry{Operation: "REST.GET.OBJECT", Key: "blog/", HTTPStatus: 304}), "A folder should be a page")
+	require.False(t, isPageView(&LogEntry{Operation: "WEBSITE.GET.OBJECT", Key: "logo.png", HTTPStatus: 200}), "An image is not a page")
+	require.False(t, isPageView(&LogEntry{Operation: "WEBSITE.GET.OBJECT", Key: "missing.html", HTTPStatus: 404}), "A failure is not a page")
+	require.False(t, isPageView(&LogEntry{Operation: "REST.PUT.OBJECT", Key: "index.html", HTTPStatus: 200}), "An upload is not a page")
+}
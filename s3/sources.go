@@ -0,0 +1,77 @@
+package s3
+
+// The functions in this file read the web logs of several log bucket folders
+// together, as if they were one.
+
+import (
+	"time"
+)
+
+// LogSource is a log bucket folder to be read along with others
+type LogSource struct {
+	LogBucket     string   // The name of the bucket holding the logs
+	Folder        string   // The name of the folder within the bucket holding the logs
+	SourceBuckets []string // Optionally, the names of Web content source buckets that are to be filtered for in this folder
+}
+
+// Name returns the name by which the source is known in the Web log display, bucket/folder
+func (s LogSource) Name() string {
+	return s.LogBucket + "/" + s.Folder
+}
+
+// sourceSession returns a copy of a session that reads one of its sources. The copy shares
+// the AWS client, bot detector, locator and checkpoint progress of the original.
+//
+// An error is returned if the source bucket patterns of the source are malformed.
+func sourceSession(session *SlogSession, source LogSource) (*SlogSession, error) {
+	s := *session
+	s.LogBucket = source.LogBucket
+	s.Folder = source.Folder
+	s.SourceBuckets = source.SourceBuckets
+	s.Sources = nil
+	s.sourceName = source.Name()
+	s.resumeKey = session.progress[progressID(&s)]
+
+	// Each source filters for its own source buckets
+	var err error
+	s.buckets, err = newBucketFilter(s.SourceBuckets)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// mergeLogObjects passes the objects delivered through each of dataChans on to mergedChan in the
+// order of the timestamps in their keys, which is the order in which AWS wrote them, closing
+// mergedChan once all of dataChans have been closed.
+func mergeLogObjects(dataChans []<-chan *logObject, mergedChan chan<- *logObject) {
+
+	// Wait for the first object from each source, or for it to have none
+	heads := make([]*logObject, len(dataChans))
+	times := make([]time.Time, len(dataChans))
+	next := func(i int) {
+		heads[i] = <-dataChans[i]
+		if heads[i] != nil {
+			times[i], _ = parseKeyTime(heads[i].session.Folder+"/", heads[i].key)
+		}
+	}
+	for i := range dataChans {
+		next(i)
+	}
+
+	// Repeatedly pass on the earliest, replacing it with the next from the same source
+	for {
+		earliest := -1
+		for i, head := range heads {
+			if head != nil && (earliest < 0 || times[i].Before(times[earliest])) {
+				earliest = i
+			}
+		}
+		if earliest < 0 {
+			break
+		}
+		mergedChan <- heads[earliest]
+		next(earliest)
+	}
+	close(mergedChan)
+}
@@ -0,0 +1,113 @@
+package s3
+
+// Unit tests for reading several log bucket folders together
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testLogObjects returns a closed channel delivering log objects with the given keys, each holding
+// the given line
+func testLogObjects(session *SlogSession, line string, keys ...string) <-chan *logObject {
+	dataChan := make(chan *logObject, len(keys))
+	for _, key := range keys {
+		dataChan <- &logObject{session: session, key: key, body: ioutil.NopCloser(strings.NewReader(line + "\n"))}
+	}
+	close(dataChan)
+	return dataChan
+}
+
+// TestSourceSession confirms that a source session reads its own folder, resuming where it left off
+func TestSourceSession(t *testing.T) {
+	session := &SlogSession{
+		LogBucket: "ignored",
+		Content:   RICH,
+		progress:  map[string]string{"log.example.com/blog": "blog/2020-03-20-13-00-00-ABCD"},
+	}
+	source, err := sourceSession(session, LogSource{LogBucket: "log.example.com", Folder: "blog", SourceBuckets: []string{"example.com"}})
+	require.Nil(t, err, "sourceSession should have succeeded: %v", err)
+	require.Equal(t, "log.example.com", source.LogBucket, "Log bucket set incorrectly")
+	require.Equal(t, "blog", source.Folder, "Folder set incorrectly")
+	require.Equal(t, []string{"example.com"}, source.SourceBuckets, "Source buckets set incorrectly")
+	require.Equal(t, "log.example.com/blog", source.sourceName, "Source name set incorrectly")
+	require.Equal(t, "blog/2020-03-20-13-00-00-ABCD", source.resumeKey, "Resume key set incorrectly")
+	require.Equal(t, RICH, source.Content, "The rest of the session should have been copied")
+	require.Equal(t, "ignored", session.LogBucket, "The original session should not have been changed")
+	require.True(t, source.buckets.accepts("example.com"), "The source should filter for its own source buckets")
+	require.False(t, source.buckets.accepts("example.net"), "The source should filter for its own source buckets")
+
+	// Bad patterns are reported
+	_, err = sourceSession(session, LogSource{LogBucket: "log.example.com", Folder: "blog", SourceBuckets: []string{"/(/"}})
+	require.NotNil(t, err, "A malformed source bucket pattern should have been reported")
+}
+
+// TestMergeLogObjects confirms that the objects of several sources are merged in key time order
+func TestMergeLogObjects(t *testing.T) {
+	blog := &SlogSession{Folder: "blog"}
+	shop := &SlogSession{Folder: "shop"}
+	merged := make(chan *logObject)
+	go mergeLogObjects([]<-chan *logObject{
+		testLogObjects(blog, "", "blog/2020-03-20-13-00-00-A", "blog/2020-03-20-13-20-00-B", "blog/2020-03-20-13-40-00-C"),
+		testLogObjects(shop, "", "shop/2020-03-20-13-10-00-D", "shop/2020-03-20-13-30-00-E"),
+		testLogObjects(shop, ""),
+	}, merged)
+
+	keys := make([]string, 0)
+	for obj := range merged {
+		keys = append(keys, obj.key)
+	}
+	require.Equal(t, []string{
+		"blog/2020-03-20-13-00-00-A",
+		"shop/2020-03-20-13-10-00-D",
+		"blog/2020-03-20-13-20-00-B",
+		"shop/2020-03-20-13-30-00-E",
+		"blog/2020-03-20-13-40-00-C",
+	}, keys, "Objects merged in the wrong order")
+}
+
+// TestDisplaySources confirms that each line is labelled with its source, and filtered as that
+// source requires
+func TestDisplaySources(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2020, time.March, 20, 13, 0, 0, 0, time.UTC)
+	session := &SlogSession{Content: BASIC, Output: &out}
+	blog, _ := sourceSession(session, LogSource{LogBucket: "logs", Folder: "blog", SourceBuckets: []string{"blog.example.com"}})
+	shop, _ := sourceSession(session, LogSource{LogBucket: "logs", Folder: "shop"})
+
+	// The blog source only wants entries for its own site
+	merged := make(chan *logObject)
+	go mergeLogObjects([]<-chan *logObject{
+		testLogObjects(blog, testLogLine(testEntry{bucket: "blog.example.com", time: now, remoteIP: "192.0.2.1", status: 200}),
+			"blog/2020-03-20-13-00-00-A"),
+		testLogObjects(blog, testLogLine(testEntry{bucket: "other.example.com", time: now, remoteIP: "192.0.2.2", status: 200}),
+			"blog/2020-03-20-13-05-00-B"),
+		testLogObjects(shop, testLogLine(testEntry{bucket: "shop.example.com", time: now, remoteIP: "192.0.2.3", status: 200}),
+			"shop/2020-03-20-13-10-00-C"),
+	}, merged)
+	doneChan := make(chan struct{})
+	displayLogData(session, merged, doneChan, make(chan error, 1))
+	<-doneChan
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2, "The other site's entry should have been filtered out of the blog source")
+	require.True(t, strings.HasPrefix(lines[0], "logs/blog "), "The blog entry should have been labelled with its source")
+	require.Contains(t, lines[0], "192.0.2.1", "The blog entry should have come first")
+	require.True(t, strings.HasPrefix(lines[1], "logs/shop "), "The shop entry should have been labelled with its source")
+
+	// JSON content has a field for the source instead
+	out.Reset()
+	session.Content = JSON
+	blog, _ = sourceSession(session, LogSource{LogBucket: "logs", Folder: "blog"})
+	entry, err := parseLogEntry(sampleLogLine)
+	require.Nil(t, err, "parseLogEntry should have succeeded: %v", err)
+	err = displayLine(blog, pendingLine{line: sampleLogLine, parts: strings.Split(sampleLogLine, " "), entry: entry})
+	require.Nil(t, err, "Displaying the log line should not have failed: %v", err)
+	require.Contains(t, out.String(), `"source":"logs/blog"`, "JSON content should include the source")
+	require.True(t, strings.HasPrefix(out.String(), "{"), "JSON content should not be prefixed with the source")
+}
@@ -0,0 +1,117 @@
+package s3
+
+// The functions in this file provide the simple statistics shared by the
+// commands that summarize web log data.
+
+import (
+	"math"
+	"sort"
+)
+
+// percentile returns the value at the given percentile (0 to 100) of a slice of values that
+// has already been sorted into ascending order, using the nearest rank method. Zero is
+// returned for an empty slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// percentiles sorts the given values and returns their 50th, 90th and 99th percentiles together
+// with their maximum.
+func percentiles(values []float64) (p50, p90, p99, max float64) {
+	sort.Float64s(values)
+	return percentile(values, 50), percentile(values, 90), percentile(values, 99), percentile(values, 100)
+}
+
+const (
+	sketchGrowth = 1.02 // The ratio of the upper bounds of successive bins of a latency sketch, bounding its error to 2%
+	sketchBins   = 1000 // The most bins that a latency sketch has, enough for timings of over a day in milliseconds
+)
+
+// latencySketch counts timings, in milliseconds, in bins whose upper bounds grow geometrically
+// by sketchGrowth, so that their percentiles can be estimated to within 2% in memory that does
+// not grow with the number of timings. Bin 0 holds timings of zero, bin 1 those of 1ms, bin i
+// those greater than sketchGrowth^(i-2) and no greater than sketchGrowth^(i-1), and the last
+// bin everything greater still.
+type latencySketch struct {
+	counts []int64 // The number of timings in each bin, up to the last bin in use
+	total  int64   // The number of timings
+	max    int64   // The greatest timing, which is kept exactly
+}
+
+// latencySketchState is the checkpoint of a latency sketch
+type latencySketchState struct {
+	Counts []int64 `json:"counts"`
+	Max    int64   `json:"max"`
+}
+
+// add counts a timing
+func (s *latencySketch) add(ms int64) {
+	bin := 0
+	if ms > 0 {
+		bin = 1 + int(math.Ceil(math.Log(float64(ms))/math.Log(sketchGrowth)))
+		if bin >= sketchBins {
+			bin = sketchBins - 1
+		}
+	}
+	for len(s.counts) <= bin {
+		s.counts = append(s.counts, 0)
+	}
+	s.counts[bin]++
+	s.total++
+	if ms > s.max {
+		s.max = ms
+	}
+}
+
+// percentile estimates the timing at the given percentile (0 to 100) using the nearest rank
+// method, as the upper bound of the bin in which that rank falls, but never more than the
+// greatest timing. Zero is returned if there are no timings.
+func (s *latencySketch) percentile(p float64) float64 {
+	if s.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(s.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for bin, count := range s.counts {
+		seen += count
+		switch {
+		case seen < rank:
+			continue
+		case bin == 0:
+			return 0
+		case bin == sketchBins-1:
+			return float64(s.max)
+		}
+		return math.Min(math.Pow(sketchGrowth, float64(bin-1)), float64(s.max))
+	}
+	return float64(s.max)
+}
+
+// percentiles returns the estimated 50th, 90th and 99th percentiles of the timings together with
+// their maximum
+func (s *latencySketch) percentiles() (p50, p90, p99, max float64) {
+	return s.percentile(50), s.percentile(90), s.percentile(99), float64(s.max)
+}
+
+// state returns the checkpoint of the sketch
+func (s *latencySketch) state() *latencySketchState {
+	return &latencySketchState{Counts: s.counts, Max: s.max}
+}
+
+// load replaces the counts of the sketch with those of a checkpoint
+func (s *latencySketch) load(state *latencySketchState) {
+	s.counts, s.total, s.max = state.Counts, 0, state.Max
+	for _, count := range s.counts {
+		s.total += count
+	}
+}